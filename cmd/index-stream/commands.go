@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/mush1e/IndexStream-v2/config"
+	"github.com/mush1e/IndexStream-v2/internal/service"
+)
+
+// subcommands are offline tools that run instead of the HTTP server
+var subcommands = map[string]func(args []string) error{
+	"eval":        runEval,
+	"import-warc": runImportWARC,
+	"index-dir":   runIndexDir,
+}
+
+func runSubcommand(name string, args []string) {
+	cmd, ok := subcommands[name]
+	if !ok {
+		names := make([]string, 0, len(subcommands))
+		for n := range subcommands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "unknown command %q (available: %v)\n", name, names)
+		os.Exit(2)
+	}
+
+	if err := cmd(args); err != nil {
+		log.Fatalf("❌ %s: %v", name, err)
+	}
+}
+
+// snapshotFlags are the flags of commands that read or add to the stored snapshot
+type snapshotFlags struct {
+	dir       *string
+	analyzers *string
+}
+
+func addSnapshotFlags(fs *flag.FlagSet) *snapshotFlags {
+	return &snapshotFlags{
+		dir:       fs.String("snapshot", config.Get().DataURL, "directory the stored pages are kept in"),
+		analyzers: fs.String("analyzers", config.Get().AnalyzersPath, "analysis config documents are analyzed with"),
+	}
+}
+
+// apply loads the analyzers and points stored copies at the snapshot
+// directory, which the server and eval read documents from
+func (f *snapshotFlags) apply() error {
+	if *f.analyzers != "" {
+		if err := service.LoadAnalysisConfig(*f.analyzers); err != nil {
+			return err
+		}
+	}
+	config.Get().DataURL = *f.dir
+	return nil
+}
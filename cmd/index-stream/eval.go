@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mush1e/IndexStream-v2/config"
+	"github.com/mush1e/IndexStream-v2/internal/eval"
+	"github.com/mush1e/IndexStream-v2/internal/service"
+)
+
+// evalConfig is one ranking configuration under evaluation, given on the
+// command line as comma separated key=value pairs, e.g. "name=tuned,k1=1.2,b=0.6"
+type evalConfig struct {
+	Name       string
+	Params     service.BM25Params
+	Engine     bool // rank with SearchEngine.PerformSearch instead of Index.Search
+	BoostExact bool
+}
+
+func parseEvalConfig(spec, defaultName string) (evalConfig, error) {
+	c := evalConfig{
+		Name:       defaultName,
+		Params:     service.DefaultBM25Params(),
+		BoostExact: true,
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return c, fmt.Errorf("invalid config entry %q: expected key=value", pair)
+		}
+
+		var err error
+		switch key {
+		case "name":
+			c.Name = value
+		case "k1":
+			c.Params.K1, err = strconv.ParseFloat(value, 64)
+		case "b":
+			c.Params.B, err = strconv.ParseFloat(value, 64)
+		case "mode":
+			switch value {
+			case "index":
+				c.Engine = false
+			case "engine":
+				c.Engine = true
+			default:
+				err = fmt.Errorf("mode must be 'index' or 'engine'")
+			}
+		case "boost_exact":
+			c.BoostExact, err = strconv.ParseBool(value)
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid config entry %q: %v", pair, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c evalConfig) String() string {
+	mode := "index"
+	if c.Engine {
+		mode = fmt.Sprintf("engine boost_exact=%t", c.BoostExact)
+	}
+	return fmt.Sprintf("k1=%g b=%g mode=%s", c.Params.K1, c.Params.B, mode)
+}
+
+func runEval(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	qrelsPath := fs.String("qrels", "", "TREC qrels file (qid iter docno grade)")
+	queriesPath := fs.String("queries", "", "queries file (qid<TAB>query per line)")
+	snapshot := addSnapshotFlags(fs)
+	k := fs.Int("k", 10, "rank cutoff for NDCG, precision and recall")
+	depth := fs.Int("depth", 100, "number of results retrieved per query")
+	specA := fs.String("a", "name=baseline", "baseline ranking configuration")
+	specB := fs.String("b", "", "candidate ranking configuration to compare against the baseline")
+	label := fs.String("label", "", "label stored in the JSON report, e.g. a commit hash")
+	jsonPath := fs.String("json", "", "write the JSON report to this file")
+	synonyms := fs.String("synonyms", config.Get().SynonymsPath, "synonyms file applied to queries")
+	fs.Parse(args)
+
+	if *qrelsPath == "" || *queriesPath == "" {
+		fs.Usage()
+		return fmt.Errorf("both -qrels and -queries are required")
+	}
+	if *k <= 0 || *depth < *k {
+		return fmt.Errorf("need 0 < k <= depth")
+	}
+
+	judgments, err := eval.LoadQrels(*qrelsPath)
+	if err != nil {
+		return err
+	}
+	queries, err := eval.LoadQueries(*queriesPath)
+	if err != nil {
+		return err
+	}
+
+	configs := []evalConfig{}
+	for i, spec := range []string{*specA, *specB} {
+		if i > 0 && spec == "" {
+			continue
+		}
+		c, err := parseEvalConfig(spec, []string{"a", "b"}[i])
+		if err != nil {
+			return err
+		}
+		configs = append(configs, c)
+	}
+
+	if err := snapshot.apply(); err != nil {
+		return err
+	}
+	if *synonyms != "" {
+		if err := service.Synonyms.Load(*synonyms); err != nil {
+			return err
+		}
+	}
+
+	idx := service.NewUncachedIndex()
+	loaded, err := service.LoadSnapshot(idx, *snapshot.dir)
+	if err != nil {
+		return err
+	}
+	log.Printf("📚 Loaded %d documents from %s", loaded, *snapshot.dir)
+
+	engine := service.NewSearchEngineWithIndex(idx)
+	runs := make([]eval.Run, 0, len(configs))
+
+	for _, c := range configs {
+		idx.SetBM25Params(c.Params)
+		run := eval.Run{
+			Name:     c.Name,
+			Config:   c.String(),
+			PerQuery: make(map[string]eval.Metrics),
+		}
+
+		for _, q := range queries {
+			judged, ok := judgments[q.ID]
+			if !ok {
+				log.Printf("⚠️  Query %s has no judgments, skipping", q.ID)
+				continue
+			}
+
+			var ranking []string
+			if c.Engine {
+				results, err := engine.PerformSearch(q.Text, service.SearchOptions{
+					MaxResults: *depth,
+					BoostExact: c.BoostExact,
+				})
+				if err != nil {
+					log.Printf("⚠️  Query %s failed: %v", q.ID, err)
+				}
+				for _, r := range results {
+					ranking = append(ranking, r.DocID)
+				}
+			} else {
+				for _, r := range idx.Search(q.Text, *depth) {
+					ranking = append(ranking, r.DocID)
+				}
+			}
+
+			run.PerQuery[q.ID] = eval.Compute(ranking, judged, *k)
+		}
+
+		run.Mean = eval.Mean(run.PerQuery)
+		runs = append(runs, run)
+	}
+
+	report := eval.NewReport(*label, *k, *depth, queries, runs)
+	if err := report.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+
+	if *jsonPath != "" {
+		f, err := os.Create(*jsonPath)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", *jsonPath, err)
+		}
+		defer f.Close()
+		if err := report.WriteJSON(f); err != nil {
+			return fmt.Errorf("writing %s: %w", *jsonPath, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/mush1e/IndexStream-v2/internal/service"
+)
+
+// runIndexDir adds a local directory to the stored snapshot, re-extracting
+// only the files that changed since the last run
+func runIndexDir(args []string) error {
+	fs := flag.NewFlagSet("index-dir", flag.ExitOnError)
+	include := fs.String("include", "", "comma separated globs of files to index, e.g. '*.md,*.html'")
+	exclude := fs.String("exclude", "", "comma separated globs of files and directories to skip, e.g. 'vendor,**/testdata'")
+	snapshot := addSnapshotFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: index-stream index-dir [flags] <dir>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one directory")
+	}
+	if err := snapshot.apply(); err != nil {
+		return err
+	}
+
+	opts := service.DirOptions{
+		Include: service.SplitPatterns(*include),
+		Exclude: service.SplitPatterns(*exclude),
+	}
+	stats, err := service.IndexDirectory(nil, fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("📚 %s: %d added, %d updated, %d unchanged, %d removed, %d failed",
+		stats.Root, stats.Added, stats.Updated, stats.Unchanged, stats.Removed, stats.Failed)
+	return nil
+}
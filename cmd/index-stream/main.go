@@ -10,19 +10,65 @@ import (
 	"time"
 
 	"github.com/mush1e/IndexStream-v2/config"
+	"github.com/mush1e/IndexStream-v2/internal/database"
 	"github.com/mush1e/IndexStream-v2/internal/server"
 	"github.com/mush1e/IndexStream-v2/internal/service"
 )
 
 func main() {
+	// Offline tools run instead of the server, e.g. "index-stream eval -qrels ..."
+	if len(os.Args) > 1 {
+		runSubcommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	log.Println("🔍 Starting IndexStream-v2...")
 
 	cfg := config.Get()
 	srv := server.NewServer(cfg)
 
+	// Open the database used for search and click logs
+	db, err := database.NewDB(cfg.DatabaseURL)
+	if err != nil {
+		log.Printf("⚠️  Database unavailable, search and click logs disabled: %v", err)
+	} else {
+		service.SetDatabase(db)
+
+		// Poll subscribed feeds as they come due
+		go service.WatchFeeds(time.Minute)
+	}
+
+	// Load ranking profiles and experiments
+	if cfg.RankingProfilesPath != "" {
+		if err := service.LoadRankingConfig(cfg.RankingProfilesPath); err != nil {
+			log.Printf("⚠️  Failed to load ranking profiles: %v", err)
+		}
+	}
+
+	// Fold expired experiment impressions into the saved totals
+	go service.Experiments.Watch(time.Minute)
+
+	// Load analyzers before anything is indexed or searched
+	if cfg.AnalyzersPath != "" {
+		if err := service.LoadAnalysisConfig(cfg.AnalyzersPath); err != nil {
+			log.Fatalf("❌ Failed to load analyzers: %v", err)
+		}
+	}
+
+	// Load query-time synonyms and pick up edits to the file
+	if cfg.SynonymsPath != "" {
+		if err := service.Synonyms.Load(cfg.SynonymsPath); err != nil {
+			log.Printf("⚠️  Failed to load synonyms: %v", err)
+		}
+		go service.Synonyms.Watch(cfg.SynonymsPath, 30*time.Second)
+	}
+
 	// Start the text extraction service
 	go service.ExtractText()
 
+	// Resume crawls left in the frontier
+	service.StartFrontier()
+
 	// Prewarm cache on startup (after a brief delay)
 	go func() {
 		time.Sleep(2 * time.Second)
@@ -56,9 +102,14 @@ func main() {
 		log.Printf("⚠️  Server forced to shutdown: %v", err)
 	}
 
+	// Stop crawling; queued URLs are fetched after the next start
+	log.Println("🔄 Stopping crawler...")
+	service.StopFrontier(ctx)
+
 	// Shutdown text extractor
 	log.Println("🔄 Shutting down text extractor...")
 	service.ShutdownExtractor()
+	service.CloseCrawlArchive()
 
 	// Print final statistics
 	stats := service.InvertedIndex.GetIndexStats()
@@ -73,5 +124,10 @@ func main() {
 		log.Printf("   L1 Cache: %v", cacheInfo)
 	}
 
+	if db != nil {
+		service.Experiments.Flush()
+		db.Close()
+	}
+
 	log.Println("✅ Server gracefully stopped")
 }
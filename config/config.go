@@ -2,8 +2,10 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 )
 
 var (
@@ -15,6 +17,62 @@ type Config struct {
 	Port        int
 	DataURL     string
 	SearchDepth int
+	DatabaseURL string
+	ClickBoost  float64
+
+	// JSON file with ranking profiles and experiments
+	RankingProfilesPath string
+
+	// JSON file defining named analyzers and their field assignment
+	AnalyzersPath string
+
+	// Solr-format synonyms file and the weight given to expanded terms
+	SynonymsPath  string
+	SynonymWeight float64
+
+	// Directories that POST /crawl may index through file:// URLs, none by default
+	LocalSourceRoots []string
+
+	// Largest request body accepted by POST /documents, and largest line of
+	// POST /documents/_bulk
+	MaxDocumentBytes int64
+
+	// Largest request body accepted by POST /documents/_bulk
+	MaxBulkBytes int64
+
+	// Directory the crawler archives fetched responses to as WARC files, and
+	// the size at which it starts a new file. Archiving is off when unset.
+	WARCDir      string
+	WARCMaxBytes int64
+
+	// Whether crawls also fetch the pages in the seed site's sitemaps, and how
+	// many sitemap pages a crawl takes at most, 0 for no limit
+	DiscoverSitemaps bool
+	SitemapMaxURLs   int
+
+	// User agent the crawler identifies itself with; robots.txt groups are
+	// matched against its product token, the part before the first "/"
+	UserAgent string
+
+	// How long a site's robots.txt is cached
+	RobotsCacheTTL time.Duration
+
+	// How long a crawled URL is left out of later crawls that find it again
+	RecrawlInterval time.Duration
+
+	// Politeness towards each host: how many requests may be in flight to it
+	// and the least time between their starts. A longer robots.txt
+	// Crawl-delay wins.
+	CrawlHostConcurrency int
+	CrawlHostDelay       time.Duration
+
+	// Crawler-wide limits on requests per second and body bytes read per
+	// second, 0 for none
+	CrawlMaxRPS         float64
+	CrawlMaxBytesPerSec int64
+
+	// How often feeds subscribed without an interval of their own are polled
+	FeedPollInterval time.Duration
 }
 
 func load() *Config {
@@ -22,6 +80,26 @@ func load() *Config {
 		Port:        8080,
 		DataURL:     "./data/webpages",
 		SearchDepth: 2,
+
+		SynonymWeight: 0.5,
+
+		MaxDocumentBytes: 10 << 20,
+		MaxBulkBytes:     100 << 20,
+
+		WARCMaxBytes: 1 << 30,
+
+		DiscoverSitemaps: true,
+		SitemapMaxURLs:   1000,
+
+		UserAgent:      "IndexStream/2.0 (+https://github.com/mush1e/IndexStream-v2)",
+		RobotsCacheTTL: 24 * time.Hour,
+
+		RecrawlInterval: 24 * time.Hour,
+
+		CrawlHostConcurrency: 2,
+		CrawlHostDelay:       500 * time.Millisecond,
+
+		FeedPollInterval: time.Hour,
 	}
 
 	if port, err := strconv.Atoi(os.Getenv("PORT")); err == nil {
@@ -36,6 +114,91 @@ func load() *Config {
 		cfg.SearchDepth = depth
 	}
 
+	if databaseURL := os.Getenv("DATABASE_URL"); databaseURL != "" {
+		cfg.DatabaseURL = databaseURL
+	}
+
+	// Weight of the click-model boost applied during ranking, 0 disables it
+	if boost, err := strconv.ParseFloat(os.Getenv("CLICK_BOOST"), 64); err == nil {
+		cfg.ClickBoost = boost
+	}
+
+	if profilesPath := os.Getenv("RANKING_PROFILES"); profilesPath != "" {
+		cfg.RankingProfilesPath = profilesPath
+	}
+
+	if analyzersPath := os.Getenv("ANALYZERS_CONFIG"); analyzersPath != "" {
+		cfg.AnalyzersPath = analyzersPath
+	}
+
+	if synonymsPath := os.Getenv("SYNONYMS_PATH"); synonymsPath != "" {
+		cfg.SynonymsPath = synonymsPath
+	}
+
+	if weight, err := strconv.ParseFloat(os.Getenv("SYNONYM_WEIGHT"), 64); err == nil {
+		cfg.SynonymWeight = weight
+	}
+
+	if maxBytes, err := strconv.ParseInt(os.Getenv("MAX_DOCUMENT_BYTES"), 10, 64); err == nil && maxBytes > 0 {
+		cfg.MaxDocumentBytes = maxBytes
+	}
+
+	if maxBytes, err := strconv.ParseInt(os.Getenv("MAX_BULK_BYTES"), 10, 64); err == nil && maxBytes > 0 {
+		cfg.MaxBulkBytes = maxBytes
+	}
+
+	if warcDir := os.Getenv("WARC_DIR"); warcDir != "" {
+		cfg.WARCDir = warcDir
+	}
+
+	if maxBytes, err := strconv.ParseInt(os.Getenv("WARC_MAX_BYTES"), 10, 64); err == nil && maxBytes > 0 {
+		cfg.WARCMaxBytes = maxBytes
+	}
+
+	if discover, err := strconv.ParseBool(os.Getenv("DISCOVER_SITEMAPS")); err == nil {
+		cfg.DiscoverSitemaps = discover
+	}
+
+	if maxURLs, err := strconv.Atoi(os.Getenv("SITEMAP_MAX_URLS")); err == nil && maxURLs >= 0 {
+		cfg.SitemapMaxURLs = maxURLs
+	}
+
+	if userAgent := os.Getenv("USER_AGENT"); userAgent != "" {
+		cfg.UserAgent = userAgent
+	}
+
+	if ttl, err := time.ParseDuration(os.Getenv("ROBOTS_CACHE_TTL")); err == nil && ttl > 0 {
+		cfg.RobotsCacheTTL = ttl
+	}
+
+	if interval, err := time.ParseDuration(os.Getenv("RECRAWL_INTERVAL")); err == nil && interval >= 0 {
+		cfg.RecrawlInterval = interval
+	}
+
+	if concurrency, err := strconv.Atoi(os.Getenv("CRAWL_HOST_CONCURRENCY")); err == nil && concurrency > 0 {
+		cfg.CrawlHostConcurrency = concurrency
+	}
+
+	if delay, err := time.ParseDuration(os.Getenv("CRAWL_HOST_DELAY")); err == nil && delay >= 0 {
+		cfg.CrawlHostDelay = delay
+	}
+
+	if rps, err := strconv.ParseFloat(os.Getenv("CRAWL_MAX_RPS"), 64); err == nil && rps >= 0 {
+		cfg.CrawlMaxRPS = rps
+	}
+
+	if bytesPerSec, err := strconv.ParseInt(os.Getenv("CRAWL_MAX_BYTES_PER_SEC"), 10, 64); err == nil && bytesPerSec >= 0 {
+		cfg.CrawlMaxBytesPerSec = bytesPerSec
+	}
+
+	if interval, err := time.ParseDuration(os.Getenv("FEED_POLL_INTERVAL")); err == nil && interval > 0 {
+		cfg.FeedPollInterval = interval
+	}
+
+	if roots := os.Getenv("LOCAL_SOURCE_ROOTS"); roots != "" {
+		cfg.LocalSourceRoots = filepath.SplitList(roots)
+	}
+
 	return cfg
 }
 
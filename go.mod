@@ -3,8 +3,10 @@ module github.com/mush1e/IndexStream-v2
 go 1.23.5
 
 require (
-	github.com/kljensen/snowball v0.10.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	github.com/mattn/go-sqlite3 v1.14.28 // indirect
-	golang.org/x/net v0.40.0 // indirect
+	github.com/kljensen/snowball v0.10.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.28
+	golang.org/x/net v0.40.0
 )
+
+require golang.org/x/text v0.25.0
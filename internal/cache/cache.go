@@ -187,6 +187,31 @@ func (c *MultiLayerCache) Set(key string, data interface{}) error {
 	return nil
 }
 
+// Delete removes an item from all cache layers
+func (c *MultiLayerCache) Delete(key string) {
+	hashKey := c.generateKey(key)
+
+	c.l1Mutex.Lock()
+	if _, exists := c.l1Cache[hashKey]; exists {
+		delete(c.l1Cache, hashKey)
+		c.removeFromOrder(hashKey)
+	}
+	c.l1Mutex.Unlock()
+
+	c.l2Mutex.Lock()
+	filePath := filepath.Join(c.l2Dir, hashKey+".cache")
+	if info, err := os.Stat(filePath); err == nil {
+		if os.Remove(filePath) == nil {
+			c.l2CurrentSize -= info.Size()
+		}
+	}
+	c.l2Mutex.Unlock()
+
+	c.l3Mutex.Lock()
+	delete(c.l3Cache, hashKey)
+	c.l3Mutex.Unlock()
+}
+
 // SetQueryResult stores a query result in L3 cache
 func (c *MultiLayerCache) SetQueryResult(query string, results interface{}) error {
 	hashKey := c.generateKey("query:" + query)
@@ -4,20 +4,33 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 type DB struct {
 	*sql.DB
+	driver string
 }
 
 type CrawlJob struct {
 	ID          int        `json:"id"`
 	URL         string     `json:"url"`
-	Status      string     `json:"status"` // pending, running, completed, failed
+	Status      string     `json:"status"` // pending, running, completed, failed, cancelled
 	StartedAt   *time.Time `json:"started_at,omitempty"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 	PagesFound  int        `json:"pages_found"`
+	Fetched     int        `json:"fetched"`
+	Failed      int        `json:"failed"`
+	Skipped     int        `json:"skipped"` // disallowed by robots.txt or unchanged since last indexed
+	Indexed     int        `json:"indexed"`
+	Retried     int        `json:"retried"`   // fetches tried again after an error
+	Throttled   int        `json:"throttled"` // 429 and 503 responses
+	Bytes       int64      `json:"bytes"`
+	Waited      int64      `json:"waited_ms"` // spent waiting on politeness limits
 	Error       string     `json:"error,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 }
@@ -30,6 +43,67 @@ type SearchQuery struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+type SearchClick struct {
+	ID        int       `json:"id"`
+	Query     string    `json:"query"`
+	DocID     string    `json:"doc_id"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ClickStat aggregates the clicks a document received for a query
+type ClickStat struct {
+	Query       string  `json:"query"`
+	DocID       string  `json:"doc_id"`
+	Clicks      int     `json:"clicks"`
+	AvgPosition float64 `json:"avg_position"`
+	CTR         float64 `json:"ctr"`
+}
+
+// QueryCTR is the click-through rate of a query over its logged searches
+type QueryCTR struct {
+	Query    string  `json:"query"`
+	Searches int     `json:"searches"`
+	Clicks   int     `json:"clicks"`
+	CTR      float64 `json:"ctr"`
+}
+
+// Feed is an RSS or Atom feed polled for new entries, with the validators of
+// its last response for conditional requests
+type Feed struct {
+	ID           int        `json:"id"`
+	URL          string     `json:"url"`
+	Title        string     `json:"title,omitempty"`
+	Interval     int        `json:"interval_seconds"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified string     `json:"last_modified,omitempty"`
+	LastPolledAt *time.Time `json:"last_polled_at,omitempty"`
+	NextPollAt   time.Time  `json:"next_poll_at"`
+	LastError    string     `json:"last_error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// FeedEntry is an entry link seen in a feed and the outcome of crawling it
+type FeedEntry struct {
+	FeedID    int        `json:"feed_id"`
+	Link      string     `json:"link"`
+	Published *time.Time `json:"published,omitempty"`
+	Status    string     `json:"status"` // pending, crawled, disallowed, failed
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	SeenAt    time.Time  `json:"seen_at"`
+}
+
+// ExperimentTotal is the folded outcome of one team of a ranking experiment.
+// Interleaving impressions and ties are kept under the empty team.
+type ExperimentTotal struct {
+	Experiment  string `json:"experiment"`
+	Team        string `json:"team"`
+	Impressions int    `json:"impressions"`
+	Clicked     int    `json:"clicked"`
+	Wins        int    `json:"wins"`
+}
+
 type IndexedDocument struct {
 	ID        int       `json:"id"`
 	DocID     string    `json:"doc_id"`
@@ -43,12 +117,14 @@ func NewDB(databaseURL string) (*DB, error) {
 	var db *sql.DB
 	var err error
 
+	driver := "postgres"
 	if databaseURL == "" || databaseURL == "sqlite" {
 		// Default to SQLite for local development
-		db, err = sql.Open("sqlite3", "./goosesearch.db")
+		driver = "sqlite3"
+		db, err = sql.Open(driver, "./goosesearch.db")
 	} else {
 		// PostgreSQL for production
-		db, err = sql.Open("postgres", databaseURL)
+		db, err = sql.Open(driver, databaseURL)
 	}
 
 	if err != nil {
@@ -59,7 +135,7 @@ func NewDB(databaseURL string) (*DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	database := &DB{db}
+	database := &DB{DB: db, driver: driver}
 	if err = database.createTables(); err != nil {
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
@@ -76,6 +152,14 @@ func (db *DB) createTables() error {
 			started_at DATETIME,
 			completed_at DATETIME,
 			pages_found INTEGER DEFAULT 0,
+			fetched INTEGER DEFAULT 0,
+			failed INTEGER DEFAULT 0,
+			skipped INTEGER DEFAULT 0,
+			indexed INTEGER DEFAULT 0,
+			retried INTEGER DEFAULT 0,
+			throttled INTEGER DEFAULT 0,
+			bytes INTEGER DEFAULT 0,
+			waited_ms INTEGER DEFAULT 0,
 			error TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
@@ -94,9 +178,47 @@ func (db *DB) createTables() error {
 			word_count INTEGER DEFAULT 0,
 			indexed_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`CREATE TABLE IF NOT EXISTS search_clicks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			query TEXT NOT NULL,
+			doc_id TEXT NOT NULL,
+			position INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS feeds (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT UNIQUE NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			interval_seconds INTEGER NOT NULL,
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT '',
+			last_polled_at DATETIME,
+			next_poll_at DATETIME NOT NULL,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS feed_entries (
+			feed_id INTEGER NOT NULL,
+			link TEXT NOT NULL,
+			published DATETIME,
+			status TEXT NOT NULL DEFAULT 'crawled',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (feed_id, link)
+		)`,
+		`CREATE TABLE IF NOT EXISTS experiment_totals (
+			experiment TEXT NOT NULL,
+			team TEXT NOT NULL,
+			impressions INTEGER NOT NULL DEFAULT 0,
+			clicked INTEGER NOT NULL DEFAULT 0,
+			wins INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (experiment, team)
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_crawl_jobs_status ON crawl_jobs(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_search_queries_created_at ON search_queries(created_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_indexed_documents_doc_id ON indexed_documents(doc_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_search_clicks_query ON search_clicks(query, doc_id)`,
 	}
 
 	for _, query := range queries {
@@ -105,6 +227,63 @@ func (db *DB) createTables() error {
 		}
 	}
 
+	// Entries seen before their outcome was kept count as crawled
+	err := db.addMissingColumns("feed_entries", []string{
+		`status TEXT NOT NULL DEFAULT 'crawled'`,
+		`attempts INTEGER NOT NULL DEFAULT 0`,
+		`last_error TEXT NOT NULL DEFAULT ''`,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Databases created before crawl jobs kept counts get the columns added
+	return db.addMissingColumns("crawl_jobs", []string{
+		`fetched INTEGER DEFAULT 0`,
+		`failed INTEGER DEFAULT 0`,
+		`skipped INTEGER DEFAULT 0`,
+		`indexed INTEGER DEFAULT 0`,
+		`retried INTEGER DEFAULT 0`,
+		`throttled INTEGER DEFAULT 0`,
+		`bytes INTEGER DEFAULT 0`,
+		`waited_ms INTEGER DEFAULT 0`,
+	})
+}
+
+// addMissingColumns adds the columns, given as they're declared, that a table
+// created by an earlier version lacks
+func (db *DB) addMissingColumns(table string, columns []string) error {
+	query := `SELECT name FROM pragma_table_info(?)`
+	if db.driver == "postgres" {
+		query = `SELECT column_name FROM information_schema.columns WHERE table_name = $1`
+	}
+	rows, err := db.Query(query, table)
+	if err != nil {
+		return fmt.Errorf("failed to list columns of %s: %w", table, err)
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		name, _, _ := strings.Cut(column, " ")
+		if existing[name] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", name, table, err)
+		}
+	}
 	return nil
 }
 
@@ -139,6 +318,9 @@ func (db *DB) UpdateCrawlJobStatus(id int, status string, pagesFound int, errorM
 	case "failed":
 		query = `UPDATE crawl_jobs SET status = ?, completed_at = ?, error = ? WHERE id = ?`
 		args = []interface{}{status, time.Now(), errorMsg, id}
+	case "cancelled":
+		query = `UPDATE crawl_jobs SET status = ?, completed_at = ?, pages_found = ? WHERE id = ?`
+		args = []interface{}{status, time.Now(), pagesFound, id}
 	default:
 		return fmt.Errorf("invalid status: %s", status)
 	}
@@ -147,15 +329,50 @@ func (db *DB) UpdateCrawlJobStatus(id int, status string, pagesFound int, errorM
 	return err
 }
 
+// UpdateCrawlJobCounts records the page and traffic counts of a job
+func (db *DB) UpdateCrawlJobCounts(job *CrawlJob) error {
+	query := `UPDATE crawl_jobs SET fetched = ?, failed = ?, skipped = ?, indexed = ?,
+			  retried = ?, throttled = ?, bytes = ?, waited_ms = ? WHERE id = ?`
+	_, err := db.Exec(query, job.Fetched, job.Failed, job.Skipped, job.Indexed,
+		job.Retried, job.Throttled, job.Bytes, job.Waited, job.ID)
+	return err
+}
+
+// crawlJobColumns are the columns scanCrawlJob reads
+const crawlJobColumns = `id, url, status, started_at, completed_at, pages_found,
+	COALESCE(fetched, 0), COALESCE(failed, 0), COALESCE(skipped, 0), COALESCE(indexed, 0),
+	COALESCE(retried, 0), COALESCE(throttled, 0), COALESCE(bytes, 0), COALESCE(waited_ms, 0), COALESCE(error, ''), created_at`
+
+// GetCrawlJob returns a crawl job, or nil when there is none with the ID
+func (db *DB) GetCrawlJob(id int) (*CrawlJob, error) {
+	job, err := scanCrawlJob(db.QueryRow(`SELECT `+crawlJobColumns+` FROM crawl_jobs WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
 func (db *DB) GetCrawlJobs(limit int) ([]CrawlJob, error) {
 	if limit <= 0 {
 		limit = 50
 	}
 
-	query := `SELECT id, url, status, started_at, completed_at, pages_found, error, created_at 
-			  FROM crawl_jobs ORDER BY created_at DESC LIMIT ?`
+	query := `SELECT ` + crawlJobColumns + `
+			  FROM crawl_jobs ORDER BY created_at DESC, id DESC LIMIT ?`
 
-	rows, err := db.Query(query, limit)
+	return db.queryCrawlJobs(query, limit)
+}
+
+// GetActiveCrawlJobs returns the jobs still pending or running
+func (db *DB) GetActiveCrawlJobs() ([]CrawlJob, error) {
+	query := `SELECT ` + crawlJobColumns + `
+			  FROM crawl_jobs WHERE status IN ('pending', 'running') ORDER BY id`
+
+	return db.queryCrawlJobs(query)
+}
+
+func (db *DB) queryCrawlJobs(query string, args ...interface{}) ([]CrawlJob, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -163,16 +380,25 @@ func (db *DB) GetCrawlJobs(limit int) ([]CrawlJob, error) {
 
 	var jobs []CrawlJob
 	for rows.Next() {
-		var job CrawlJob
-		err := rows.Scan(&job.ID, &job.URL, &job.Status, &job.StartedAt,
-			&job.CompletedAt, &job.PagesFound, &job.Error, &job.CreatedAt)
+		job, err := scanCrawlJob(rows)
 		if err != nil {
 			return nil, err
 		}
-		jobs = append(jobs, job)
+		jobs = append(jobs, *job)
 	}
 
-	return jobs, nil
+	return jobs, rows.Err()
+}
+
+func scanCrawlJob(row interface{ Scan(...interface{}) error }) (*CrawlJob, error) {
+	var job CrawlJob
+	err := row.Scan(&job.ID, &job.URL, &job.Status, &job.StartedAt, &job.CompletedAt, &job.PagesFound,
+		&job.Fetched, &job.Failed, &job.Skipped, &job.Indexed,
+		&job.Retried, &job.Throttled, &job.Bytes, &job.Waited, &job.Error, &job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
 }
 
 // SearchQuery methods
@@ -241,6 +467,272 @@ func (db *DB) GetPopularSearches(limit int) ([]SearchQuery, error) {
 	return searches, nil
 }
 
+// SearchClick methods
+func (db *DB) LogClick(query, docID string, position int) error {
+	sqlQuery := `INSERT INTO search_clicks (query, doc_id, position, created_at) VALUES (?, ?, ?, ?)`
+	_, err := db.Exec(sqlQuery, query, docID, position, time.Now())
+	return err
+}
+
+// GetClickStats aggregates clicks per query and document. An empty query returns
+// statistics for every query; CTR is relative to the number of logged searches.
+func (db *DB) GetClickStats(query string, limit int) ([]ClickStat, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	sqlQuery := `SELECT c.query, c.doc_id, COUNT(*) as clicks, AVG(c.position) as avg_position,
+			  (SELECT COUNT(*) FROM search_queries s WHERE s.query = c.query) as searches
+			  FROM search_clicks c
+			  WHERE (? = '' OR c.query = ?)
+			  GROUP BY c.query, c.doc_id
+			  ORDER BY clicks DESC
+			  LIMIT ?`
+
+	rows, err := db.Query(sqlQuery, query, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []ClickStat
+	for rows.Next() {
+		var stat ClickStat
+		var searches int
+		err := rows.Scan(&stat.Query, &stat.DocID, &stat.Clicks, &stat.AvgPosition, &searches)
+		if err != nil {
+			return nil, err
+		}
+		if searches > 0 {
+			stat.CTR = float64(stat.Clicks) / float64(searches)
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats, nil
+}
+
+// GetClicks returns the most recent raw click events, oldest first
+func (db *DB) GetClicks(limit int) ([]SearchClick, error) {
+	if limit <= 0 {
+		limit = 10000
+	}
+
+	query := `SELECT id, query, doc_id, position, created_at
+			  FROM search_clicks ORDER BY created_at DESC, id DESC LIMIT ?`
+
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clicks []SearchClick
+	for rows.Next() {
+		var click SearchClick
+		err := rows.Scan(&click.ID, &click.Query, &click.DocID, &click.Position, &click.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		clicks = append(clicks, click)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(clicks)-1; i < j; i, j = i+1, j-1 {
+		clicks[i], clicks[j] = clicks[j], clicks[i]
+	}
+	return clicks, nil
+}
+
+// GetLowCTRQueries lists queries searched at least minSearches times, lowest CTR first
+func (db *DB) GetLowCTRQueries(minSearches, limit int) ([]QueryCTR, error) {
+	if minSearches <= 0 {
+		minSearches = 1
+	}
+	if limit <= 0 {
+		limit = 20
+	}
+
+	query := `SELECT s.query, COUNT(*) as searches,
+			  (SELECT COUNT(*) FROM search_clicks c WHERE c.query = s.query) as clicks
+			  FROM search_queries s
+			  GROUP BY s.query
+			  HAVING COUNT(*) >= ?
+			  ORDER BY CAST(clicks AS REAL) / COUNT(*) ASC, searches DESC
+			  LIMIT ?`
+
+	rows, err := db.Query(query, minSearches, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queries []QueryCTR
+	for rows.Next() {
+		var q QueryCTR
+		if err := rows.Scan(&q.Query, &q.Searches, &q.Clicks); err != nil {
+			return nil, err
+		}
+		q.CTR = float64(q.Clicks) / float64(q.Searches)
+		queries = append(queries, q)
+	}
+
+	return queries, nil
+}
+
+// Feed methods
+func (db *DB) CreateFeed(url string, interval time.Duration) (*Feed, error) {
+	feed := &Feed{
+		URL:        url,
+		Interval:   int(interval.Seconds()),
+		NextPollAt: time.Now(),
+		CreatedAt:  time.Now(),
+	}
+
+	query := `INSERT INTO feeds (url, interval_seconds, next_poll_at, created_at) VALUES (?, ?, ?, ?) RETURNING id`
+	err := db.QueryRow(query, feed.URL, feed.Interval, feed.NextPollAt, feed.CreatedAt).Scan(&feed.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create feed: %w", err)
+	}
+
+	return feed, nil
+}
+
+// GetFeed returns nil when no feed has the given ID
+func (db *DB) GetFeed(id int) (*Feed, error) {
+	query := `SELECT id, url, title, interval_seconds, etag, last_modified, last_polled_at, next_poll_at, last_error, created_at
+			  FROM feeds WHERE id = ?`
+
+	feed, err := scanFeed(db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return feed, err
+}
+
+func (db *DB) GetFeeds() ([]Feed, error) {
+	query := `SELECT id, url, title, interval_seconds, etag, last_modified, last_polled_at, next_poll_at, last_error, created_at
+			  FROM feeds ORDER BY id`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var feeds []Feed
+	for rows.Next() {
+		feed, err := scanFeed(rows)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, *feed)
+	}
+
+	return feeds, rows.Err()
+}
+
+func scanFeed(row interface{ Scan(...interface{}) error }) (*Feed, error) {
+	var feed Feed
+	err := row.Scan(&feed.ID, &feed.URL, &feed.Title, &feed.Interval, &feed.ETag, &feed.LastModified,
+		&feed.LastPolledAt, &feed.NextPollAt, &feed.LastError, &feed.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// UpdateFeedPoll records the outcome of polling a feed
+func (db *DB) UpdateFeedPoll(feed *Feed) error {
+	query := `UPDATE feeds SET title = ?, etag = ?, last_modified = ?, last_polled_at = ?, next_poll_at = ?, last_error = ?
+			  WHERE id = ?`
+	_, err := db.Exec(query, feed.Title, feed.ETag, feed.LastModified, feed.LastPolledAt, feed.NextPollAt, feed.LastError, feed.ID)
+	return err
+}
+
+// DeleteFeed removes a feed and the entries seen in it, reporting whether it existed
+func (db *DB) DeleteFeed(id int) (bool, error) {
+	result, err := db.Exec(`DELETE FROM feeds WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	if _, err := db.Exec(`DELETE FROM feed_entries WHERE feed_id = ?`, id); err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// AddFeedEntry records an entry seen in a feed as pending, reporting whether
+// it wasn't seen before
+func (db *DB) AddFeedEntry(feedID int, link string, published *time.Time) (bool, error) {
+	query := `INSERT OR IGNORE INTO feed_entries (feed_id, link, published, status, seen_at) VALUES (?, ?, ?, 'pending', ?)`
+	result, err := db.Exec(query, feedID, link, published, time.Now())
+	if err != nil {
+		return false, err
+	}
+	added, err := result.RowsAffected()
+	return added > 0, err
+}
+
+// GetPendingFeedEntries returns the entries of a feed still to be crawled,
+// oldest first
+func (db *DB) GetPendingFeedEntries(feedID int) ([]FeedEntry, error) {
+	query := `SELECT feed_id, link, published, status, attempts, last_error, seen_at
+			  FROM feed_entries WHERE feed_id = ? AND status = 'pending' ORDER BY seen_at`
+
+	rows, err := db.Query(query, feedID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []FeedEntry
+	for rows.Next() {
+		var e FeedEntry
+		if err := rows.Scan(&e.FeedID, &e.Link, &e.Published, &e.Status, &e.Attempts, &e.LastError, &e.SeenAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// UpdateFeedEntry records the outcome of an attempt to crawl an entry
+func (db *DB) UpdateFeedEntry(e *FeedEntry) error {
+	query := `UPDATE feed_entries SET status = ?, attempts = ?, last_error = ? WHERE feed_id = ? AND link = ?`
+	_, err := db.Exec(query, e.Status, e.Attempts, e.LastError, e.FeedID, e.Link)
+	return err
+}
+
+// ExperimentTotal methods
+func (db *DB) GetExperimentTotals() ([]ExperimentTotal, error) {
+	rows, err := db.Query(`SELECT experiment, team, impressions, clicked, wins FROM experiment_totals`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []ExperimentTotal
+	for rows.Next() {
+		var t ExperimentTotal
+		if err := rows.Scan(&t.Experiment, &t.Team, &t.Impressions, &t.Clicked, &t.Wins); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// SaveExperimentTotal stores the folded outcome of an experiment's team
+func (db *DB) SaveExperimentTotal(t ExperimentTotal) error {
+	query := `INSERT OR REPLACE INTO experiment_totals (experiment, team, impressions, clicked, wins) VALUES (?, ?, ?, ?, ?)`
+	_, err := db.Exec(query, t.Experiment, t.Team, t.Impressions, t.Clicked, t.Wins)
+	return err
+}
+
 // IndexedDocument methods
 func (db *DB) AddIndexedDocument(docID, url, title string, wordCount int) error {
 	query := `INSERT OR REPLACE INTO indexed_documents (doc_id, url, title, word_count, indexed_at) 
@@ -249,6 +741,11 @@ func (db *DB) AddIndexedDocument(docID, url, title string, wordCount int) error
 	return err
 }
 
+func (db *DB) RemoveIndexedDocument(docID string) error {
+	_, err := db.Exec(`DELETE FROM indexed_documents WHERE doc_id = ?`, docID)
+	return err
+}
+
 func (db *DB) GetIndexedDocuments(limit int) ([]IndexedDocument, error) {
 	if limit <= 0 {
 		limit = 100
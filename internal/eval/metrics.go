@@ -0,0 +1,109 @@
+package eval
+
+import (
+	"math"
+	"sort"
+)
+
+// Metrics holds the IR measures computed for a single ranked list
+type Metrics struct {
+	NDCG      float64 `json:"ndcg"`
+	AP        float64 `json:"ap"`
+	RR        float64 `json:"rr"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	Retrieved int     `json:"retrieved"`
+	Relevant  int     `json:"relevant"`
+}
+
+// Compute scores a ranking of document IDs against graded judgments.
+// NDCG, precision and recall are cut off at k; AP and RR use the full ranking.
+func Compute(ranking []string, judged map[string]int, k int) Metrics {
+	m := Metrics{Retrieved: len(ranking)}
+
+	for _, grade := range judged {
+		if grade > 0 {
+			m.Relevant++
+		}
+	}
+	if m.Relevant == 0 {
+		return m
+	}
+
+	relevantSeen := 0
+	relevantAtK := 0
+	precisionSum := 0.0
+	dcg := 0.0
+
+	for i, docID := range ranking {
+		grade := judged[docID]
+		if grade <= 0 {
+			continue
+		}
+
+		relevantSeen++
+		precisionSum += float64(relevantSeen) / float64(i+1)
+		if m.RR == 0 {
+			m.RR = 1 / float64(i+1)
+		}
+		if i < k {
+			relevantAtK++
+			dcg += gain(grade, i)
+		}
+	}
+
+	m.AP = precisionSum / float64(m.Relevant)
+	m.Precision = float64(relevantAtK) / float64(k)
+	m.Recall = float64(relevantAtK) / float64(m.Relevant)
+	if ideal := idealDCG(judged, k); ideal > 0 {
+		m.NDCG = dcg / ideal
+	}
+	return m
+}
+
+// gain is the exponential DCG gain of a grade at a zero-based rank
+func gain(grade, rank int) float64 {
+	return (math.Pow(2, float64(grade)) - 1) / math.Log2(float64(rank+2))
+}
+
+func idealDCG(judged map[string]int, k int) float64 {
+	grades := make([]int, 0, len(judged))
+	for _, grade := range judged {
+		if grade > 0 {
+			grades = append(grades, grade)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(grades)))
+
+	ideal := 0.0
+	for i := 0; i < len(grades) && i < k; i++ {
+		ideal += gain(grades[i], i)
+	}
+	return ideal
+}
+
+// Mean averages metrics over all evaluated queries
+func Mean(perQuery map[string]Metrics) Metrics {
+	var mean Metrics
+	if len(perQuery) == 0 {
+		return mean
+	}
+
+	for _, m := range perQuery {
+		mean.NDCG += m.NDCG
+		mean.AP += m.AP
+		mean.RR += m.RR
+		mean.Precision += m.Precision
+		mean.Recall += m.Recall
+		mean.Retrieved += m.Retrieved
+		mean.Relevant += m.Relevant
+	}
+
+	n := float64(len(perQuery))
+	mean.NDCG /= n
+	mean.AP /= n
+	mean.RR /= n
+	mean.Precision /= n
+	mean.Recall /= n
+	return mean
+}
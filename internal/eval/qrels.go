@@ -0,0 +1,111 @@
+package eval
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Judgments maps a query ID to the graded relevance of each judged document
+type Judgments map[string]map[string]int
+
+// Query is a single evaluation query
+type Query struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+// DocIDFor normalizes a qrels document reference to an index document ID.
+// URLs are hashed the same way the crawler names stored pages.
+func DocIDFor(ref string) string {
+	if strings.Contains(ref, "://") {
+		sum := sha256.Sum256([]byte(ref))
+		return hex.EncodeToString(sum[:])
+	}
+	return ref
+}
+
+// LoadQrels reads a TREC qrels file ("qid iter docno grade" per line)
+func LoadQrels(path string) (Judgments, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening qrels %s: %w", path, err)
+	}
+	defer f.Close()
+
+	judgments := make(Judgments)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("qrels %s:%d: expected 4 fields, got %d", path, lineNo, len(fields))
+		}
+
+		grade, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("qrels %s:%d: invalid grade %q", path, lineNo, fields[3])
+		}
+
+		qid := fields[0]
+		if judgments[qid] == nil {
+			judgments[qid] = make(map[string]int)
+		}
+		judgments[qid][DocIDFor(fields[2])] = grade
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading qrels %s: %w", path, err)
+	}
+	return judgments, nil
+}
+
+// LoadQueries reads a topics file with one "qid<whitespace>query text" entry per line
+func LoadQueries(path string) ([]Query, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening queries %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var queries []Query
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		qid, text, found := strings.Cut(line, "\t")
+		if !found {
+			qid, text, found = strings.Cut(line, " ")
+		}
+		text = strings.TrimSpace(text)
+		if !found || text == "" {
+			return nil, fmt.Errorf("queries %s:%d: expected \"qid query\"", path, lineNo)
+		}
+		queries = append(queries, Query{ID: qid, Text: text})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading queries %s: %w", path, err)
+	}
+
+	sort.SliceStable(queries, func(i, j int) bool {
+		return queries[i].ID < queries[j].ID
+	})
+	return queries, nil
+}
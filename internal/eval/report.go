@@ -0,0 +1,115 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Run is the outcome of evaluating one ranking configuration
+type Run struct {
+	Name     string             `json:"name"`
+	Config   string             `json:"config"`
+	Mean     Metrics            `json:"mean"`
+	PerQuery map[string]Metrics `json:"per_query"`
+}
+
+// QueryDiff compares a query between the first two runs of a report
+type QueryDiff struct {
+	QueryID string  `json:"query_id"`
+	Query   string  `json:"query"`
+	NDCGA   float64 `json:"ndcg_a"`
+	NDCGB   float64 `json:"ndcg_b"`
+	APA     float64 `json:"ap_a"`
+	APB     float64 `json:"ap_b"`
+	Delta   float64 `json:"ndcg_delta"`
+}
+
+// Report is the serializable result of an evaluation session
+type Report struct {
+	Label       string      `json:"label,omitempty"`
+	K           int         `json:"k"`
+	Depth       int         `json:"depth"`
+	Queries     int         `json:"queries"`
+	GeneratedAt time.Time   `json:"generated_at"`
+	Runs        []Run       `json:"runs"`
+	Diffs       []QueryDiff `json:"diffs,omitempty"`
+}
+
+// NewReport assembles runs into a report, computing per-query diffs when two runs are present
+func NewReport(label string, k, depth int, queries []Query, runs []Run) *Report {
+	report := &Report{
+		Label:       label,
+		K:           k,
+		Depth:       depth,
+		Queries:     len(queries),
+		GeneratedAt: time.Now(),
+		Runs:        runs,
+	}
+
+	if len(runs) < 2 {
+		return report
+	}
+
+	a, b := runs[0], runs[1]
+	for _, q := range queries {
+		ma, okA := a.PerQuery[q.ID]
+		mb, okB := b.PerQuery[q.ID]
+		if !okA || !okB {
+			continue
+		}
+		report.Diffs = append(report.Diffs, QueryDiff{
+			QueryID: q.ID,
+			Query:   q.Text,
+			NDCGA:   ma.NDCG,
+			NDCGB:   mb.NDCG,
+			APA:     ma.AP,
+			APB:     mb.AP,
+			Delta:   mb.NDCG - ma.NDCG,
+		})
+	}
+
+	// Biggest movers first so regressions are easy to spot
+	sort.SliceStable(report.Diffs, func(i, j int) bool {
+		return abs(report.Diffs[i].Delta) > abs(report.Diffs[j].Delta)
+	})
+	return report
+}
+
+// WriteTable prints a human readable summary of the report
+func (r *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "RUN\tNDCG@%d\tMAP\tMRR\tP@%d\tR@%d\tCONFIG\n", r.K, r.K, r.K)
+	for _, run := range r.Runs {
+		fmt.Fprintf(tw, "%s\t%.4f\t%.4f\t%.4f\t%.4f\t%.4f\t%s\n",
+			run.Name, run.Mean.NDCG, run.Mean.AP, run.Mean.RR,
+			run.Mean.Precision, run.Mean.Recall, run.Config)
+	}
+
+	if len(r.Diffs) > 0 {
+		fmt.Fprintf(tw, "\nQID\tNDCG(%s)\tNDCG(%s)\tDELTA\tQUERY\n", r.Runs[0].Name, r.Runs[1].Name)
+		for _, d := range r.Diffs {
+			fmt.Fprintf(tw, "%s\t%.4f\t%.4f\t%+.4f\t%s\n", d.QueryID, d.NDCGA, d.NDCGB, d.Delta, d.Query)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON serializes the report for comparison across commits
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func abs(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -1,13 +1,22 @@
 package handler
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
+	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mush1e/IndexStream-v2/config"
 	"github.com/mush1e/IndexStream-v2/internal/service"
 )
 
@@ -190,6 +199,8 @@ const searchPageHTML = `
         }
 
         .result-title {
+            display: block;
+            text-decoration: none;
             font-size: 1.2rem;
             font-weight: 600;
             color: #667eea;
@@ -203,6 +214,12 @@ const searchPageHTML = `
             word-break: break-all;
         }
 
+        .result-snippet {
+            font-size: 0.95rem;
+            color: #444;
+            margin-bottom: 8px;
+        }
+
         .result-score {
             font-size: 0.8rem;
             color: #666;
@@ -433,6 +450,12 @@ const searchPageHTML = `
             }
         }
 
+        function escapeHTML(text) {
+            const div = document.createElement('div');
+            div.textContent = text;
+            return div.innerHTML;
+        }
+
         function displayResults(results, query) {
             const searchResults = document.getElementById('searchResults');
             
@@ -445,8 +468,12 @@ const searchPageHTML = `
             
             results.forEach((result, index) => {
                 html += '<div class="result-item">';
-                html += '<div class="result-title">' + (result.title || result.url || 'Untitled') + '</div>';
+                const clickParams = { q: query, doc: result.doc_id, pos: index + 1 };
+                if (result.impression) clickParams.imp = result.impression;
+                const clickURL = '/r?' + new URLSearchParams(clickParams);
+                html += '<a class="result-title" href="' + clickURL + '">' + escapeHTML(result.title || result.url || 'Untitled') + '</a>';
                 html += '<div class="result-url">' + (result.url || result.doc_id) + '</div>';
+                if (result.snippet) html += '<div class="result-snippet">' + escapeHTML(result.snippet) + '</div>';
                 html += '<div class="result-score">Relevance Score: ' + result.score.toFixed(4) + '</div>';
                 html += '</div>';
             });
@@ -471,7 +498,10 @@ const searchPageHTML = `
                     throw new Error('Crawl failed');
                 }
 
-                const message = await response.text();
+                const contentType = response.headers.get('Content-Type') || '';
+                const message = contentType.includes('application/json')
+                    ? 'job ' + (await response.json()).id
+                    : await response.text();
                 showMessage('Crawl started: ' + message, 'success');
                 
                 // Clear the input
@@ -605,6 +635,9 @@ const searchPageHTML = `
 
 var searchTemplate = template.Must(template.New("search").Parse(searchPageHTML))
 
+// userCookieName is the cookie used to bucket anonymous users into experiments
+const userCookieName = "is_uid"
+
 func GetHome(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := searchTemplate.Execute(w, nil); err != nil {
@@ -625,7 +658,19 @@ func GetSearch(w http.ResponseWriter, r *http.Request) {
 		searchLimit = 10
 	}
 
-	searchResults := service.InvertedIndex.Search(searchQuery, searchLimit)
+	req, err := searchFilters(r)
+	if err != nil {
+		http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Query = searchQuery
+	req.TopK = searchLimit
+
+	start := time.Now()
+	user := userID(w, r)
+	searchResults := service.Experiments.Search(req, user)
+	service.LogSearch(searchQuery, len(searchResults), time.Since(start))
+	service.RecordServed(user, searchQuery, searchResults)
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	enc := json.NewEncoder(w)
@@ -636,8 +681,253 @@ func GetSearch(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// searchFilters reads the language and the filters of a search request: type,
+// author, published_after and published_before (dates or RFC 3339 times)
+func searchFilters(r *http.Request) (service.SearchRequest, error) {
+	params := r.URL.Query()
+	req := service.SearchRequest{
+		Language:    params.Get("lang"),
+		ContentType: params.Get("type"),
+		Author:      params.Get("author"),
+	}
+
+	if req.ContentType != "" && service.NormalizeContentType(req.ContentType) == "" {
+		return req, fmt.Errorf("unknown content 'type'")
+	}
+
+	var err error
+	if req.PublishedAfter, err = parseFilterTime(params.Get("published_after")); err != nil {
+		return req, fmt.Errorf("bad 'published_after': %w", err)
+	}
+	if req.PublishedBefore, err = parseFilterTime(params.Get("published_before")); err != nil {
+		return req, fmt.Errorf("bad 'published_before': %w", err)
+	}
+	return req, nil
+}
+
+func parseFilterTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// GetSearchFacets counts author, date, type and language values over all
+// documents matching a search, with the same filters as /search
+func GetSearchFacets(w http.ResponseWriter, r *http.Request) {
+	searchQuery := r.URL.Query().Get("search-query")
+	if searchQuery == "" {
+		http.Error(w, "invalid query missing 'search-query' parameter", http.StatusBadRequest)
+		return
+	}
+
+	req, err := searchFilters(r)
+	if err != nil {
+		http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Query = searchQuery
+
+	names := service.FacetNames()
+	if requested := r.URL.Query().Get("facets"); requested != "" {
+		names = strings.Split(requested, ",")
+	}
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size <= 0 {
+		size = 10
+	}
+
+	facets, err := service.InvertedIndex.Facets(req, names, size)
+	if err != nil {
+		http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, facets)
+}
+
+// GetRedirect logs a click on a search result and redirects to the document
+func GetRedirect(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	docID := r.URL.Query().Get("doc")
+
+	// Only redirect to URLs we indexed so /r can't be used as an open redirect
+	target, ok := service.DocumentURL(docID)
+	if !ok || target == "" {
+		http.Error(w, "unknown document", http.StatusNotFound)
+		return
+	}
+
+	if query != "" {
+		service.RecordClick(userID(w, r), query, docID)
+	}
+	if impression := r.URL.Query().Get("imp"); impression != "" {
+		service.Experiments.RecordClick(impression, docID)
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// GetClickStats reports click-through statistics per query and document
+func GetClickStats(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	stats, err := service.ClickStats(r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeServiceError(w, "failed to load click stats", err)
+		return
+	}
+
+	writeJSON(w, stats)
+}
+
+// GetLowCTRQueries lists frequent queries whose results are rarely clicked
+func GetLowCTRQueries(w http.ResponseWriter, r *http.Request) {
+	minSearches, err := strconv.Atoi(r.URL.Query().Get("min_searches"))
+	if err != nil || minSearches <= 0 {
+		minSearches = 5
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+
+	queries, err := service.LowCTRQueries(minSearches, limit)
+	if err != nil {
+		writeServiceError(w, "failed to load query CTR", err)
+		return
+	}
+
+	writeJSON(w, queries)
+}
+
+// GetExperiments reports win rates and CTRs of the configured ranking experiments
+func GetExperiments(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, service.Experiments.Stats())
+}
+
+// GetExperiment reports a single experiment by name
+func GetExperiment(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, stats := range service.Experiments.Stats() {
+		if stats.Name == name {
+			writeJSON(w, stats)
+			return
+		}
+	}
+	http.Error(w, "unknown experiment", http.StatusNotFound)
+}
+
+// GetRankingProfiles lists the available ranking profiles
+func GetRankingProfiles(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, service.ListProfiles())
+}
+
+// userID identifies the caller for experiment bucketing: the API key when one
+// is sent, otherwise a long-lived cookie that is issued on first contact
+func userID(w http.ResponseWriter, r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return "key:" + key
+	}
+
+	if cookie, err := r.Cookie(userCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     userCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// GetSynonyms shows the active synonym set
+func GetSynonyms(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, service.Synonyms.Info())
+}
+
+// PostReloadSynonyms re-reads the synonyms file from disk
+func PostReloadSynonyms(w http.ResponseWriter, r *http.Request) {
+	if err := service.Synonyms.Reload(); err != nil {
+		http.Error(w, "failed to reload synonyms: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, service.Synonyms.Info())
+}
+
+// GetAnalyzers shows which analyzer each field uses
+func GetAnalyzers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, service.FieldAnalyzers())
+}
+
+// GetAnalyze runs text through a field's or a named analyzer, for debugging analysis
+func GetAnalyze(w http.ResponseWriter, r *http.Request) {
+	text := r.URL.Query().Get("text")
+	if text == "" {
+		http.Error(w, "invalid query: missing 'text' parameter", http.StatusBadRequest)
+		return
+	}
+
+	analyzer := service.AnalyzerFor(r.URL.Query().Get("field"))
+	if name := r.URL.Query().Get("analyzer"); name != "" {
+		if analyzer = service.GetAnalyzer(name); analyzer == nil {
+			http.Error(w, "unknown analyzer", http.StatusNotFound)
+			return
+		}
+	}
+
+	tokens, stopped := analyzer.AnalyzeWithStopwords(text, service.NormalizeLanguage(r.URL.Query().Get("lang")))
+	writeJSON(w, map[string]interface{}{
+		"analyzer":  analyzer.Name,
+		"tokens":    tokens,
+		"stopwords": stopped,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, "failed to json encode response", http.StatusInternalServerError)
+	}
+}
+
+// writeJSONStatus writes a JSON response with a status other than 200 OK
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeServiceError(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, service.ErrNoDatabase) {
+		http.Error(w, msg+": "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, msg+": "+err.Error(), http.StatusInternalServerError)
+}
+
 func GetCrawl(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "Crawl endpoint - use POST /crawl?url=<url> to start crawling")
+	fmt.Fprintf(w, "Crawl endpoint - use POST /crawl?url=<url> to start crawling, or url=file:///<dir> to index a local directory")
 }
 
 func PostCrawl(w http.ResponseWriter, r *http.Request) {
@@ -648,11 +938,28 @@ func PostCrawl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if strings.HasPrefix(crawlURL, "file:") {
+		postLocalCrawl(w, r, crawlURL)
+		return
+	}
+
 	if u, err := url.ParseRequestURI(crawlURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
 		http.Error(w, "bad URL provided", http.StatusBadRequest)
 		return
 	}
 
+	job, err := service.StartCrawlJob(crawlURL)
+	if err == nil {
+		w.Header().Set("Location", "/crawl/jobs/"+strconv.Itoa(job.ID))
+		writeJSONStatus(w, http.StatusAccepted, job)
+		return
+	}
+	if !errors.Is(err, service.ErrNoDatabase) {
+		writeServiceError(w, "failed to start crawl", err)
+		return
+	}
+
+	// Without a database there is no job to follow the crawl by
 	w.WriteHeader(http.StatusAccepted)
 	w.Write([]byte("crawl has been queued for " + crawlURL))
 
@@ -661,6 +968,323 @@ func PostCrawl(w http.ResponseWriter, r *http.Request) {
 	}(crawlURL)
 }
 
+// GetCrawlJobs lists the most recent crawl jobs with their counts
+func GetCrawlJobs(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	jobs, err := service.CrawlJobs(limit)
+	if err != nil {
+		writeServiceError(w, "failed to list crawl jobs", err)
+		return
+	}
+	writeJSON(w, jobs)
+}
+
+// GetCrawlJob reports the status of a crawl job and its counts so far
+func GetCrawlJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid crawl job id", http.StatusBadRequest)
+		return
+	}
+	job, err := service.GetCrawlJob(id)
+	if err != nil {
+		if errors.Is(err, service.ErrCrawlJobNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeServiceError(w, "failed to get crawl job", err)
+		return
+	}
+	writeJSON(w, job)
+}
+
+// DeleteCrawlJob cancels a running crawl job
+func DeleteCrawlJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid crawl job id", http.StatusBadRequest)
+		return
+	}
+	job, err := service.CancelCrawlJob(id)
+	switch {
+	case errors.Is(err, service.ErrCrawlJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrCrawlJobFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		writeServiceError(w, "failed to cancel crawl job", err)
+	default:
+		writeJSON(w, job)
+	}
+}
+
+// PostDocument indexes a document pushed by the caller. A JSON body is a
+// service.PushedDocument; any other body is the document itself, of the
+// request's Content-Type, with its URL, ID and title in X-Document-* headers.
+// With async=true the document is indexed in the background and a task is
+// returned to poll.
+func PostDocument(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.Get().MaxDocumentBytes)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "document too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "failed to read body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	doc := &service.PushedDocument{}
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/json" {
+		if err := json.Unmarshal(body, doc); err != nil {
+			http.Error(w, "invalid document: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		doc.ID = r.Header.Get("X-Document-Id")
+		doc.URL = r.Header.Get("X-Document-Url")
+		doc.Title = r.Header.Get("X-Document-Title")
+		doc.Language = r.Header.Get("Content-Language")
+		doc.ContentType = contentType
+		doc.Body = string(body)
+	}
+	if err := doc.Validate(); err != nil {
+		http.Error(w, "invalid document: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		task, err := service.PushDocumentAsync(doc)
+		if err != nil {
+			http.Error(w, "invalid document: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Location", "/documents/tasks/"+task.ID)
+		writeJSONStatus(w, http.StatusAccepted, task)
+		return
+	}
+
+	docID, tokens, err := service.PushDocument(doc)
+	if err != nil {
+		http.Error(w, "failed to index document: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSONStatus(w, http.StatusCreated, map[string]interface{}{
+		"doc_id": docID,
+		"tokens": tokens,
+	})
+}
+
+// bulkBatchTimeout is how long a bulk request may take to send and apply each
+// batch of actions, and to receive the response after the last one
+const bulkBatchTimeout = 30 * time.Second
+
+// PostDocumentsBulk applies newline-delimited JSON index, create and delete
+// actions and reports the outcome of each
+func PostDocumentsBulk(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.Get().MaxBulkBytes)
+
+	// Large requests take longer than the server timeouts allow, so the
+	// deadlines move forward as each batch is done. A client that stops
+	// sending still times out.
+	rc := http.NewResponseController(w)
+	extend := func() {
+		deadline := time.Now().Add(bulkBatchTimeout)
+		rc.SetReadDeadline(deadline)
+		rc.SetWriteDeadline(deadline)
+	}
+	extend()
+
+	resp, err := service.Bulk(r.Body, int(config.Get().MaxDocumentBytes), extend)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeJSONStatus(w, http.StatusRequestEntityTooLarge, resp)
+	case err != nil:
+		writeJSONStatus(w, http.StatusBadRequest, resp)
+	default:
+		writeJSON(w, resp)
+	}
+}
+
+// GetDocumentTask reports the state of a background document push
+func GetDocumentTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := service.GetTask(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "unknown task", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, task)
+}
+
+// GetCrawlFrontier reports the URLs queued for crawling and the crawls they belong to
+func GetCrawlFrontier(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, service.StartFrontier().Status())
+}
+
+// PostCrawlPause stops the crawler from starting new fetches until it's resumed
+func PostCrawlPause(w http.ResponseWriter, r *http.Request) {
+	f := service.StartFrontier()
+	f.SetPaused(true)
+	writeJSON(w, f.Status())
+}
+
+// PostCrawlResume lets a paused crawler fetch again
+func PostCrawlResume(w http.ResponseWriter, r *http.Request) {
+	f := service.StartFrontier()
+	f.SetPaused(false)
+	writeJSON(w, f.Status())
+}
+
+// PostCrawlSitemap crawls the pages listed in a sitemap or sitemap index
+func PostCrawlSitemap(w http.ResponseWriter, r *http.Request) {
+	sitemapURL := r.URL.Query().Get("url")
+
+	if sitemapURL == "" {
+		http.Error(w, "invalid query: missing 'url' parameter", http.StatusBadRequest)
+		return
+	}
+
+	if u, err := url.ParseRequestURI(sitemapURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		http.Error(w, "bad URL provided", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("sitemap crawl has been queued for " + sitemapURL))
+
+	go func() {
+		if _, err := service.CrawlSitemap(sitemapURL); err != nil {
+			log.Printf("Error crawling sitemap %s: %v", sitemapURL, err)
+		}
+	}()
+}
+
+// PostFeed subscribes to an RSS or Atom feed, given as JSON with its url and
+// an optional interval_seconds, and polls it right away
+func PostFeed(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL      string `json:"url"`
+		Interval int    `json:"interval_seconds"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Interval < 0 {
+		http.Error(w, "invalid request: negative interval_seconds", http.StatusBadRequest)
+		return
+	}
+
+	feed, err := service.SubscribeFeed(req.URL, time.Duration(req.Interval)*time.Second)
+	switch {
+	case errors.Is(err, service.ErrFeedExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrNoDatabase):
+		writeServiceError(w, "failed to subscribe", err)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	go func() {
+		if _, err := service.PollFeedNow(feed.ID); err != nil {
+			log.Printf("Error polling feed %s: %v", feed.URL, err)
+		}
+	}()
+
+	w.Header().Set("Location", "/feeds/"+strconv.Itoa(feed.ID))
+	writeJSONStatus(w, http.StatusCreated, feed)
+}
+
+// GetFeeds lists the subscribed feeds and the state of their last poll
+func GetFeeds(w http.ResponseWriter, r *http.Request) {
+	feeds, err := service.Feeds()
+	if err != nil {
+		writeServiceError(w, "failed to list feeds", err)
+		return
+	}
+	writeJSON(w, feeds)
+}
+
+// DeleteFeed unsubscribes from a feed
+func DeleteFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid feed id", http.StatusBadRequest)
+		return
+	}
+	if err := service.UnsubscribeFeed(id); err != nil {
+		if errors.Is(err, service.ErrFeedNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeServiceError(w, "failed to unsubscribe", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PostPollFeed polls a feed now and reports what it found
+func PostPollFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid feed id", http.StatusBadRequest)
+		return
+	}
+	poll, err := service.PollFeedNow(id)
+	switch {
+	case errors.Is(err, service.ErrFeedNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrNoDatabase):
+		writeServiceError(w, "failed to poll feed", err)
+	case err != nil:
+		http.Error(w, "failed to poll feed: "+err.Error(), http.StatusBadGateway)
+	default:
+		writeJSON(w, poll)
+	}
+}
+
+// postLocalCrawl indexes a directory under one of the configured local source
+// roots, with optional comma separated include and exclude globs
+func postLocalCrawl(w http.ResponseWriter, r *http.Request, crawlURL string) {
+	root, err := service.LocalSourcePath(crawlURL)
+	if errors.Is(err, service.ErrLocalSourceForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := service.DirOptions{
+		Include: service.SplitPatterns(r.URL.Query().Get("include")),
+		Exclude: service.SplitPatterns(r.URL.Query().Get("exclude")),
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("indexing has been queued for " + root))
+
+	go func() {
+		if _, err := service.IndexDirectory(service.InvertedIndex, root, opts); err != nil {
+			log.Printf("Error indexing %s: %v", root, err)
+		}
+	}()
+}
+
 // New cache management endpoints
 func GetStats(w http.ResponseWriter, r *http.Request) {
 	indexStats := service.InvertedIndex.GetIndexStats()
@@ -669,6 +1293,7 @@ func GetStats(w http.ResponseWriter, r *http.Request) {
 	stats := map[string]interface{}{
 		"index": indexStats,
 		"cache": cacheStats,
+		"hosts": service.HostsPoliteness(),
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -17,8 +17,26 @@ func NewServer(cfg *config.Config) *http.Server {
 	// Main routes
 	mux.HandleFunc("/", handler.GetHome)
 	mux.HandleFunc("GET /search", handler.GetSearch)
+	mux.HandleFunc("GET /search/facets", handler.GetSearchFacets)
+	mux.HandleFunc("GET /r", handler.GetRedirect)
 	mux.HandleFunc("GET /crawl", handler.GetCrawl)
 	mux.HandleFunc("POST /crawl", handler.PostCrawl)
+	mux.HandleFunc("POST /crawl/sitemap", handler.PostCrawlSitemap)
+	mux.HandleFunc("GET /crawl/frontier", handler.GetCrawlFrontier)
+	mux.HandleFunc("POST /crawl/pause", handler.PostCrawlPause)
+	mux.HandleFunc("POST /crawl/resume", handler.PostCrawlResume)
+	mux.HandleFunc("GET /crawl/jobs", handler.GetCrawlJobs)
+	mux.HandleFunc("GET /crawl/jobs/{id}", handler.GetCrawlJob)
+	mux.HandleFunc("DELETE /crawl/jobs/{id}", handler.DeleteCrawlJob)
+	mux.HandleFunc("POST /documents", handler.PostDocument)
+	mux.HandleFunc("POST /documents/_bulk", handler.PostDocumentsBulk)
+	mux.HandleFunc("GET /documents/tasks/{id}", handler.GetDocumentTask)
+
+	// Feed subscriptions
+	mux.HandleFunc("GET /feeds", handler.GetFeeds)
+	mux.HandleFunc("POST /feeds", handler.PostFeed)
+	mux.HandleFunc("DELETE /feeds/{id}", handler.DeleteFeed)
+	mux.HandleFunc("POST /feeds/{id}/poll", handler.PostPollFeed)
 
 	// Statistics and monitoring
 	mux.HandleFunc("GET /stats", handler.GetStats)
@@ -27,6 +45,21 @@ func NewServer(cfg *config.Config) *http.Server {
 		w.Write([]byte(`{"status":"healthy","timestamp":"` + time.Now().Format(time.RFC3339) + `"}`))
 	})
 
+	// Click-through reporting
+	mux.HandleFunc("GET /clicks/stats", handler.GetClickStats)
+	mux.HandleFunc("GET /clicks/low-ctr", handler.GetLowCTRQueries)
+
+	// Ranking profiles and online experiments
+	mux.HandleFunc("GET /ranking/profiles", handler.GetRankingProfiles)
+	mux.HandleFunc("GET /experiments", handler.GetExperiments)
+	mux.HandleFunc("GET /experiments/{name}", handler.GetExperiment)
+
+	// Admin endpoints
+	mux.HandleFunc("GET /admin/synonyms", handler.GetSynonyms)
+	mux.HandleFunc("POST /admin/synonyms/reload", handler.PostReloadSynonyms)
+	mux.HandleFunc("GET /admin/analyzers", handler.GetAnalyzers)
+	mux.HandleFunc("GET /admin/analyze", handler.GetAnalyze)
+
 	// Cache management endpoints
 	mux.HandleFunc("POST /cache/clear", handler.PostClearCache)
 	mux.HandleFunc("POST /cache/prewarm", handler.PostPrewarmCache)
@@ -36,8 +69,8 @@ func NewServer(cfg *config.Config) *http.Server {
 	corsMiddleware := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Document-Id, X-Document-Url, X-Document-Title")
 
 			if r.Method == "OPTIONS" {
 				w.WriteHeader(http.StatusOK)
@@ -0,0 +1,407 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Field names used by the index
+const (
+	FieldBody     = "body"
+	FieldTitle    = "title"
+	FieldHeadings = "headings"
+
+	// FieldBoilerplate holds navigation, footers and other page chrome, searched
+	// at a low weight so pages aren't found by their menus
+	FieldBoilerplate = "boilerplate"
+
+	// FieldStopwords holds the body stopwords removed by analysis. It is only
+	// searched when a query consists of nothing but stopwords.
+	FieldStopwords = "stopwords"
+)
+
+// Token is a term produced by analysis with its position in the source text.
+// Filters that drop tokens keep the remaining positions untouched, and filters
+// that add alternatives emit them at the position of the original token.
+type Token struct {
+	Term     string `json:"term"`
+	Position int    `json:"position"`
+}
+
+// CharFilter rewrites raw text before it is tokenized
+type CharFilter interface {
+	Filter(text string) string
+}
+
+// Tokenizer splits text into positioned tokens
+type Tokenizer interface {
+	Tokenize(text string) []Token
+}
+
+// TokenFilter transforms a token stream
+type TokenFilter interface {
+	Filter(tokens []Token) []Token
+}
+
+// stopwordFilter is implemented by filters that remove stopwords, so analysis
+// can keep track of what they removed
+type stopwordFilter interface {
+	split(tokens []Token) (kept, stopped []Token)
+}
+
+// languageAware filters adapt to the language of the text being analyzed
+type languageAware interface {
+	forLanguage(language string) TokenFilter
+}
+
+// Analyzer turns text into index terms: char filters, then a tokenizer, then token filters
+type Analyzer struct {
+	Name        string
+	CharFilters []CharFilter
+	Tokenizer   Tokenizer
+	Filters     []TokenFilter
+}
+
+// Analyze runs the full pipeline over text in the default language
+func (a *Analyzer) Analyze(text string) []Token {
+	return a.AnalyzeLang(text, "")
+}
+
+// AnalyzeLang runs the pipeline over text written in language. Language-aware
+// filters such as stemmers and stopword lists switch to that language unless
+// they were pinned to one in config.
+func (a *Analyzer) AnalyzeLang(text, language string) []Token {
+	tokens, _ := a.AnalyzeWithStopwords(text, language)
+	return tokens
+}
+
+// AnalyzeWithStopwords also returns the tokens removed by stop filters, as they
+// were when removed. Their positions are left as gaps in the analyzed tokens.
+func (a *Analyzer) AnalyzeWithStopwords(text, language string) (tokens, stopped []Token) {
+	for _, cf := range a.CharFilters {
+		text = cf.Filter(text)
+	}
+
+	tokens = a.Tokenizer.Tokenize(text)
+	for _, tf := range a.Filters {
+		if la, ok := tf.(languageAware); ok && language != "" {
+			tf = la.forLanguage(language)
+		}
+		if sf, ok := tf.(stopwordFilter); ok {
+			var removed []Token
+			tokens, removed = sf.split(tokens)
+			stopped = append(stopped, removed...)
+			continue
+		}
+		tokens = tf.Filter(tokens)
+	}
+	return tokens, stopped
+}
+
+// Terms analyzes text and returns just the terms, in order
+func (a *Analyzer) Terms(text string) []string {
+	return tokenTerms(a.Analyze(text))
+}
+
+// TermsLang analyzes text written in language and returns just the terms
+func (a *Analyzer) TermsLang(text, language string) []string {
+	return tokenTerms(a.AnalyzeLang(text, language))
+}
+
+func tokenTerms(tokens []Token) []string {
+	terms := make([]string, len(tokens))
+	for i, token := range tokens {
+		terms[i] = token.Term
+	}
+	return terms
+}
+
+var (
+	// stopwordLists override the Snowball stopword list of a language
+	stopwordLists = map[string]map[string]bool{}
+
+	analyzers      = builtinAnalyzers()
+	fieldAnalyzers = map[string]string{
+		FieldBody:  "standard",
+		FieldTitle: "standard",
+	}
+	analyzersMu sync.RWMutex
+)
+
+// builtinAnalyzers are always available and can be referenced from config
+func builtinAnalyzers() map[string]*Analyzer {
+	return map[string]*Analyzer{
+		// Normalize, strip punctuation, split on whitespace, case fold, drop
+		// stopwords and stem
+		"standard": {
+			Name:        "standard",
+			CharFilters: []CharFilter{nfkcFilter{}, stripPunctuationFilter{}},
+			Tokenizer:   whitespaceTokenizer{},
+			Filters: []TokenFilter{
+				caseFoldFilter{},
+				stopFilter{language: DefaultLanguage, useLanguage: true},
+				stemmerFilter{language: DefaultLanguage},
+			},
+		},
+		// Split on anything that isn't part of a word and case fold, no stemming
+		"simple": {
+			Name:        "simple",
+			CharFilters: []CharFilter{nfkcFilter{}},
+			Tokenizer:   standardTokenizer{},
+			Filters:     []TokenFilter{caseFoldFilter{}},
+		},
+		// Technical text: identifiers, versions, paths and URLs whole and in parts
+		"code": {
+			Name:        "code",
+			CharFilters: []CharFilter{nfkcFilter{}},
+			Tokenizer:   codeTokenizer{},
+			Filters:     []TokenFilter{caseFoldFilter{}},
+		},
+		"whitespace": {
+			Name:      "whitespace",
+			Tokenizer: whitespaceTokenizer{},
+		},
+		// The whole input as one lowercased term, for identifiers and tags
+		"keyword": {
+			Name:      "keyword",
+			Tokenizer: keywordTokenizer{},
+			Filters:   []TokenFilter{lowercaseFilter{}},
+		},
+	}
+}
+
+// AnalyzerFor returns the analyzer assigned to a field, the body analyzer for unknown fields
+func AnalyzerFor(field string) *Analyzer {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+
+	name, ok := fieldAnalyzers[field]
+	if !ok {
+		name = fieldAnalyzers[FieldBody]
+	}
+	return analyzers[name]
+}
+
+// GetAnalyzer returns a named analyzer, or nil if it doesn't exist
+func GetAnalyzer(name string) *Analyzer {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+	return analyzers[name]
+}
+
+// FieldAnalyzers returns the analyzer name assigned to each field
+func FieldAnalyzers() map[string]string {
+	analyzersMu.RLock()
+	defer analyzersMu.RUnlock()
+
+	assigned := make(map[string]string, len(fieldAnalyzers))
+	for field, name := range fieldAnalyzers {
+		assigned[field] = name
+	}
+	return assigned
+}
+
+// analysisConfig is the on-disk format of ANALYZERS_CONFIG:
+//
+//	{
+//	  "analyzers": {
+//	    "english_stop": {
+//	      "char_filters": ["strip_punctuation"],
+//	      "tokenizer": "whitespace",
+//	      "filters": ["lowercase", {"type": "stop", "language": "english"}, "stemmer"]
+//	    }
+//	  },
+//	  "fields": {"body": "english_stop", "title": "simple"},
+//	  "stopwords": {"english": "config/stopwords_en.txt", "german": ["der", "die", "das"]}
+//	}
+//
+// Stopword lists are given inline or as a file with one word per line, and
+// replace the built-in list of the language for every stop filter using it.
+type analysisConfig struct {
+	Analyzers map[string]analyzerSpec  `json:"analyzers"`
+	Fields    map[string]string        `json:"fields"`
+	Stopwords map[string]stopwordsSpec `json:"stopwords"`
+}
+
+// stopwordsSpec is a list of words or the path of a stopword file
+type stopwordsSpec []string
+
+func (s *stopwordsSpec) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err != nil {
+		return json.Unmarshal(data, (*[]string)(s))
+	}
+
+	words, err := readWordList(path)
+	if err != nil {
+		return err
+	}
+	*s = words
+	return nil
+}
+
+type analyzerSpec struct {
+	CharFilters []componentSpec `json:"char_filters"`
+	Tokenizer   componentSpec   `json:"tokenizer"`
+	Filters     []componentSpec `json:"filters"`
+}
+
+// componentSpec is a pipeline component given either by name or as an object
+// with a "type" and its parameters
+type componentSpec struct {
+	Type   string
+	Params map[string]interface{}
+}
+
+func (c *componentSpec) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		c.Type = name
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &c.Params); err != nil {
+		return fmt.Errorf("component must be a name or an object: %w", err)
+	}
+	c.Type, _ = c.Params["type"].(string)
+	if c.Type == "" {
+		return fmt.Errorf("component object without a \"type\"")
+	}
+	return nil
+}
+
+func (c componentSpec) String(key, def string) string {
+	if v, ok := c.Params[key].(string); ok {
+		return v
+	}
+	return def
+}
+
+func (c componentSpec) Int(key string, def int) int {
+	if v, ok := c.Params[key].(float64); ok {
+		return int(v)
+	}
+	return def
+}
+
+func (c componentSpec) Bool(key string, def bool) bool {
+	if v, ok := c.Params[key].(bool); ok {
+		return v
+	}
+	return def
+}
+
+func (c componentSpec) Strings(key string) []string {
+	raw, _ := c.Params[key].([]interface{})
+	values := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if s, ok := v.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// LoadAnalysisConfig defines named analyzers and assigns them to fields. Documents
+// indexed before a change keep the terms of the old analyzers until re-indexed.
+func LoadAnalysisConfig(path string) (err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading analysis config: %w", err)
+	}
+
+	var ac analysisConfig
+	if err := json.Unmarshal(data, &ac); err != nil {
+		return fmt.Errorf("parsing analysis config: %w", err)
+	}
+
+	lists := make(map[string]map[string]bool, len(ac.Stopwords))
+	for language, words := range ac.Stopwords {
+		lists[language] = wordSet(words)
+	}
+
+	// Stop filters check their language against the new lists while being
+	// built, the old lists come back if the config turns out to be invalid
+	analyzersMu.Lock()
+	previousLists := stopwordLists
+	stopwordLists = lists
+	analyzersMu.Unlock()
+	defer func() {
+		if err != nil {
+			analyzersMu.Lock()
+			stopwordLists = previousLists
+			analyzersMu.Unlock()
+		}
+	}()
+
+	defined := builtinAnalyzers()
+	for name, spec := range ac.Analyzers {
+		analyzer, err := buildAnalyzer(name, spec)
+		if err != nil {
+			return fmt.Errorf("analyzer %q: %w", name, err)
+		}
+		defined[name] = analyzer
+	}
+
+	assigned := map[string]string{
+		FieldBody:  "standard",
+		FieldTitle: "standard",
+	}
+	for field, name := range ac.Fields {
+		if _, ok := defined[name]; !ok {
+			return fmt.Errorf("field %q: unknown analyzer %q", field, name)
+		}
+		assigned[field] = name
+	}
+
+	analyzersMu.Lock()
+	analyzers = defined
+	fieldAnalyzers = assigned
+	analyzersMu.Unlock()
+	return nil
+}
+
+func buildAnalyzer(name string, spec analyzerSpec) (*Analyzer, error) {
+	analyzer := &Analyzer{Name: name}
+
+	for _, cs := range spec.CharFilters {
+		factory, ok := charFilterFactories[cs.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown char filter %q", cs.Type)
+		}
+		cf, err := factory(cs)
+		if err != nil {
+			return nil, fmt.Errorf("char filter %q: %w", cs.Type, err)
+		}
+		analyzer.CharFilters = append(analyzer.CharFilters, cf)
+	}
+
+	if spec.Tokenizer.Type == "" {
+		spec.Tokenizer.Type = "standard"
+	}
+	factory, ok := tokenizerFactories[spec.Tokenizer.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown tokenizer %q", spec.Tokenizer.Type)
+	}
+	tokenizer, err := factory(spec.Tokenizer)
+	if err != nil {
+		return nil, fmt.Errorf("tokenizer %q: %w", spec.Tokenizer.Type, err)
+	}
+	analyzer.Tokenizer = tokenizer
+
+	for _, fs := range spec.Filters {
+		factory, ok := tokenFilterFactories[fs.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown token filter %q", fs.Type)
+		}
+		tf, err := factory(fs)
+		if err != nil {
+			return nil, fmt.Errorf("token filter %q: %w", fs.Type, err)
+		}
+		analyzer.Filters = append(analyzer.Filters, tf)
+	}
+
+	return analyzer, nil
+}
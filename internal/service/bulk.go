@@ -0,0 +1,273 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Bulk requests are read in batches: a batch is analyzed by a few workers and
+// added to the index under one lock before the next lines are read, so a large
+// request never holds more than one batch in memory.
+const (
+	bulkBatchSize = 100
+	bulkWorkers   = 4
+)
+
+// Bulk actions
+const (
+	BulkIndex  = "index"  // add or replace a document
+	BulkCreate = "create" // add a document that isn't indexed yet
+	BulkDelete = "delete" // remove a document
+)
+
+// BulkItem is the outcome of one action of a bulk request
+type BulkItem struct {
+	Line   int    `json:"line"` // of the action line, counting from 1
+	Action string `json:"action"`
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Tokens int    `json:"tokens,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkResponse reports every action of a bulk request in request order
+type BulkResponse struct {
+	Took   int64      `json:"took"` // milliseconds
+	Errors bool       `json:"errors"`
+	Items  []BulkItem `json:"items"`
+	Error  string     `json:"error,omitempty"` // why the request stopped early
+}
+
+// bulkAction is an action line: {"index": {"_id": "...", "url": "..."}}
+type bulkAction struct {
+	ID  string `json:"_id"`
+	URL string `json:"url"`
+}
+
+// pendingIndex is an index or create action waiting for its batch to run
+type pendingIndex struct {
+	item *BulkItem
+	doc  *PushedDocument
+}
+
+// Bulk applies newline-delimited JSON actions to the main index, in the style
+// of Elasticsearch's bulk API. Each index or create action line is followed by
+// a PushedDocument line, delete actions stand alone:
+//
+//	{"index": {"_id": "TICKET-1"}}
+//	{"title": "Login outage", "body": "..."}
+//	{"delete": {"_id": "TICKET-0"}}
+//
+// Failed actions are reported in the response and don't stop the request. An
+// error is returned when the body can't be read any further, for example past
+// the request size limit, along with the results of the actions before it.
+// progress, if not nil, is called whenever a batch of actions is done.
+func Bulk(r io.Reader, maxLineBytes int, progress func()) (*BulkResponse, error) {
+	start := time.Now()
+	resp := &BulkResponse{Items: []BulkItem{}}
+	var items []*BulkItem
+
+	var batch []pendingIndex
+	batched := make(map[string]bool)
+	deletes := 0
+	flush := func() {
+		runBulkBatch(batch)
+		batch = batch[:0]
+		batched = make(map[string]bool)
+		if progress != nil {
+			progress()
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	line := 0
+	next := func() ([]byte, bool) {
+		for scanner.Scan() {
+			line++
+			if text := scanner.Bytes(); len(bytes.TrimSpace(text)) > 0 {
+				return text, true
+			}
+		}
+		return nil, false
+	}
+
+	for {
+		text, ok := next()
+		if !ok {
+			break
+		}
+		item := &BulkItem{Line: line}
+		items = append(items, item)
+
+		action, meta, err := parseBulkAction(text)
+		if err != nil {
+			item.Status, item.Error = http.StatusBadRequest, err.Error()
+			continue
+		}
+		item.Action = action
+
+		if action == BulkDelete {
+			// Earlier actions on the document must happen first
+			if batched[meta.docID()] {
+				flush()
+			}
+			item.ID = meta.docID()
+			switch found, err := DeleteDocument(item.ID); {
+			case err != nil:
+				item.Status, item.Error = http.StatusBadRequest, err.Error()
+			case !found:
+				item.Status, item.Error = http.StatusNotFound, "document not found"
+			default:
+				item.Status = http.StatusOK
+			}
+			if deletes++; deletes%bulkBatchSize == 0 && progress != nil {
+				progress()
+			}
+			continue
+		}
+
+		source, ok := next()
+		if !ok {
+			item.Status, item.Error = http.StatusBadRequest, "missing document line after action"
+			break
+		}
+		doc := &PushedDocument{}
+		if err := json.Unmarshal(source, doc); err != nil {
+			item.Status, item.Error = http.StatusBadRequest, fmt.Sprintf("line %d: invalid document: %v", line, err)
+			continue
+		}
+		if meta.ID != "" {
+			doc.ID = meta.ID
+		}
+		if meta.URL != "" {
+			doc.URL = meta.URL
+		}
+		if err := doc.Validate(); err != nil {
+			item.Status, item.Error = http.StatusBadRequest, err.Error()
+			continue
+		}
+		item.ID = doc.docID()
+
+		if batched[item.ID] {
+			flush()
+		}
+		if action == BulkCreate && InvertedIndex.HasDocument(item.ID) {
+			item.Status, item.Error = http.StatusConflict, "document already exists"
+			continue
+		}
+		batch = append(batch, pendingIndex{item: item, doc: doc})
+		batched[item.ID] = true
+		if len(batch) >= bulkBatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	err := scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		err = fmt.Errorf("line %d is longer than %d bytes", line+1, maxLineBytes)
+	}
+
+	for _, item := range items {
+		if item.Status >= 300 {
+			resp.Errors = true
+		}
+		resp.Items = append(resp.Items, *item)
+	}
+	resp.Took = time.Since(start).Milliseconds()
+	if err != nil {
+		resp.Errors, resp.Error = true, err.Error()
+	}
+	return resp, err
+}
+
+func parseBulkAction(text []byte) (string, bulkAction, error) {
+	var line map[string]bulkAction
+	if err := json.Unmarshal(text, &line); err != nil {
+		return "", bulkAction{}, fmt.Errorf("invalid action: %v", err)
+	}
+	if len(line) != 1 {
+		return "", bulkAction{}, fmt.Errorf("an action line must have exactly one of index, create or delete")
+	}
+	for action, meta := range line {
+		switch action {
+		case BulkIndex, BulkCreate:
+			return action, meta, nil
+		case BulkDelete:
+			if meta.ID == "" && meta.URL == "" {
+				return "", meta, fmt.Errorf("delete needs an _id or a url")
+			}
+			return action, meta, nil
+		default:
+			return "", meta, fmt.Errorf("unknown action %q", action)
+		}
+	}
+	return "", bulkAction{}, nil
+}
+
+func (a bulkAction) docID() string {
+	if a.ID != "" {
+		return a.ID
+	}
+	return documentID(a.URL)
+}
+
+// runBulkBatch stores and analyzes a batch concurrently, then indexes the
+// documents that succeeded in one go
+func runBulkBatch(batch []pendingIndex) {
+	if len(batch) == 0 {
+		return
+	}
+
+	prepared := make([]*analyzedDocument, len(batch))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(bulkWorkers, len(batch)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				doc, err := preparePushed(batch[i].doc)
+				if err != nil {
+					batch[i].item.Status, batch[i].item.Error = http.StatusUnprocessableEntity, err.Error()
+					continue
+				}
+				prepared[i] = doc
+			}
+		}()
+	}
+	for i := range batch {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var docs []*analyzedDocument
+	var indexed []*BulkItem
+	for i, doc := range prepared {
+		if doc != nil {
+			docs = append(docs, doc)
+			indexed = append(indexed, batch[i].item)
+		}
+	}
+
+	replaced := InvertedIndex.replaceDocuments(docs)
+	for i, doc := range docs {
+		indexed[i].Tokens = len(doc.fields[FieldBody])
+		indexed[i].Status = http.StatusCreated
+		if replaced[i] {
+			indexed[i].Status = http.StatusOK
+		}
+		recordIndexed(InvertedIndex, doc.docID, indexed[i].Tokens)
+	}
+	log.Printf("Bulk batch indexed %d of %d documents", len(docs), len(batch))
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+var xmlEncodingDecl = regexp.MustCompile(`^\s*<\?xml[^>]*encoding=["']([A-Za-z0-9._:-]+)["']`)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// decodeCharset transcodes a fetched document to UTF-8 and returns the charset
+// it was decoded from. The charset comes from a byte order mark, the charset
+// parameter of the Content-Type header, an XML declaration or <meta charset>,
+// in that order. Undeclared documents are UTF-8 if they are valid UTF-8 and
+// Windows-1252 otherwise, as browsers do. PDFs carry their own encodings and
+// are returned untouched.
+func decodeCharset(data []byte, header, contentType string) ([]byte, string) {
+	if contentType == ContentTypePDF {
+		return data, ""
+	}
+
+	enc, name, certain := charset.DetermineEncoding(data, header)
+	if !certain && contentType == ContentTypeXML {
+		if m := xmlEncodingDecl.FindSubmatch(data[:min(len(data), 1024)]); m != nil {
+			if xmlEnc, xmlName := charset.Lookup(string(m[1])); xmlEnc != nil {
+				enc, name = xmlEnc, xmlName
+			}
+		}
+	}
+
+	// DetermineEncoding only looks at the first KB, non-ASCII text may come later
+	if !certain && name == "windows-1252" && utf8.Valid(data) {
+		name = "utf-8"
+	}
+	if name == "utf-8" {
+		return bytes.ToValidUTF8(bytes.TrimPrefix(data, utf8BOM), nil), name
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return bytes.ToValidUTF8(data, nil), "utf-8"
+	}
+	return decoded, name
+}
+
+// decodeRecordedCharset transcodes a stored document from the charset found
+// when it was fetched, detecting it again only when none was recorded or the
+// recorded one doesn't decode
+func decodeRecordedCharset(data []byte, recorded, header, contentType string) ([]byte, string) {
+	if recorded == "" || contentType == ContentTypePDF {
+		return decodeCharset(data, header, contentType)
+	}
+	enc, name := charset.Lookup(recorded)
+	if enc == nil {
+		return decodeCharset(data, header, contentType)
+	}
+	if name == "utf-8" {
+		return bytes.ToValidUTF8(bytes.TrimPrefix(data, utf8BOM), nil), name
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return decodeCharset(data, header, contentType)
+	}
+	return decoded, name
+}
+
+// newXMLDecoder returns a lenient decoder for sitemaps and feeds, which
+// transcodes documents that declare an encoding other than UTF-8
+func newXMLDecoder(data []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.CharsetReader = charset.NewReaderLabel
+	return decoder
+}
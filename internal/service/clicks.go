@@ -0,0 +1,242 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mush1e/IndexStream-v2/internal/database"
+)
+
+// ErrNoDatabase is returned by operations that need persistence when no database is configured
+var ErrNoDatabase = errors.New("database not configured")
+
+// store persists search and click logs, nil when running without a database
+var store *database.DB
+
+// Clicks holds the click model used to boost documents users pick for a query
+var Clicks = NewClickModel()
+
+// SetDatabase enables persistence, loads previously logged clicks into the
+// click model and restores the experiment totals of earlier runs
+func SetDatabase(db *database.DB) {
+	store = db
+	if db == nil {
+		return
+	}
+
+	if err := Experiments.loadTotals(db); err != nil {
+		log.Printf("Failed to load experiment totals: %v", err)
+	}
+
+	clicks, err := db.GetClicks(100000)
+	if err != nil {
+		log.Printf("Failed to load click history: %v", err)
+		return
+	}
+	for _, click := range clicks {
+		Clicks.Add(click.Query, click.DocID, click.Position)
+	}
+	log.Printf("Loaded %d clicks into click model", len(clicks))
+}
+
+// NormalizeQuery canonicalizes a query string so logs and the click model agree on identity
+func NormalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// LogSearch records a served query, silently skipped without a database
+func LogSearch(query string, results int, duration time.Duration) {
+	if store == nil {
+		return
+	}
+	if err := store.LogSearchQuery(NormalizeQuery(query), results, duration); err != nil {
+		log.Printf("Failed to log search %q: %v", query, err)
+	}
+}
+
+// RecordServed remembers which documents a user was shown for a query, so
+// later clicks can be checked against them
+func RecordServed(userID, query string, results []SearchResult) {
+	if userID == "" || len(results) == 0 {
+		return
+	}
+	docIDs := make([]string, len(results))
+	for i, result := range results {
+		docIDs[i] = result.DocID
+	}
+	served.add(userID, NormalizeQuery(query), docIDs)
+}
+
+// RecordClick logs a result click and feeds it into the click model. Only the
+// first click by a user on a document they were recently shown for the query
+// counts, at the position it was served at.
+func RecordClick(userID, query, docID string) {
+	query = NormalizeQuery(query)
+	position, ok := served.click(userID, query, docID)
+	if !ok {
+		return
+	}
+	Clicks.Add(query, docID, position)
+
+	if store == nil {
+		return
+	}
+	if err := store.LogClick(query, docID, position); err != nil {
+		log.Printf("Failed to log click on %q for %q: %v", docID, query, err)
+	}
+}
+
+// ClickStats returns aggregated click-through statistics, for one query or all of them
+func ClickStats(query string, limit int) ([]database.ClickStat, error) {
+	if store == nil {
+		return nil, ErrNoDatabase
+	}
+	return store.GetClickStats(NormalizeQuery(query), limit)
+}
+
+// LowCTRQueries returns frequently searched queries that rarely lead to a click
+func LowCTRQueries(minSearches, limit int) ([]database.QueryCTR, error) {
+	if store == nil {
+		return nil, ErrNoDatabase
+	}
+	return store.GetLowCTRQueries(minSearches, limit)
+}
+
+// servedTTL is how long a served result list accepts clicks
+const servedTTL = time.Hour
+
+// served holds the result lists recently shown to each user
+var served = newServedResults()
+
+type servedList struct {
+	docIDs   []string
+	clicked  map[string]bool
+	servedAt time.Time
+}
+
+type servedEntry struct {
+	key      string
+	servedAt time.Time
+}
+
+// servedResults tracks result lists per user and query. Lists are queued in
+// the order they were served, so expired ones are dropped from the front.
+type servedResults struct {
+	mu    sync.Mutex
+	lists map[string]*servedList
+	queue []servedEntry
+}
+
+func newServedResults() *servedResults {
+	return &servedResults{lists: make(map[string]*servedList)}
+}
+
+func servedKey(userID, query string) string {
+	return userID + "\x00" + query
+}
+
+func (s *servedResults) add(userID, query string, docIDs []string) {
+	now := time.Now()
+	key := servedKey(userID, query)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked(now)
+	list := &servedList{docIDs: docIDs, clicked: make(map[string]bool), servedAt: now}
+	if previous := s.lists[key]; previous != nil {
+		// Re-running a query must not allow clicking the same result again
+		list.clicked = previous.clicked
+	}
+	s.lists[key] = list
+	s.queue = append(s.queue, servedEntry{key: key, servedAt: now})
+}
+
+// click marks docID as clicked and returns the 1-based position it was served
+// at, false when the document wasn't served to the user or was already clicked
+func (s *servedResults) click(userID, query, docID string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked(time.Now())
+	list := s.lists[servedKey(userID, query)]
+	if list == nil || list.clicked[docID] {
+		return 0, false
+	}
+
+	for i, id := range list.docIDs {
+		if id == docID {
+			list.clicked[docID] = true
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// pruneLocked drops lists served longer than servedTTL ago
+func (s *servedResults) pruneLocked(now time.Time) {
+	cutoff := now.Add(-servedTTL)
+	n := 0
+	for n < len(s.queue) && s.queue[n].servedAt.Before(cutoff) {
+		entry := s.queue[n]
+		if list := s.lists[entry.key]; list != nil && list.servedAt.Equal(entry.servedAt) {
+			delete(s.lists, entry.key)
+		}
+		n++
+	}
+	s.queue = s.queue[n:]
+}
+
+// ClickModel accumulates position-debiased click evidence per query and document.
+// A click at rank p is weighted by log2(p+1), since lower ranks are examined less
+// often and a click there is stronger evidence of relevance.
+type ClickModel struct {
+	mu      sync.RWMutex
+	weights map[string]map[string]float64
+	clicks  map[string]int // clicks per query, which versions its boosts
+}
+
+// NewClickModel creates an empty click model
+func NewClickModel() *ClickModel {
+	return &ClickModel{
+		weights: make(map[string]map[string]float64),
+		clicks:  make(map[string]int),
+	}
+}
+
+// Add records a click at a 1-based result position
+func (m *ClickModel) Add(query, docID string, position int) {
+	if position < 1 {
+		position = 1
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.weights[query] == nil {
+		m.weights[query] = make(map[string]float64)
+	}
+	m.weights[query][docID] += math.Log2(float64(position) + 1)
+	m.clicks[query]++
+}
+
+// Version changes whenever a click is added for a normalized query, so
+// results cached with the query's boosts can tell they're stale
+func (m *ClickModel) Version(query string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.clicks[query]
+}
+
+// Boost returns the additive score boost for a document under a normalized query
+func (m *ClickModel) Boost(query, docID string, weight float64) float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return weight * math.Log1p(m.weights[query][docID])
+}
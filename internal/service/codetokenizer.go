@@ -0,0 +1,127 @@
+package service
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// codeTokenizer is meant for technical text. It keeps identifiers, versions,
+// emails, URLs and file paths whole, and also emits their sub-parts at the same
+// position, so `net/http` matches "net/http", "net" and "http", and
+// `camelCaseNames` matches "camel", "case" and "names".
+type codeTokenizer struct{}
+
+// codeBreakers end a token wherever they appear; they never occur inside
+// identifiers, paths or URLs
+const codeBreakers = ",;\"()[]{}<>!|`"
+
+// Sentence punctuation and quotes trimmed from the edges of a token
+const (
+	codeLeadingTrim  = "'*"
+	codeTrailingTrim = "'.:?*"
+)
+
+var versionPattern = regexp.MustCompile(`^[vV]?\d+(\.\d+)+([-+][0-9A-Za-z.-]+)?$`)
+
+func (codeTokenizer) Tokenize(text string) []Token {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(codeBreakers, r)
+	})
+
+	var tokens []Token
+	position := 0
+	for _, word := range words {
+		word = strings.TrimRight(strings.TrimLeft(word, codeLeadingTrim), codeTrailingTrim)
+		if !strings.ContainsFunc(word, isWordRune) {
+			continue
+		}
+
+		parts := codeParts(word)
+		if len(parts) == 0 {
+			// A plain word, possibly in a script written without spaces
+			for _, term := range segmentWords([]string{word}) {
+				tokens = append(tokens, Token{Term: term.Term, Position: position})
+				position++
+			}
+			continue
+		}
+
+		tokens = append(tokens, Token{Term: word, Position: position})
+		for _, part := range parts {
+			tokens = append(tokens, Token{Term: part, Position: position})
+		}
+		position++
+	}
+	return tokens
+}
+
+// codeParts returns the searchable sub-parts of a structured token, or nil for
+// a plain word
+func codeParts(word string) []string {
+	var parts []string
+	seen := map[string]bool{word: true}
+	add := func(part string) {
+		if part != "" && !seen[part] {
+			seen[part] = true
+			parts = append(parts, part)
+		}
+	}
+
+	// Versions match by their number and release prefixes: v1.23.5 -> 1.23.5, 1.23
+	if versionPattern.MatchString(word) {
+		number := strings.TrimLeft(word, "vV")
+		add(number)
+		core, _, _ := strings.Cut(strings.SplitN(number, "+", 2)[0], "-")
+		for i := len(core) - 1; i > 0; i-- {
+			if core[i] == '.' {
+				add(core[:i])
+			}
+		}
+		return parts
+	}
+
+	rest := word
+	if u, err := url.Parse(word); err == nil && u.Scheme != "" && u.Host != "" {
+		add(u.Hostname())
+		rest = u.Hostname() + " " + u.Path + " " + u.RawQuery + " " + u.Fragment
+	} else if local, domain, ok := strings.Cut(word, "@"); ok && local != "" && strings.Contains(domain, ".") {
+		add(local)
+		add(domain)
+	} else if i := strings.LastIndexAny(word, `/\`); i >= 0 {
+		// File paths also match by file name
+		add(word[i+1:])
+	}
+
+	for _, piece := range strings.FieldsFunc(rest, func(r rune) bool { return !isWordRune(r) }) {
+		if piece != word {
+			add(piece)
+		}
+		if subwords := splitCamelCase(piece); len(subwords) > 1 {
+			for _, subword := range subwords {
+				add(subword)
+			}
+		}
+	}
+	return parts
+}
+
+// splitCamelCase splits at lower-to-upper transitions and before the last
+// capital of an acronym: "parseHTTPRequest" -> parse, HTTP, Request
+func splitCamelCase(word string) []string {
+	runes := []rune(word)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		lowerToUpper := (unicode.IsLower(prev) || unicode.IsDigit(prev)) && unicode.IsUpper(cur)
+		acronymEnd := unicode.IsUpper(prev) && unicode.IsUpper(cur) &&
+			i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if lowerToUpper || acronymEnd {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
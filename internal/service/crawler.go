@@ -1,10 +1,11 @@
 package service
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
@@ -12,6 +13,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/mush1e/IndexStream-v2/config"
 	"golang.org/x/net/html"
@@ -23,6 +25,38 @@ var cfg *config.Config = config.Get()
 var DocURLMap = make(map[string]string)
 var docURLMu sync.RWMutex
 
+// FetchInfo records what the crawler learned about a document from its HTTP
+// response, and from the feed that linked to it. For pushed documents it keeps
+// what the pusher stated, so it's applied again whenever they're reindexed.
+type FetchInfo struct {
+	ContentType     string          `json:"content_type,omitempty"`
+	ContentLanguage string          `json:"content_language,omitempty"`
+	Charset         string          `json:"charset,omitempty"`   // the charset the body was decoded from
+	Published       *time.Time      `json:"published,omitempty"` // the date of the feed entry
+	Pushed          *PushedDocument `json:"pushed,omitempty"`    // without its body, which is stored
+}
+
+// docFetchInfo is keyed by document ID and guarded by docURLMu
+var docFetchInfo = make(map[string]*FetchInfo)
+
+// DocumentFetchInfo returns the response details recorded for a document, never nil
+func DocumentFetchInfo(docID string) *FetchInfo {
+	docURLMu.RLock()
+	defer docURLMu.RUnlock()
+	if info, ok := docFetchInfo[docID]; ok {
+		return info
+	}
+	return &FetchInfo{}
+}
+
+// DocumentURL returns the URL a document was fetched from
+func DocumentURL(docID string) (string, bool) {
+	docURLMu.RLock()
+	defer docURLMu.RUnlock()
+	url, ok := DocURLMap[docID]
+	return url, ok
+}
+
 // Helper function to check if URL is valid HTTP(S)
 func isValidHTTPURL(u *url.URL) bool {
 	return u.Host != "" && (u.Scheme == "https" || u.Scheme == "http")
@@ -95,62 +129,134 @@ func URLExtractor(fileContent []byte, baseURL string) (map[string]struct{}, erro
 	return extractedURLs, nil
 }
 
-func hashAndStore(url string, file_contents []byte) error {
+// documentID derives the ID a document is stored and indexed under from its URL
+func documentID(url string) string {
 	sum := sha256.Sum256([]byte(url))
-	docID := hex.EncodeToString(sum[:])
+	return hex.EncodeToString(sum[:])
+}
+
+func hashAndStore(url string, file_contents []byte, info *FetchInfo, stats *CrawlStats) error {
+	file_path, err := storeDocument(documentID(url), url, file_contents, info)
+	if err != nil {
+		return err
+	}
+
+	trackIndexing(file_path, stats)
+	IndexTargetChan <- file_path
+
+	return nil
+}
+
+// storeDocument saves a document in the data directory under its ID and the
+// extension of its content type, and returns the stored file's path
+func storeDocument(docID, url string, file_contents []byte, info *FetchInfo) (string, error) {
+	if info == nil {
+		info = &FetchInfo{}
+	}
+	contentType := DetectContentType(info.ContentType, url, file_contents)
+	if ExtractorFor(contentType) == nil {
+		return "", fmt.Errorf("unsupported content type %q for %s", info.ContentType, url)
+	}
+	name := docID + contentTypeExtensions[contentType]
 
 	if err := os.MkdirAll(cfg.DataURL, 0755); err != nil {
 		log.Printf("Error generating data dump dir\n\terr : %v\n", err)
-		return err
+		return "", err
 	}
 
-	file_path := filepath.Join(cfg.DataURL, docID+".html")
+	file_path := filepath.Join(cfg.DataURL, name)
 	if err := os.WriteFile(file_path, file_contents, 0644); err != nil {
 		log.Printf("Error writing to dump file\n\terr : %v\n", err)
-		return err
+		return "", err
+	}
+	log.Printf("Saved %s for %q", name, url)
+
+	if err := appendSnapshotLog(cfg.DataURL, snapshotRecord{DocID: docID, URL: url, FetchInfo: *info}); err != nil {
+		log.Printf("Error recording %s in %s: %v", name, snapshotLogName, err)
 	}
-	log.Printf("Saved %s.html for %q", docID, url)
 
 	docURLMu.Lock()
-	DocURLMap[docID] = url
+	if url != "" {
+		DocURLMap[docID] = url
+	}
+	docFetchInfo[docID] = info
 	docURLMu.Unlock()
 
-	IndexTargetChan <- file_path
+	return file_path, nil
+}
 
-	return nil
+// analyzeAndStore analyzes a document and stores it only once that succeeded,
+// so a document that can't be indexed leaves the snapshot as it was. It
+// returns the analyzed document and the stored file's path.
+func analyzeAndStore(docID, url string, data []byte, info *FetchInfo, push *PushedDocument) (*analyzedDocument, string, error) {
+	doc, err := analyzeDocument(docID, url, url, data, info, push)
+	if err != nil {
+		return nil, "", fmt.Errorf("indexing %s: %w", docID, err)
+	}
+
+	stored, err := storeDocument(docID, url, data, info)
+	if err != nil {
+		return nil, "", err
+	}
+	removeStaleCopies(docID, stored)
+	return doc, stored, nil
+}
+
+// Crawl fetches a page, stores it and queues it for indexing, returning the
+// links found on it. The fetch is abandoned once ctx is done.
+func Crawl(ctx context.Context, url string) (map[string]struct{}, error) {
+	return crawlPage(ctx, url, nil, nil)
 }
 
-func Crawl(url string) (map[string]struct{}, error) {
+// crawlPage fetches, stores and queues a page for indexing like Crawl, giving
+// it a publish date when one is known from elsewhere and counting its traffic
+// in stats, which may be nil. The outcome is left to the caller to count, as
+// a failed fetch may be tried again.
+func crawlPage(ctx context.Context, url string, published *time.Time, stats *CrawlStats) (urls map[string]struct{}, err error) {
+	rules, err := checkRobots(url)
+	if err != nil {
+		log.Printf("Skipping %q: %v", url, err)
+		return nil, err
+	}
 
 	log.Printf("Starting crawl on %q\n", url)
 	defer log.Printf("Finished crawling %q\n", url)
 
-	resp, err := http.Get(url)
+	resp, body_content, err := politeGet(ctx, url, rules.CrawlDelay, stats)
 
 	if err != nil {
 		log.Printf("Failed to fetch %q\n\tnerr : %v\n", url, err)
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("Non-OK status for %q : %v", url, resp.StatusCode)
-		return nil, err
+		return nil, &statusError{url: url, status: resp.Status, code: resp.StatusCode}
 	}
 
-	body_content, err := io.ReadAll(resp.Body)
+	archiveResponse(resp, body_content)
 
-	if err != nil {
-		log.Printf("Error reading content of %q\n\terr : %v\n", url, err)
-		return nil, err
+	info := &FetchInfo{
+		ContentType:     resp.Header.Get("Content-Type"),
+		ContentLanguage: resp.Header.Get("Content-Language"),
+		Published:       published,
 	}
 
-	if err := hashAndStore(url, body_content); err != nil {
+	// The body is stored as fetched; links are read from its UTF-8 decoding
+	contentType := DetectContentType(info.ContentType, url, body_content)
+	decoded, charsetName := decodeCharset(body_content, info.ContentType, contentType)
+	info.Charset = charsetName
+
+	if err := hashAndStore(url, body_content, info, stats); err != nil {
 		return nil, err
 	}
 
-	urlList, err := URLExtractor(body_content, url)
+	// Only HTML pages have links to follow
+	if contentType != ContentTypeHTML {
+		return map[string]struct{}{}, nil
+	}
+
+	urlList, err := URLExtractor(decoded, url)
 	if err != nil {
 		return nil, err
 	}
@@ -158,80 +264,212 @@ func Crawl(url string) (map[string]struct{}, error) {
 	return urlList, nil
 }
 
-// This function has borderline excessive documentation since its a bit complicated
-func CrawlRecursive(seedURL string) {
-
-	// Retrieve MAX CRAWL DEPTH from config struct
-	maxDepth := cfg.SearchDepth
-
-	// A hash set of visited urls to dedupe results
-	visitedURLs := make(map[string]struct{})
+// CrawlStats counts what happened to the pages a crawl tried to fetch. Its
+// methods are safe for concurrent use and do nothing on a nil *CrawlStats.
+type CrawlStats struct {
+	mu         sync.Mutex
+	Fetched    int   `json:"fetched"`
+	Failed     int   `json:"failed"`
+	Retried    int   `json:"retried"`    // fetches tried again after an error
+	Disallowed int   `json:"disallowed"` // kept out by robots.txt
+	Unchanged  int   `json:"unchanged"`  // sitemap pages not modified since indexed
+	Indexed    int   `json:"indexed"`
+	Throttled  int   `json:"throttled"` // 429 and 503 responses
+	Bytes      int64 `json:"bytes"`
+	Waited     int64 `json:"waited_ms"` // spent waiting on politeness limits
+
+	indexing int // pages stored and waiting to be indexed
+}
 
-	// mutex to safely have multiple go routines update visitedURLS
-	var mu sync.RWMutex
+func (s *CrawlStats) record(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		s.Fetched++
+	case errors.Is(err, context.Canceled):
+		// A cancelled crawl neither fetched nor failed the page
+	case errors.Is(err, ErrDisallowed):
+		s.Disallowed++
+	default:
+		s.Failed++
+	}
+}
 
-	// Created a wait group to wait for all go routines to end before returning from function
-	var wg sync.WaitGroup
+func (s *CrawlStats) addRetried() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Retried++
+}
 
-	// A semaphore of size 10 to limit the number of concurrent go routines to 10
-	sem := make(chan struct{}, 10)
+func (s *CrawlStats) addUnchanged(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Unchanged += n
+}
 
-	// declare function before defining it so that recursive calls inside dont cause errors due to function not being bound to arguments and stuff
-	var worker func(string, int)
+func (s *CrawlStats) startIndexing() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexing++
+}
 
-	// recursive helper function to actually recursively crawl to depth
-	worker = func(url string, curr_depth int) {
+// doneIndexing counts off a page waiting to be indexed, as indexed if ok
+func (s *CrawlStats) doneIndexing(ok bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexing--
+	if ok {
+		s.Indexed++
+	}
+}
 
-		// Base case to terminate recursion
-		if curr_depth >= maxDepth {
+// waitIndexed waits until the pages stored so far are indexed, or for timeout
+func (s *CrawlStats) waitIndexed(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		indexing := s.indexing
+		s.mu.Unlock()
+		if indexing <= 0 {
 			return
 		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
 
-		// locking reads and writes on the visitedURLs hash set
-		mu.Lock()
-
-		// if the current url passed to worker already exists in our hash set unlock mutex and return
-		if _, seen := visitedURLs[url]; seen {
-			mu.Unlock()
-			return
-		}
+// indexingStats maps stored files waiting in IndexTargetChan to the stats of
+// the crawl that fetched them, so they're counted once indexed
+var (
+	indexingStats   = make(map[string]*CrawlStats)
+	indexingStatsMu sync.Mutex
+)
 
-		// Otherwise add the new url to our hash set and unlock our mutex
-		visitedURLs[url] = struct{}{}
-		mu.Unlock()
+func trackIndexing(filePath string, stats *CrawlStats) {
+	if stats == nil {
+		return
+	}
+	stats.startIndexing()
+	indexingStatsMu.Lock()
+	previous := indexingStats[filePath]
+	indexingStats[filePath] = stats
+	indexingStatsMu.Unlock()
+
+	// The file was stored again before it was indexed; the earlier crawl
+	// won't see it indexed
+	previous.doneIndexing(false)
+}
 
-		// signal to the WaitGroup that one new goroutine needs to be monitored and start go routine
-		wg.Add(1)
-		go func() {
+// takeIndexingStats returns the stats of the crawl waiting for a file to be
+// indexed, or nil
+func takeIndexingStats(filePath string) *CrawlStats {
+	indexingStatsMu.Lock()
+	defer indexingStatsMu.Unlock()
+	stats := indexingStats[filePath]
+	delete(indexingStats, filePath)
+	return stats
+}
 
-			// Once goroutine concludes we gotta tell the WaitGroup that one of the go routines have finished and to reduce count by one
-			defer wg.Done()
+func (s *CrawlStats) addThrottled() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Throttled++
+}
 
-			// Add one entry to the semaphore channel signifying another go routine has started
-			// If theres already 10 entries in our semaphone channel this will block the next go routine till a go routine finishes working and we have 9 in the channel buffer
-			sem <- struct{}{}
+func (s *CrawlStats) addBytes(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Bytes += int64(n)
+}
 
-			// To release one entry from our channel buffer
-			defer func() { <-sem }()
+func (s *CrawlStats) addWait(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Waited += d.Milliseconds()
+}
 
-			// The meat and potatoes of our code, now we want to start crawling our url and get all valid vistable urls from here
-			extracted_urls, err := Crawl(url)
+// Snapshot copies the counts for reporting while the crawl goes on
+func (s *CrawlStats) Snapshot() *CrawlStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &CrawlStats{
+		Fetched:    s.Fetched,
+		Failed:     s.Failed,
+		Retried:    s.Retried,
+		Disallowed: s.Disallowed,
+		Unchanged:  s.Unchanged,
+		Indexed:    s.Indexed,
+		Throttled:  s.Throttled,
+		Bytes:      s.Bytes,
+		Waited:     s.Waited,
+	}
+}
 
-			// if we have some trouble in this process get outta this go routine
-			if err != nil {
-				return
-			}
+// statusError is returned for responses other than 200 OK
+type statusError struct {
+	url    string
+	status string
+	code   int
+}
 
-			// We iterate over all our extracted urls and call worker recursively with our depth increasing by one
-			for u := range extracted_urls {
-				worker(u, curr_depth+1)
-			}
-		}()
-	}
+func (e *statusError) Error() string {
+	return fmt.Sprintf("fetching %s: status %s", e.url, e.status)
+}
 
-	// init worker with seed URL
-	worker(seedURL, 0)
+// CrawlRecursive crawls a seed URL and the pages it links to up to the
+// configured depth, along with the pages in the site's sitemaps, through the
+// frontier. It returns once they have all been fetched.
+func CrawlRecursive(seedURL string) *CrawlStats {
+	f := StartFrontier()
+	crawl, release := f.newCrawl("", seedURL)
+	queueRecursiveCrawl(f, crawl, seedURL)
+	release()
+	<-crawl.done
+	return crawl.stats
+}
 
-	// wait till all our go routines are done working
-	wg.Wait()
+// queueRecursiveCrawl queues the seed of a recursive crawl and the pages in
+// its site's sitemaps
+func queueRecursiveCrawl(f *Frontier, crawl *frontierCrawl, seedURL string) {
+	// The seed is fetched again even if it was crawled recently
+	f.Add(&FrontierEntry{URL: seedURL, Crawl: crawl.id, MaxDepth: cfg.SearchDepth, Priority: seedPriority}, true)
+
+	// Pages listed in the site's sitemaps may not be linked within reach of the seed
+	if cfg.DiscoverSitemaps {
+		entries, _, err := FetchSitemaps(crawl.ctx, seedURL, DiscoverSitemaps(seedURL), cfg.SitemapMaxURLs)
+		switch {
+		case crawl.ctx.Err() != nil:
+			// The crawl was cancelled while its sitemaps were read
+		case err != nil:
+			log.Printf("No sitemap for %q: %v", seedURL, err)
+		default:
+			queued, unchanged := queueSitemapEntries(f, crawl.id, entries, cfg.SearchDepth)
+			crawl.stats.addUnchanged(unchanged)
+			log.Printf("Queued %d of %d sitemap pages for %q", queued, len(entries), seedURL)
+		}
+	}
 }
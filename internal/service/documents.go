@@ -0,0 +1,280 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PushedDocument is a document sent to the index directly instead of being
+// crawled. Body holds the raw document, extracted according to ContentType;
+// the other fields take precedence over what extraction finds.
+type PushedDocument struct {
+	ID          string            `json:"id"`
+	URL         string            `json:"url"`
+	Title       string            `json:"title"`
+	Body        string            `json:"body"`
+	ContentType string            `json:"content_type"` // of Body, plain text if empty
+	Language    string            `json:"language"`
+	Fields      map[string]string `json:"fields"` // extra fields searched with their profile weight
+	Metadata    PushedMetadata    `json:"metadata"`
+}
+
+// PushedMetadata fills in document metadata that is filtered and faceted on
+type PushedMetadata struct {
+	Description string     `json:"description"`
+	Type        string     `json:"type"`
+	Authors     []string   `json:"authors"`
+	Published   *time.Time `json:"published"`
+	Modified    *time.Time `json:"modified"`
+}
+
+var validDocumentID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// Validate checks that a pushed document can be stored and indexed
+func (d *PushedDocument) Validate() error {
+	if d.ID == "" && d.URL == "" {
+		return fmt.Errorf("a document needs an id or a url")
+	}
+	if d.ID != "" && !validDocumentID.MatchString(d.ID) {
+		return fmt.Errorf("invalid id %q: use up to 128 letters, digits, '.', '_' and '-'", d.ID)
+	}
+	if d.Body == "" {
+		return fmt.Errorf("document %s has an empty body", d.docID())
+	}
+	for name := range d.Fields {
+		if name == FieldBody || name == FieldStopwords {
+			return fmt.Errorf("field %q is reserved", name)
+		}
+	}
+	return nil
+}
+
+// docID is the pushed ID, or the one a crawl of the URL would store it under
+func (d *PushedDocument) docID() string {
+	if d.ID != "" {
+		return d.ID
+	}
+	return documentID(d.URL)
+}
+
+// overrides copies what the pusher stated about a document without its body
+func (d *PushedDocument) overrides() *PushedDocument {
+	o := *d
+	o.Body = ""
+	return &o
+}
+
+// applyTo overrides extracted content with what the pusher stated
+func (d *PushedDocument) applyTo(page *ExtractedDocument) {
+	if d.Title != "" {
+		page.Title = d.Title
+	}
+	if d.Language != "" {
+		page.Language = d.Language
+	}
+	if d.Metadata.Description != "" {
+		page.Description = d.Metadata.Description
+	}
+	if d.Metadata.Type != "" {
+		page.Structured.Type = d.Metadata.Type
+	}
+	if len(d.Metadata.Authors) > 0 {
+		page.Structured.Authors = d.Metadata.Authors
+	}
+	if d.Metadata.Published != nil {
+		page.Structured.Published = d.Metadata.Published
+	}
+	if d.Metadata.Modified != nil {
+		page.Structured.Modified = d.Metadata.Modified
+	}
+}
+
+// PushDocument stores a pushed document and indexes it into the main index,
+// replacing an earlier version with the same ID. The body is stored like a
+// crawled page and the overridden fields are kept in the snapshot log, so
+// snapshots reindex it as pushed.
+func PushDocument(d *PushedDocument) (string, int, error) {
+	doc, err := preparePushed(d)
+	if err != nil {
+		return "", 0, err
+	}
+	InvertedIndex.replaceDocuments([]*analyzedDocument{doc})
+
+	tokenCount := len(doc.fields[FieldBody])
+	recordIndexed(InvertedIndex, doc.docID, tokenCount)
+
+	log.Printf("Indexed pushed document %s: %d tokens", doc.docID, tokenCount)
+	return doc.docID, tokenCount, nil
+}
+
+// preparePushed validates, analyzes and stores a pushed document. It's stored
+// only once it analyzed, so a rejected push leaves the version indexed and
+// stored before it in place.
+func preparePushed(d *PushedDocument) (*analyzedDocument, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	docID := d.docID()
+	contentType := d.ContentType
+	if contentType == "" {
+		contentType = ContentTypeText
+	}
+	info := &FetchInfo{ContentType: contentType, Pushed: d.overrides()}
+	doc, _, err := analyzeAndStore(docID, d.URL, []byte(d.Body), info, d)
+	return doc, err
+}
+
+// DeleteDocument removes a document from the main index, the data directory
+// and the database. It reports whether the document was indexed.
+func DeleteDocument(docID string) (bool, error) {
+	if !validDocumentID.MatchString(docID) {
+		return false, fmt.Errorf("invalid id %q", docID)
+	}
+
+	found := InvertedIndex.RemoveDocument(docID)
+	removeStaleCopies(docID, "")
+
+	docURLMu.Lock()
+	delete(DocURLMap, docID)
+	delete(docFetchInfo, docID)
+	docURLMu.Unlock()
+
+	if store != nil {
+		if err := store.RemoveIndexedDocument(docID); err != nil {
+			log.Printf("Error removing %s from database: %v", docID, err)
+		}
+	}
+	return found, nil
+}
+
+// removeStaleCopies deletes copies of a document stored under another
+// extension, left behind when a new version has a different content type
+func removeStaleCopies(docID, keep string) {
+	for _, ext := range contentTypeExtensions {
+		stale := filepath.Join(cfg.DataURL, docID+ext)
+		if stale != keep {
+			os.Remove(stale)
+		}
+	}
+}
+
+// Task statuses
+const (
+	TaskQueued    = "queued"
+	TaskRunning   = "running"
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+)
+
+// Task tracks a document push that runs in the background
+type Task struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	DocID    string     `json:"doc_id,omitempty"`
+	Tokens   int        `json:"tokens,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// maxTasks bounds how many tasks are remembered; the oldest finished ones go first
+const maxTasks = 1000
+
+var (
+	tasks   = make(map[string]*Task)
+	tasksMu sync.RWMutex
+
+	// taskSem limits how many background pushes are indexed at once
+	taskSem = make(chan struct{}, 4)
+)
+
+// PushDocumentAsync validates a document and indexes it in the background,
+// returning the task that reports the outcome
+func PushDocumentAsync(d *PushedDocument) (*Task, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	task := newTask()
+	go func() {
+		taskSem <- struct{}{}
+		defer func() { <-taskSem }()
+
+		updateTask(task.ID, func(t *Task) { t.Status = TaskRunning })
+		docID, tokens, err := PushDocument(d)
+		updateTask(task.ID, func(t *Task) {
+			now := time.Now()
+			t.Finished = &now
+			t.DocID, t.Tokens = docID, tokens
+			if err != nil {
+				t.Status, t.Error = TaskFailed, err.Error()
+				return
+			}
+			t.Status = TaskSucceeded
+		})
+		if err != nil {
+			log.Printf("Task %s failed: %v", task.ID, err)
+		}
+	}()
+
+	return task, nil
+}
+
+func newTask() *Task {
+	id := make([]byte, 8)
+	rand.Read(id)
+	task := &Task{ID: hex.EncodeToString(id), Status: TaskQueued, Created: time.Now()}
+
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	if len(tasks) >= maxTasks {
+		pruneTasks()
+	}
+	tasks[task.ID] = task
+	copied := *task
+	return &copied
+}
+
+// pruneTasks forgets the oldest finished tasks, keeping the most recent half.
+// tasksMu must be held.
+func pruneTasks() {
+	var finished []*Task
+	for _, t := range tasks {
+		if t.Finished != nil {
+			finished = append(finished, t)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Finished.Before(*finished[j].Finished) })
+	for _, t := range finished[:len(finished)-min(len(finished), maxTasks/2)] {
+		delete(tasks, t.ID)
+	}
+}
+
+func updateTask(id string, update func(*Task)) {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	if t, ok := tasks[id]; ok {
+		update(t)
+	}
+}
+
+// GetTask returns a snapshot of a background task's state
+func GetTask(id string) (*Task, bool) {
+	tasksMu.RLock()
+	defer tasksMu.RUnlock()
+	t, ok := tasks[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *t
+	return &copied, true
+}
@@ -0,0 +1,551 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	mrand "math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mush1e/IndexStream-v2/internal/database"
+)
+
+const (
+	ModeAB         = "ab"
+	ModeInterleave = "interleave"
+
+	TeamControl   = "A"
+	TeamTreatment = "B"
+
+	// impressions older than this are folded into the experiment totals
+	impressionTTL = 24 * time.Hour
+)
+
+// Experiment compares two ranking profiles on live traffic. In "ab" mode each
+// enrolled user sees one profile; in "interleave" mode both rankings are merged
+// with team-draft interleaving and clicks are credited to the profile that
+// contributed the clicked result.
+type Experiment struct {
+	Name      string  `json:"name"`
+	Control   string  `json:"control"`
+	Treatment string  `json:"treatment"`
+	Mode      string  `json:"mode"`
+	Traffic   float64 `json:"traffic"` // fraction of users enrolled, 0..1
+}
+
+// ArmStats summarizes one side of an A/B experiment
+type ArmStats struct {
+	Profile     string  `json:"profile"`
+	Impressions int     `json:"impressions"`
+	Clicked     int     `json:"clicked_impressions"`
+	CTR         float64 `json:"ctr"`
+	CILow       float64 `json:"ci_low"`
+	CIHigh      float64 `json:"ci_high"`
+}
+
+// ExperimentStats reports the outcome of an experiment with 95% confidence intervals
+type ExperimentStats struct {
+	Experiment
+	Impressions int `json:"impressions"`
+
+	// Interleaving outcome, win rate is the treatment's share of decisive impressions
+	ControlWins   int     `json:"control_wins,omitempty"`
+	TreatmentWins int     `json:"treatment_wins,omitempty"`
+	Ties          int     `json:"ties,omitempty"`
+	WinRate       float64 `json:"win_rate,omitempty"`
+	WinRateLow    float64 `json:"win_rate_ci_low,omitempty"`
+	WinRateHigh   float64 `json:"win_rate_ci_high,omitempty"`
+
+	// A/B outcome
+	Arms      map[string]*ArmStats `json:"arms,omitempty"`
+	CTRDelta  float64              `json:"ctr_delta,omitempty"`
+	DeltaLow  float64              `json:"ctr_delta_ci_low,omitempty"`
+	DeltaHigh float64              `json:"ctr_delta_ci_high,omitempty"`
+}
+
+type impression struct {
+	experiment string
+	team       string            // arm shown, A/B mode only
+	teams      map[string]string // docID -> team, interleave mode only
+	clicks     map[string]int    // team -> clicks
+	clicked    map[string]bool   // docIDs already credited
+	createdAt  time.Time
+}
+
+type experimentTotals struct {
+	impressions map[string]int // per team
+	clicked     map[string]int // impressions with at least one click, per team
+	wins        map[string]int // interleaving wins per team, "" for ties
+}
+
+func newExperimentTotals() *experimentTotals {
+	return &experimentTotals{
+		impressions: make(map[string]int),
+		clicked:     make(map[string]int),
+		wins:        make(map[string]int),
+	}
+}
+
+// ExperimentRegistry holds the configured experiments and their live statistics.
+// Impressions still accepting clicks are counted in live; once expired they are
+// folded into totals, which are persisted when a database is configured.
+type ExperimentRegistry struct {
+	mu          sync.Mutex
+	experiments []*Experiment
+	impressions map[string]*impression
+	order       []string // impression IDs in creation order
+	live        map[string]*experimentTotals
+	totals      map[string]*experimentTotals
+	dirty       map[string]bool // experiments whose totals weren't saved yet
+}
+
+// Experiments is the registry consulted by the search handler
+var Experiments = &ExperimentRegistry{
+	impressions: make(map[string]*impression),
+	live:        make(map[string]*experimentTotals),
+	totals:      make(map[string]*experimentTotals),
+	dirty:       make(map[string]bool),
+}
+
+// Set replaces the configured experiments, keeping statistics of those that remain
+func (r *ExperimentRegistry) Set(experiments []*Experiment) error {
+	if err := validateExperiments(experiments); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.experiments = experiments
+	for _, exp := range experiments {
+		if r.totals[exp.Name] == nil {
+			r.totals[exp.Name] = newExperimentTotals()
+		}
+		if r.live[exp.Name] == nil {
+			r.live[exp.Name] = newExperimentTotals()
+		}
+	}
+	return nil
+}
+
+// loadTotals restores the folded totals saved by earlier runs
+func (r *ExperimentRegistry) loadTotals(db *database.DB) error {
+	saved, err := db.GetExperimentTotals()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range saved {
+		totals := r.totals[t.Experiment]
+		if totals == nil {
+			totals = newExperimentTotals()
+			r.totals[t.Experiment] = totals
+		}
+		totals.impressions[t.Team] += t.Impressions
+		totals.clicked[t.Team] += t.Clicked
+		totals.wins[t.Team] += t.Wins
+	}
+	return nil
+}
+
+// validateExperiments checks the name and mode of each experiment and
+// defaults its traffic share to everyone
+func validateExperiments(experiments []*Experiment) error {
+	for _, exp := range experiments {
+		if exp.Name == "" {
+			return fmt.Errorf("experiment without a name")
+		}
+		if exp.Mode != ModeAB && exp.Mode != ModeInterleave {
+			return fmt.Errorf("experiment %q: mode must be %q or %q", exp.Name, ModeAB, ModeInterleave)
+		}
+		if exp.Traffic <= 0 || exp.Traffic > 1 {
+			exp.Traffic = 1
+		}
+	}
+	return nil
+}
+
+// bucket deterministically maps a key to [0, 1)
+func bucket(key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()%10000) / 10000
+}
+
+// assign returns the first experiment the user is enrolled in, if any
+func (r *ExperimentRegistry) assign(userID string) *Experiment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, exp := range r.experiments {
+		if bucket(exp.Name+":"+userID) < exp.Traffic {
+			return exp
+		}
+	}
+	return nil
+}
+
+// Search ranks a query for a user, routing it through the user's experiment if
+// they are enrolled in one. Results served under an experiment carry an
+// impression ID that clicks must report back through RecordClick.
+func (r *ExperimentRegistry) Search(req SearchRequest, userID string) []SearchResult {
+	exp := r.assign(userID)
+	if userID == "" || exp == nil {
+		return InvertedIndex.SearchWith(req)
+	}
+
+	control, treatment := GetProfile(exp.Control), GetProfile(exp.Treatment)
+	if control == nil || treatment == nil {
+		return InvertedIndex.SearchWith(req)
+	}
+	query, topK := req.Query, req.TopK
+
+	imp := &impression{
+		experiment: exp.Name,
+		clicks:     make(map[string]int),
+		clicked:    make(map[string]bool),
+		createdAt:  time.Now(),
+	}
+
+	var results []SearchResult
+	switch exp.Mode {
+	case ModeInterleave:
+		reqA, reqB := req, req
+		reqA.Profile, reqB.Profile = control, treatment
+		listA, listB := InvertedIndex.SearchWith(reqA), InvertedIndex.SearchWith(reqB)
+		results = teamDraftInterleave(listA, listB, topK, bucketSeed(userID, query))
+		imp.teams = make(map[string]string, len(results))
+		for _, result := range results {
+			imp.teams[result.DocID] = result.Team
+		}
+	default:
+		profile, team := control, TeamControl
+		if bucket(exp.Name+":arm:"+userID) >= 0.5 {
+			profile, team = treatment, TeamTreatment
+		}
+		imp.team = team
+		req.Profile = profile
+		results = copyResults(InvertedIndex.SearchWith(req))
+		for i := range results {
+			results[i].Team = team
+		}
+	}
+
+	id := newImpressionID()
+	for i := range results {
+		results[i].Experiment = exp.Name
+		results[i].Impression = id
+	}
+
+	r.mu.Lock()
+	r.impressions[id] = imp
+	r.order = append(r.order, id)
+	if live := r.live[exp.Name]; live != nil {
+		live.add(imp, 1)
+	}
+	r.mu.Unlock()
+
+	return results
+}
+
+// RecordClick credits a click on docID to the team that contributed it. Each
+// document of an impression is credited once, however often it's clicked.
+func (r *ExperimentRegistry) RecordClick(impressionID, docID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	imp, ok := r.impressions[impressionID]
+	if !ok {
+		return
+	}
+
+	team := imp.team
+	if imp.teams != nil {
+		team = imp.teams[docID]
+	}
+	if team == "" || imp.clicked[docID] {
+		return
+	}
+	imp.clicked[docID] = true
+
+	// Swap the impression's contribution to the live totals for its new outcome
+	live := r.live[imp.experiment]
+	if live != nil {
+		live.add(imp, -1)
+	}
+	imp.clicks[team]++
+	if live != nil {
+		live.add(imp, 1)
+	}
+}
+
+// Watch folds expired impressions into the experiment totals every tick and
+// saves the totals that changed
+func (r *ExperimentRegistry) Watch(tick time.Duration) {
+	for {
+		time.Sleep(tick)
+		r.Flush()
+	}
+}
+
+// Flush folds expired impressions and saves the changed totals right away
+func (r *ExperimentRegistry) Flush() {
+	r.save(r.prune(time.Now()))
+}
+
+// prune folds impressions older than impressionTTL into the experiment totals.
+// Impressions are queued in creation order, so only expired ones are visited.
+// It returns the totals to save.
+func (r *ExperimentRegistry) prune(now time.Time) []database.ExperimentTotal {
+	cutoff := now.Add(-impressionTTL)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for ; n < len(r.order); n++ {
+		imp := r.impressions[r.order[n]]
+		if !imp.createdAt.Before(cutoff) {
+			break
+		}
+		if live := r.live[imp.experiment]; live != nil {
+			live.add(imp, -1)
+		}
+		totals := r.totals[imp.experiment]
+		if totals == nil {
+			totals = newExperimentTotals()
+			r.totals[imp.experiment] = totals
+		}
+		totals.add(imp, 1)
+		r.dirty[imp.experiment] = true
+		delete(r.impressions, r.order[n])
+	}
+	r.order = r.order[n:]
+
+	if store == nil {
+		return nil
+	}
+	var changed []database.ExperimentTotal
+	for name := range r.dirty {
+		changed = append(changed, r.totals[name].rows(name)...)
+		delete(r.dirty, name)
+	}
+	return changed
+}
+
+func (r *ExperimentRegistry) save(totals []database.ExperimentTotal) {
+	for _, t := range totals {
+		if err := store.SaveExperimentTotal(t); err != nil {
+			log.Printf("Failed to save totals of experiment %q: %v", t.Experiment, err)
+		}
+	}
+}
+
+// add counts an impression's outcome n times, -1 to take it back
+func (t *experimentTotals) add(imp *impression, n int) {
+	if imp.teams == nil {
+		t.impressions[imp.team] += n
+		if imp.clicks[imp.team] > 0 {
+			t.clicked[imp.team] += n
+		}
+		return
+	}
+
+	t.impressions[""] += n
+	a, b := imp.clicks[TeamControl], imp.clicks[TeamTreatment]
+	switch {
+	case a > b:
+		t.wins[TeamControl] += n
+	case b > a:
+		t.wins[TeamTreatment] += n
+	case a > 0:
+		t.wins[""] += n
+	}
+}
+
+// rows flattens the totals into one database row per team
+func (t *experimentTotals) rows(experiment string) []database.ExperimentTotal {
+	teams := map[string]bool{}
+	for _, m := range []map[string]int{t.impressions, t.clicked, t.wins} {
+		for team := range m {
+			teams[team] = true
+		}
+	}
+
+	rows := make([]database.ExperimentTotal, 0, len(teams))
+	for team := range teams {
+		rows = append(rows, database.ExperimentTotal{
+			Experiment:  experiment,
+			Team:        team,
+			Impressions: t.impressions[team],
+			Clicked:     t.clicked[team],
+			Wins:        t.wins[team],
+		})
+	}
+	return rows
+}
+
+// merge adds other's counts to t
+func (t *experimentTotals) merge(other *experimentTotals) {
+	if other == nil {
+		return
+	}
+	for k, v := range other.impressions {
+		t.impressions[k] += v
+	}
+	for k, v := range other.clicked {
+		t.clicked[k] += v
+	}
+	for k, v := range other.wins {
+		t.wins[k] += v
+	}
+}
+
+// Stats reports the current outcome of every configured experiment
+func (r *ExperimentRegistry) Stats() []ExperimentStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := make([]ExperimentStats, 0, len(r.experiments))
+	for _, exp := range r.experiments {
+		// Combine folded totals with the impressions still being tracked
+		totals := newExperimentTotals()
+		totals.merge(r.totals[exp.Name])
+		totals.merge(r.live[exp.Name])
+
+		stats = append(stats, totals.stats(*exp))
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func (t *experimentTotals) stats(exp Experiment) ExperimentStats {
+	s := ExperimentStats{Experiment: exp}
+
+	if exp.Mode == ModeInterleave {
+		s.Impressions = t.impressions[""]
+		s.ControlWins = t.wins[TeamControl]
+		s.TreatmentWins = t.wins[TeamTreatment]
+		s.Ties = t.wins[""]
+		if decisive := s.ControlWins + s.TreatmentWins; decisive > 0 {
+			s.WinRate = float64(s.TreatmentWins) / float64(decisive)
+			s.WinRateLow, s.WinRateHigh = wilsonInterval(s.TreatmentWins, decisive)
+		}
+		return s
+	}
+
+	s.Arms = map[string]*ArmStats{}
+	for team, profile := range map[string]string{TeamControl: exp.Control, TeamTreatment: exp.Treatment} {
+		arm := &ArmStats{
+			Profile:     profile,
+			Impressions: t.impressions[team],
+			Clicked:     t.clicked[team],
+		}
+		if arm.Impressions > 0 {
+			arm.CTR = float64(arm.Clicked) / float64(arm.Impressions)
+			arm.CILow, arm.CIHigh = wilsonInterval(arm.Clicked, arm.Impressions)
+		}
+		s.Impressions += arm.Impressions
+		s.Arms[team] = arm
+	}
+
+	a, b := s.Arms[TeamControl], s.Arms[TeamTreatment]
+	if a.Impressions > 0 && b.Impressions > 0 {
+		s.CTRDelta = b.CTR - a.CTR
+		se := math.Sqrt(a.CTR*(1-a.CTR)/float64(a.Impressions) + b.CTR*(1-b.CTR)/float64(b.Impressions))
+		s.DeltaLow, s.DeltaHigh = s.CTRDelta-1.96*se, s.CTRDelta+1.96*se
+	}
+	return s
+}
+
+// wilsonInterval is the 95% Wilson score interval of a binomial proportion
+func wilsonInterval(successes, trials int) (float64, float64) {
+	const z = 1.96
+	n := float64(trials)
+	p := float64(successes) / n
+
+	denom := 1 + z*z/n
+	center := (p + z*z/(2*n)) / denom
+	margin := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n)) / denom
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
+
+// teamDraftInterleave merges two rankings: in each round the team with fewer
+// picks (a coin flip on ties) contributes its highest ranked result not yet shown
+func teamDraftInterleave(listA, listB []SearchResult, topK int, seed uint64) []SearchResult {
+	rng := mrand.New(mrand.NewPCG(seed, 0))
+	merged := make([]SearchResult, 0, topK)
+	shown := make(map[string]bool)
+	picksA, picksB := 0, 0
+	nextA, nextB := 0, 0
+
+	next := func(list []SearchResult, i *int) (SearchResult, bool) {
+		for *i < len(list) {
+			result := list[*i]
+			*i++
+			if !shown[result.DocID] {
+				return result, true
+			}
+		}
+		return SearchResult{}, false
+	}
+
+	for len(merged) < topK {
+		pickA := picksA < picksB || (picksA == picksB && rng.IntN(2) == 0)
+
+		result, ok := SearchResult{}, false
+		team := TeamControl
+		if pickA {
+			result, ok = next(listA, &nextA)
+		}
+		if !ok {
+			result, ok = next(listB, &nextB)
+			team = TeamTreatment
+		}
+		if !ok && !pickA {
+			result, ok = next(listA, &nextA)
+			team = TeamControl
+		}
+		if !ok {
+			break
+		}
+
+		if team == TeamControl {
+			picksA++
+		} else {
+			picksB++
+		}
+		result.Team = team
+		shown[result.DocID] = true
+		merged = append(merged, result)
+	}
+
+	return merged
+}
+
+// bucketSeed makes interleaving coin flips reproducible for a user and query
+func bucketSeed(userID, query string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(userID + "\x00" + query))
+	return h.Sum64()
+}
+
+// copyResults copies a result slice so attribution never leaks into cached results
+func copyResults(results []SearchResult) []SearchResult {
+	return append([]SearchResult(nil), results...)
+}
+
+func newImpressionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -3,45 +3,133 @@ package service
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 var IndexTargetChan = make(chan string, 100) // Increased buffer size
 var extractorCtx context.Context
 var extractorCancel context.CancelFunc
 var extractorWg sync.WaitGroup
+var activeWorkers atomic.Int64
 
 func init() {
 	extractorCtx, extractorCancel = context.WithCancel(context.Background())
 }
 
-func parseHTML(htmlBytes *[]byte) string {
-	var rawText strings.Builder
+// ExtractedDocument is the indexable content pulled out of a fetched page
+type ExtractedDocument struct {
+	Text        string // the main content
+	Boilerplate string // navigation, headers, footers and other page chrome
+	Language    string // as declared by the page, e.g. <html lang="fr">
+	Title       string
+	Description string
+	Headings    []string // <h1> to <h3>, in document order
+	Canonical   string   // <link rel="canonical"> as written, possibly relative
+	Structured  StructuredData
+}
+
+func parseHTML(htmlBytes *[]byte) *ExtractedDocument {
 	htmlReader := bytes.NewReader(*htmlBytes)
 	node, err := html.Parse(htmlReader)
 	if err != nil {
 		log.Printf("error parsing html : %q\n", err)
-		return ""
+		return &ExtractedDocument{}
 	}
 
+	doc := &ExtractedDocument{}
 	visit := func(node *html.Node) {
-		if node.Type == html.TextNode {
-			// Clean and normalize text
-			text := strings.TrimSpace(node.Data)
-			if text != "" {
-				rawText.WriteString(text)
-				rawText.WriteString(" ")
+		if node.Type == html.ElementNode {
+			switch node.DataAtom {
+			case atom.Html:
+				doc.Language = attrValue(node, "lang")
+			case atom.Title:
+				if doc.Title == "" {
+					doc.Title = nodeText(node)
+				}
+			case atom.Meta:
+				if strings.EqualFold(attrValue(node, "name"), "description") {
+					doc.Description = strings.TrimSpace(attrValue(node, "content"))
+				}
+			case atom.Link:
+				if hasToken(attrValue(node, "rel"), "canonical") {
+					doc.Canonical = strings.TrimSpace(attrValue(node, "href"))
+				}
+			case atom.H1, atom.H2, atom.H3:
+				if heading := nodeText(node); heading != "" {
+					doc.Headings = append(doc.Headings, heading)
+				}
 			}
 		}
 	}
 	traverseDOMTree(node, visit)
-	return strings.TrimSpace(rawText.String())
+	doc.Text, doc.Boilerplate = extractContent(node)
+	doc.Structured = parseStructuredData(node)
+
+	// Structured data and the main heading stand in for missing metadata
+	if doc.Title == "" {
+		doc.Title = doc.Structured.Name
+	}
+	if doc.Title == "" && len(doc.Headings) > 0 {
+		doc.Title = doc.Headings[0]
+	}
+	if doc.Description == "" {
+		doc.Description = doc.Structured.Description
+	}
+	return doc
+}
+
+func attrValue(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// hasToken reports whether a space separated attribute such as rel contains token
+func hasToken(value, token string) bool {
+	for _, field := range strings.Fields(value) {
+		if strings.EqualFold(field, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeText returns the text inside node with whitespace collapsed
+func nodeText(node *html.Node) string {
+	var parts []string
+	traverseDOMTree(node, func(n *html.Node) {
+		if n.Type == html.TextNode {
+			parts = append(parts, strings.Fields(n.Data)...)
+		}
+	})
+	return strings.Join(parts, " ")
+}
+
+// documentLanguage picks the language used to analyze a document: the page's
+// own declaration, then the Content-Language header, then detection from text
+func documentLanguage(declared, contentLanguage, text string) string {
+	if lang := NormalizeLanguage(declared); lang != "" {
+		return lang
+	}
+	if lang := NormalizeLanguage(contentLanguage); lang != "" {
+		return lang
+	}
+	if lang := DetectLanguage(text); lang != "" {
+		return lang
+	}
+	return DefaultLanguage
 }
 
 func ExtractText() {
@@ -61,6 +149,9 @@ func ExtractText() {
 				defer extractorWg.Done()
 				defer func() { <-sem }()
 
+				activeWorkers.Add(1)
+				defer activeWorkers.Add(-1)
+
 				processFile(filePath)
 			}(filePath)
 
@@ -74,40 +165,198 @@ func ExtractText() {
 }
 
 func processFile(filePath string) {
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		log.Printf("File does not exist: %s", filePath)
+	stats := takeIndexingStats(filePath)
+	doc, err := analyzeFile(filePath)
+	if err != nil {
+		stats.doneIndexing(false)
+		log.Printf("Error processing %s: %v", filePath, err)
 		return
 	}
 
-	htmlBytes, err := os.ReadFile(filePath)
-	if err != nil {
-		log.Printf("Error reading html file %s: %v", filePath, err)
+	// A page crawled again replaces the version indexed before
+	InvertedIndex.replaceDocuments([]*analyzedDocument{doc})
+	docID, tokenCount := doc.docID, len(doc.fields[FieldBody])
+
+	recordIndexed(InvertedIndex, docID, tokenCount)
+	stats.doneIndexing(true)
+
+	log.Printf("Successfully processed %s: %d tokens indexed", docID, tokenCount)
+}
+
+// recordIndexed lists an indexed document in the database, if there is one
+func recordIndexed(idx *Index, docID string, tokenCount int) {
+	if store == nil {
 		return
 	}
+	metadata := idx.getDocumentMetadata(docID)
+	if err := store.AddIndexedDocument(docID, metadata.URL, metadata.Title, tokenCount); err != nil {
+		log.Printf("Error recording %s in database: %v", docID, err)
+	}
+}
 
-	// Extract text content from HTML
-	rawTextFile := parseHTML(&htmlBytes)
-	if rawTextFile == "" {
-		log.Printf("No text content extracted from %s", filePath)
-		return
+// indexFile extracts, analyzes and indexes a stored page into idx
+func indexFile(idx *Index, filePath string) (string, int, error) {
+	doc, err := analyzeFile(filePath)
+	if err != nil {
+		return "", 0, err
 	}
+	idx.AddAnalyzedDocument(doc.docID, doc.fields, doc.metadata)
+	return doc.docID, len(doc.fields[FieldBody]), nil
+}
 
-	// Tokenize the extracted text
-	tokens := Tokenize(rawTextFile)
-	if len(tokens) == 0 {
-		log.Printf("No tokens generated from %s", filePath)
-		return
+// analyzedDocument is a stored page ready to be added to an index
+type analyzedDocument struct {
+	docID    string
+	fields   map[string][]Token
+	metadata *DocumentMetadata
+}
+
+// analyzeFile extracts and analyzes a stored page without indexing it. A
+// pushed document keeps what its pusher stated about it.
+func analyzeFile(filePath string) (*analyzedDocument, error) {
+	// Check if file exists
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("file does not exist: %s", filePath)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading stored file: %w", err)
 	}
 
 	// Extract document ID from filename
 	docID := filepath.Base(filePath)
-	docID = strings.TrimSuffix(docID, ".html")
+	docID = strings.TrimSuffix(docID, filepath.Ext(docID))
+
+	info := DocumentFetchInfo(docID)
+	url, _ := DocumentURL(docID)
+	return analyzeDocument(docID, filePath, url, data, info, info.Pushed)
+}
+
+// analyzeDocument extracts and analyzes a document's original bytes. name is
+// the stored file or URL whose extension may tell the document's type, url
+// the document's URL if it has one.
+func analyzeDocument(docID, name, url string, data []byte, info *FetchInfo, push *PushedDocument) (*analyzedDocument, error) {
+	// Extract text content with the extractor for the document's type
+	contentType := DetectContentType(info.ContentType, name, data)
+	extractor := ExtractorFor(contentType)
+	if extractor == nil {
+		return nil, fmt.Errorf("no extractor for content type %q", contentType)
+	}
+
+	// Stored documents keep their original bytes, extractors work on UTF-8
+	data, charsetName := decodeRecordedCharset(data, info.Charset, info.ContentType, contentType)
+	page, err := extractor.Extract(data)
+	if err != nil {
+		return nil, fmt.Errorf("extracting %s: %w", contentType, err)
+	}
+	if page.Text == "" {
+		return nil, fmt.Errorf("no text content extracted")
+	}
+
+	if push != nil {
+		push.applyTo(page)
+	}
 
-	// Add document to inverted index
-	InvertedIndex.AddDocument(docID, tokens)
+	if info.Published != nil {
+		page.Structured.Published = info.Published
+	}
+
+	language := documentLanguage(page.Language, info.ContentLanguage, page.Text)
+
+	// Analyze each field with the analyzer assigned to it. Stopwords removed
+	// from the body are kept apart for queries made only of stopwords.
+	tokens, stopped := AnalyzerFor(FieldBody).AnalyzeWithStopwords(page.Text, language)
+	fields := map[string][]Token{
+		FieldBody:      tokens,
+		FieldStopwords: stopped,
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens generated")
+	}
+
+	if push != nil && push.URL != "" {
+		url = push.URL
+	}
+	title := page.Title
+	if title == "" && url != "" {
+		title = extractTitleFromURL(url)
+	}
+	if title != "" {
+		fields[FieldTitle] = AnalyzerFor(FieldTitle).AnalyzeLang(title, language)
+	}
+	if page.Boilerplate != "" {
+		fields[FieldBoilerplate] = AnalyzerFor(FieldBoilerplate).AnalyzeLang(page.Boilerplate, language)
+	}
+	if len(page.Headings) > 0 {
+		fields[FieldHeadings] = AnalyzerFor(FieldHeadings).AnalyzeLang(strings.Join(page.Headings, " \n "), language)
+	}
+
+	if push != nil {
+		for name, value := range push.Fields {
+			fields[name] = AnalyzerFor(name).AnalyzeLang(value, language)
+		}
+	}
+
+	return &analyzedDocument{docID: docID, fields: fields, metadata: &DocumentMetadata{
+		Title:       title,
+		Description: page.Description,
+		Headings:    page.Headings,
+		Canonical:   preprocessRawURL(page.Canonical, url),
+		ContentType: contentType,
+		Charset:     charsetName,
+		Language:    language,
+		Type:        page.Structured.Type,
+		Authors:     page.Structured.Authors,
+		Published:   page.Structured.Published,
+		Modified:    page.Structured.Modified,
+		Structured:  structuredOrNil(page.Structured),
+	}}, nil
+}
+
+// structuredOrNil leaves documents without structured data out of responses
+func structuredOrNil(sd StructuredData) *StructuredData {
+	if sd.Type == "" && sd.Name == "" && len(sd.Authors) == 0 && sd.Published == nil &&
+		len(sd.Breadcrumbs) == 0 && len(sd.OpenGraph) == 0 {
+		return nil
+	}
+	return &sd
+}
+
+// LoadSnapshot synchronously indexes every stored document in dir into idx and
+// returns the number of documents added. Only files whose extension names a
+// content type are documents; the logs and manifests kept alongside them use
+// other extensions.
+func LoadSnapshot(idx *Index, dir string) (int, error) {
+	all, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return 0, fmt.Errorf("listing snapshot %s: %w", dir, err)
+	}
+
+	var files []string
+	for _, file := range all {
+		if NormalizeContentType(strings.TrimPrefix(filepath.Ext(file), ".")) != "" {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no stored pages found in %s", dir)
+	}
+
+	if err := restoreSnapshotLog(dir); err != nil {
+		log.Printf("Loading %s without stored URLs: %v", dir, err)
+	}
+
+	loaded := 0
+	for _, filePath := range files {
+		if _, _, err := indexFile(idx, filePath); err != nil {
+			log.Printf("Skipping %s: %v", filePath, err)
+			continue
+		}
+		loaded++
+	}
 
-	log.Printf("Successfully processed %s: %d tokens indexed", docID, len(tokens))
+	return loaded, nil
 }
 
 // ShutdownExtractor gracefully shuts down the text extractor
@@ -128,6 +377,6 @@ func GetExtractorStats() map[string]interface{} {
 	return map[string]interface{}{
 		"queue_size":     len(IndexTargetChan),
 		"queue_capacity": cap(IndexTargetChan),
-		"active_workers": extractorWg,
+		"active_workers": activeWorkers.Load(),
 	}
 }
@@ -0,0 +1,353 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Content types documents are stored and filtered under
+const (
+	ContentTypeHTML     = "text/html"
+	ContentTypeText     = "text/plain"
+	ContentTypeMarkdown = "text/markdown"
+	ContentTypeXML      = "application/xml"
+	ContentTypeJSON     = "application/json"
+	ContentTypePDF      = "application/pdf"
+)
+
+// Extractor pulls the indexable content out of documents of one content type
+type Extractor interface {
+	Extract(data []byte) (*ExtractedDocument, error)
+}
+
+var (
+	extractors = map[string]Extractor{
+		ContentTypeHTML:     htmlExtractor{},
+		ContentTypeText:     textExtractor{},
+		ContentTypeMarkdown: markdownExtractor{},
+		ContentTypeXML:      xmlExtractor{},
+		ContentTypeJSON:     jsonExtractor{},
+		ContentTypePDF:      pdfExtractor{},
+	}
+	extractorsMu sync.RWMutex
+)
+
+// RegisterExtractor adds or replaces the extractor of a content type
+func RegisterExtractor(contentType string, e Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors[contentType] = e
+}
+
+// ExtractorFor returns the extractor of a content type, nil if there is none
+func ExtractorFor(contentType string) Extractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+	return extractors[contentType]
+}
+
+// contentTypeAliases map media types and short names to the stored content types
+var contentTypeAliases = map[string]string{
+	"html":                  ContentTypeHTML,
+	"htm":                   ContentTypeHTML,
+	"application/xhtml+xml": ContentTypeHTML,
+	"text":                  ContentTypeText,
+	"txt":                   ContentTypeText,
+	"markdown":              ContentTypeMarkdown,
+	"md":                    ContentTypeMarkdown,
+	"text/x-markdown":       ContentTypeMarkdown,
+	"xml":                   ContentTypeXML,
+	"text/xml":              ContentTypeXML,
+	"application/rss+xml":   ContentTypeXML,
+	"application/atom+xml":  ContentTypeXML,
+	"rss":                   ContentTypeXML,
+	"json":                  ContentTypeJSON,
+	"text/json":             ContentTypeJSON,
+	"application/ld+json":   ContentTypeJSON,
+	"pdf":                   ContentTypePDF,
+}
+
+// contentTypeExtensions name stored files, so snapshots can be reindexed
+// without the HTTP headers they were fetched with
+var contentTypeExtensions = map[string]string{
+	ContentTypeHTML:     ".html",
+	ContentTypeText:     ".txt",
+	ContentTypeMarkdown: ".md",
+	ContentTypeXML:      ".xml",
+	ContentTypeJSON:     ".json",
+	ContentTypePDF:      ".pdf",
+}
+
+// NormalizeContentType maps a Content-Type header value or a short name such as
+// "pdf" to one of the stored content types, or "" if it isn't one we extract
+func NormalizeContentType(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(value))
+	}
+	if alias, ok := contentTypeAliases[mediaType]; ok {
+		return alias
+	}
+	if _, ok := contentTypeExtensions[mediaType]; ok {
+		return mediaType
+	}
+	if strings.HasSuffix(mediaType, "+xml") {
+		return ContentTypeXML
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return ContentTypeJSON
+	}
+	return ""
+}
+
+// DetectContentType decides how to extract a document from its declared
+// Content-Type, its file name and its first bytes. Servers often send generic
+// types like application/octet-stream or text/plain, so those are sniffed.
+func DetectContentType(declared, name string, data []byte) string {
+	contentType := NormalizeContentType(declared)
+	if contentType != "" && contentType != ContentTypeText {
+		return contentType
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	if byExt := NormalizeContentType(ext); byExt != "" && byExt != ContentTypeText {
+		return byExt
+	}
+
+	if sniffed := sniffContentType(data); sniffed != "" {
+		if contentType == ContentTypeText && sniffed != ContentTypePDF {
+			// Trust a text/plain header unless the content is binary
+			return contentType
+		}
+		return sniffed
+	}
+	return contentType
+}
+
+func sniffContentType(data []byte) string {
+	head := bytes.TrimSpace(data[:min(len(data), 512)])
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return ContentTypePDF
+	case (bytes.HasPrefix(head, []byte("{")) || bytes.HasPrefix(head, []byte("["))) && json.Valid(data):
+		return ContentTypeJSON
+	}
+
+	sniffed := NormalizeContentType(http.DetectContentType(data))
+	if sniffed == ContentTypeXML {
+		// Feeds and XHTML both start with <?xml
+		lower := bytes.ToLower(head)
+		if bytes.Contains(lower, []byte("<html")) || bytes.Contains(lower, []byte("<!doctype html")) {
+			return ContentTypeHTML
+		}
+	}
+	return sniffed
+}
+
+// htmlExtractor extracts the main content and metadata of HTML pages
+type htmlExtractor struct{}
+
+func (htmlExtractor) Extract(data []byte) (*ExtractedDocument, error) {
+	return parseHTML(&data), nil
+}
+
+// textExtractor indexes plain text as is, taking a short first line as the title
+type textExtractor struct{}
+
+func (textExtractor) Extract(data []byte) (*ExtractedDocument, error) {
+	text := strings.ToValidUTF8(string(data), "")
+	doc := &ExtractedDocument{Text: strings.TrimSpace(text)}
+
+	firstLine, _, _ := strings.Cut(doc.Text, "\n")
+	if firstLine = strings.TrimSpace(firstLine); len(firstLine) <= 120 {
+		doc.Title = firstLine
+	}
+	return doc, nil
+}
+
+var (
+	markdownHeading  = regexp.MustCompile(`^\s{0,3}(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownListItem = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s+`)
+	markdownEmphasis = strings.NewReplacer("**", "", "__", "", "`", "", "~~", "")
+)
+
+// markdownExtractor strips Markdown syntax, keeping link and image text, and
+// reads the title and headings from # headings
+type markdownExtractor struct{}
+
+func (markdownExtractor) Extract(data []byte) (*ExtractedDocument, error) {
+	doc := &ExtractedDocument{}
+	var text strings.Builder
+	inFence := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if !inFence {
+			if m := markdownHeading.FindStringSubmatch(line); m != nil {
+				line = m[2]
+				if len(m[1]) == 1 && doc.Title == "" {
+					doc.Title = line
+				}
+				if len(m[1]) <= 3 {
+					doc.Headings = append(doc.Headings, line)
+				}
+			}
+			line = strings.TrimLeft(line, "> ")
+			line = markdownListItem.ReplaceAllString(line, "")
+			line = markdownImage.ReplaceAllString(line, "$1")
+			line = markdownLink.ReplaceAllString(line, "$1")
+			line = markdownEmphasis.Replace(line)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			text.WriteString(line)
+			text.WriteString("\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading markdown: %w", err)
+	}
+
+	doc.Text = strings.TrimSpace(text.String())
+	if doc.Title == "" && len(doc.Headings) > 0 {
+		doc.Title = doc.Headings[0]
+	}
+	return doc, nil
+}
+
+// xmlExtractor indexes the character data of XML documents. For RSS and Atom
+// feeds the first title and description are the feed's own.
+type xmlExtractor struct{}
+
+func (xmlExtractor) Extract(data []byte) (*ExtractedDocument, error) {
+	doc := &ExtractedDocument{}
+	var text strings.Builder
+	var element string
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if text.Len() == 0 {
+				return nil, fmt.Errorf("parsing xml: %w", err)
+			}
+			break // keep what was read before the error
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			element = t.Name.Local
+			if element == "html" && doc.Language == "" {
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "lang" {
+						doc.Language = attr.Value
+					}
+				}
+			}
+		case xml.EndElement:
+			element = ""
+		case xml.CharData:
+			value := strings.TrimSpace(string(t))
+			if value == "" {
+				continue
+			}
+			switch element {
+			case "title":
+				if doc.Title == "" {
+					doc.Title = value
+				}
+			case "description", "subtitle", "summary":
+				if doc.Description == "" {
+					doc.Description = value
+				}
+			case "language":
+				if doc.Language == "" {
+					doc.Language = value
+				}
+			}
+			text.WriteString(value)
+			text.WriteString(" ")
+		}
+	}
+
+	doc.Text = strings.TrimSpace(text.String())
+	return doc, nil
+}
+
+// jsonExtractor indexes the string values of JSON documents. Top-level "title"
+// or "name" and "description" or "summary" keys fill in the metadata.
+type jsonExtractor struct{}
+
+func (jsonExtractor) Extract(data []byte) (*ExtractedDocument, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("parsing json: %w", err)
+	}
+
+	doc := &ExtractedDocument{}
+	if object, ok := value.(map[string]interface{}); ok {
+		doc.Title = firstString(object, "title", "name", "headline")
+		doc.Description = firstString(object, "description", "summary", "abstract")
+		doc.Language = firstString(object, "language", "lang", "inLanguage")
+	}
+
+	var values []string
+	collectJSONStrings(value, &values)
+	doc.Text = strings.Join(values, " ")
+	return doc, nil
+}
+
+func firstString(object map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if s, ok := object[key].(string); ok && strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+// collectJSONStrings walks a decoded JSON value in a stable order
+func collectJSONStrings(value interface{}, values *[]string) {
+	switch v := value.(type) {
+	case string:
+		if s := strings.TrimSpace(v); s != "" {
+			*values = append(*values, s)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectJSONStrings(item, values)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectJSONStrings(v[key], values)
+		}
+	}
+}
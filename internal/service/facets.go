@@ -0,0 +1,100 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// FacetCount is the number of matching documents with one value of a facet
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// facetFields read the values of each facet from a document's metadata
+var facetFields = map[string]func(*DocumentMetadata) []string{
+	"author": func(m *DocumentMetadata) []string { return m.Authors },
+	"published_year": func(m *DocumentMetadata) []string {
+		if m.Published == nil {
+			return nil
+		}
+		return []string{strconv.Itoa(m.Published.Year())}
+	},
+	"published_month": func(m *DocumentMetadata) []string {
+		if m.Published == nil {
+			return nil
+		}
+		return []string{m.Published.Format("2006-01")}
+	},
+	"type":         func(m *DocumentMetadata) []string { return nonEmpty(m.Type) },
+	"content_type": func(m *DocumentMetadata) []string { return nonEmpty(m.ContentType) },
+	"language":     func(m *DocumentMetadata) []string { return nonEmpty(m.Language) },
+}
+
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// FacetNames lists the facets Facets can count, sorted
+func FacetNames() []string {
+	names := make([]string, 0, len(facetFields))
+	for name := range facetFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Facets counts facet values over every document matching the request, not
+// just the top results. Each facet keeps its size most frequent values.
+func (idx *Index) Facets(req SearchRequest, names []string, size int) (map[string][]FacetCount, error) {
+	for _, name := range names {
+		if _, ok := facetFields[name]; !ok {
+			return nil, fmt.Errorf("unknown facet %q", name)
+		}
+	}
+
+	// Only which documents match matters here: they're neither ranked nor
+	// counted as accessed
+	req = req.normalized()
+	idx.mu.RLock()
+	scores, _ := idx.scoreDocuments(req)
+	idx.mu.RUnlock()
+
+	matched := make([]*DocumentMetadata, 0, len(scores))
+	for docID := range scores {
+		if metadata := idx.getDocumentMetadata(docID); req.matches(metadata) {
+			matched = append(matched, metadata)
+		}
+	}
+
+	facets := make(map[string][]FacetCount, len(names))
+	for _, name := range names {
+		counts := make(map[string]int)
+		for _, metadata := range matched {
+			for _, value := range facetFields[name](metadata) {
+				counts[value]++
+			}
+		}
+
+		values := make([]FacetCount, 0, len(counts))
+		for value, count := range counts {
+			values = append(values, FacetCount{Value: value, Count: count})
+		}
+		sort.Slice(values, func(i, j int) bool {
+			if values[i].Count != values[j].Count {
+				return values[i].Count > values[j].Count
+			}
+			return values[i].Value < values[j].Value
+		})
+		if size > 0 && len(values) > size {
+			values = values[:size]
+		}
+		facets[name] = values
+	}
+	return facets, nil
+}
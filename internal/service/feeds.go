@@ -0,0 +1,444 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mush1e/IndexStream-v2/internal/database"
+)
+
+var (
+	ErrFeedExists   = errors.New("feed already subscribed")
+	ErrFeedNotFound = errors.New("feed not found")
+)
+
+const (
+	// minFeedInterval keeps subscriptions from polling a feed too often
+	minFeedInterval = time.Minute
+
+	// maxFeedBytes bounds the size of a feed document
+	maxFeedBytes = 10 << 20
+
+	// maxFeedEntryAttempts is how many polls try to crawl an entry before it's
+	// given up on
+	maxFeedEntryAttempts = 3
+)
+
+// FeedPoll counts what one poll of a feed did
+type FeedPoll struct {
+	NotModified bool `json:"not_modified"`
+	Entries     int  `json:"entries"`
+	New         int  `json:"new"`
+	Retried     int  `json:"retried"` // entries of earlier polls tried again
+	Crawled     int  `json:"crawled"`
+	Failed      int  `json:"failed"`
+	GaveUp      int  `json:"gave_up"`    // failed entries not to be tried again
+	Disallowed  int  `json:"disallowed"` // kept out by robots.txt
+}
+
+// feedXML matches RSS 2.0, RSS 1.0 and Atom documents
+type feedXML struct {
+	Title   string `xml:"title"` // Atom
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"` // RSS 1.0 items are siblings of the channel
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Link string `xml:"link"`
+	GUID struct {
+		Value       string `xml:",chardata"`
+		IsPermaLink string `xml:"isPermaLink,attr"`
+	} `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+	Date    string `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+type atomEntry struct {
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+// feedEntry is an entry of a feed reduced to what the crawler needs
+type feedEntry struct {
+	Link      string
+	Published *time.Time
+}
+
+// feedDateLayouts are the RFC 822 dates of RSS as found in the wild; Atom and
+// Dublin Core dates are ISO 8601 and go through parseStructuredDate
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+}
+
+func parseFeedDate(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = t.UTC()
+			return &t
+		}
+	}
+	return parseStructuredDate(value)
+}
+
+// parseFeed reads the title and entries of an RSS or Atom feed, resolving
+// entry links against the feed's URL
+func parseFeed(data []byte, feedURL string) (string, []feedEntry, error) {
+	var doc feedXML
+	if err := newXMLDecoder(data).Decode(&doc); err != nil {
+		return "", nil, fmt.Errorf("parsing feed: %w", err)
+	}
+
+	var entries []feedEntry
+	add := func(link string, published *time.Time) {
+		if link = preprocessRawURL(strings.TrimSpace(link), feedURL); link != "" {
+			entries = append(entries, feedEntry{Link: link, Published: published})
+		}
+	}
+
+	for _, item := range append(doc.Channel.Items, doc.Items...) {
+		link := item.Link
+		if link == "" && item.GUID.IsPermaLink != "false" {
+			// A permalink GUID is the item's link, when it's an absolute URL
+			link = preprocessRawURL(strings.TrimSpace(item.GUID.Value), "")
+		}
+		published := parseFeedDate(item.PubDate)
+		if published == nil {
+			published = parseFeedDate(item.Date)
+		}
+		add(link, published)
+	}
+	for _, entry := range doc.Entries {
+		var link string
+		for _, l := range entry.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = l.Href
+				break
+			}
+		}
+		published := parseFeedDate(entry.Published)
+		if published == nil {
+			published = parseFeedDate(entry.Updated)
+		}
+		add(link, published)
+	}
+
+	title := strings.TrimSpace(doc.Channel.Title)
+	if title == "" {
+		title = strings.TrimSpace(doc.Title)
+	}
+	if len(entries) == 0 && doc.Channel.Title == "" && doc.Title == "" {
+		return "", nil, fmt.Errorf("not an RSS or Atom feed")
+	}
+	return title, entries, nil
+}
+
+// SubscribeFeed stores a feed to be polled every interval, or at the default
+// interval when it's zero. The first poll is due right away.
+func SubscribeFeed(feedURL string, interval time.Duration) (*database.Feed, error) {
+	if store == nil {
+		return nil, ErrNoDatabase
+	}
+	u, err := url.ParseRequestURI(feedURL)
+	if err != nil || !isValidHTTPURL(u) {
+		return nil, fmt.Errorf("invalid feed URL %q", feedURL)
+	}
+	if interval == 0 {
+		interval = cfg.FeedPollInterval
+	}
+	if interval < minFeedInterval {
+		return nil, fmt.Errorf("interval must be at least %v", minFeedInterval)
+	}
+
+	feeds, err := store.GetFeeds()
+	if err != nil {
+		return nil, err
+	}
+	for _, feed := range feeds {
+		if feed.URL == feedURL {
+			return nil, ErrFeedExists
+		}
+	}
+
+	feed, err := store.CreateFeed(feedURL, interval)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Subscribed to feed %q, polling every %v", feedURL, interval)
+	return feed, nil
+}
+
+// Feeds lists the subscribed feeds
+func Feeds() ([]database.Feed, error) {
+	if store == nil {
+		return nil, ErrNoDatabase
+	}
+	feeds, err := store.GetFeeds()
+	if feeds == nil {
+		feeds = []database.Feed{}
+	}
+	return feeds, err
+}
+
+// UnsubscribeFeed stops polling a feed. Pages already indexed from it stay.
+func UnsubscribeFeed(id int) error {
+	if store == nil {
+		return ErrNoDatabase
+	}
+	deleted, err := store.DeleteFeed(id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrFeedNotFound
+	}
+	return nil
+}
+
+// PollFeedNow polls a feed right away regardless of its schedule
+func PollFeedNow(id int) (*FeedPoll, error) {
+	if store == nil {
+		return nil, ErrNoDatabase
+	}
+	feed, err := store.GetFeed(id)
+	if err != nil {
+		return nil, err
+	}
+	if feed == nil {
+		return nil, ErrFeedNotFound
+	}
+	return pollFeed(feed)
+}
+
+// feedsPolling holds the IDs of the feeds being polled so a slow poll isn't
+// started again by the next tick
+var (
+	feedsPolling   = make(map[int]bool)
+	feedsPollingMu sync.Mutex
+)
+
+// pollFeed fetches a feed with a conditional GET, crawls the links of entries
+// not seen before along with those that failed in earlier polls, and schedules
+// the next poll
+func pollFeed(feed *database.Feed) (*FeedPoll, error) {
+	feedsPollingMu.Lock()
+	if feedsPolling[feed.ID] {
+		feedsPollingMu.Unlock()
+		return nil, fmt.Errorf("feed %d is already being polled", feed.ID)
+	}
+	feedsPolling[feed.ID] = true
+	feedsPollingMu.Unlock()
+	defer func() {
+		feedsPollingMu.Lock()
+		delete(feedsPolling, feed.ID)
+		feedsPollingMu.Unlock()
+	}()
+
+	poll, err := fetchFeedEntries(feed)
+	if poll == nil {
+		poll = &FeedPoll{}
+	}
+	// Entries still pending are crawled even when the feed couldn't be fetched
+	// or hasn't changed
+	if crawlErr := crawlFeedEntries(feed, poll); err == nil {
+		err = crawlErr
+	}
+
+	now := time.Now()
+	feed.LastPolledAt = &now
+	feed.NextPollAt = now.Add(time.Duration(feed.Interval) * time.Second)
+	feed.LastError = ""
+	if err != nil {
+		feed.LastError = err.Error()
+	}
+	if updateErr := store.UpdateFeedPoll(feed); updateErr != nil {
+		log.Printf("Error saving state of feed %q: %v", feed.URL, updateErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Polled feed %q: %d entries, %d new, %d retried, %d crawled, %d failed (%d given up), %d disallowed",
+		feed.URL, poll.Entries, poll.New, poll.Retried, poll.Crawled, poll.Failed, poll.GaveUp, poll.Disallowed)
+	return poll, nil
+}
+
+// fetchFeedEntries fetches a feed and records its new entries as pending,
+// updating the feed's validators and title from the response
+func fetchFeedEntries(feed *database.Feed) (*FeedPoll, error) {
+	header := http.Header{}
+	if feed.ETag != "" {
+		header.Set("If-None-Match", feed.ETag)
+	}
+	if feed.LastModified != "" {
+		header.Set("If-Modified-Since", feed.LastModified)
+	}
+
+	resp, data, err := politeFetch(context.Background(), feed.URL, header, maxFeedBytes, crawlDelayFor(feed.URL), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return &FeedPoll{NotModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	title, entries, err := parseFeed(data, feed.URL)
+	if err != nil {
+		return nil, err
+	}
+	feed.Title = title
+
+	poll := &FeedPoll{Entries: len(entries)}
+	for _, entry := range entries {
+		added, err := store.AddFeedEntry(feed.ID, entry.Link, entry.Published)
+		if err != nil {
+			return poll, err
+		}
+		if added {
+			poll.New++
+		}
+	}
+
+	feed.ETag = resp.Header.Get("ETag")
+	feed.LastModified = resp.Header.Get("Last-Modified")
+	return poll, nil
+}
+
+// feedCrawlID is the ID of a feed's crawl in the frontier
+func feedCrawlID(id int) string {
+	return "feed-" + strconv.Itoa(id)
+}
+
+// crawlFeedEntries queues the pending entries of a feed in the frontier and
+// waits for them to be fetched, recording each attempt. An entry is given up
+// on once it's refused with a client error or has failed
+// maxFeedEntryAttempts times. Entries queued by another crawl, or dropped
+// when the feed's crawl is cancelled, stay pending for the next poll.
+func crawlFeedEntries(feed *database.Feed, poll *FeedPoll) error {
+	entries, err := store.GetPendingFeedEntries(feed.ID)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	var (
+		mu        sync.Mutex
+		byURL     = make(map[string]*database.FeedEntry, len(entries))
+		updateErr error
+	)
+	for i := range entries {
+		byURL[entries[i].Link] = &entries[i]
+	}
+
+	f := StartFrontier()
+	crawl, release := f.newCrawl(feedCrawlID(feed.ID), feed.URL)
+	f.watch(crawl, func(e *FrontierEntry, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		entry, ok := byURL[e.URL]
+		if !ok || errors.Is(err, context.Canceled) {
+			return
+		}
+		if entry.Attempts > 0 {
+			poll.Retried++
+		}
+		entry.Attempts++
+
+		switch {
+		case errors.Is(err, ErrDisallowed):
+			poll.Disallowed++
+			entry.Status, entry.LastError = "disallowed", ""
+		case err != nil:
+			poll.Failed++
+			entry.LastError = err.Error()
+			var status *statusError
+			refused := errors.As(err, &status) && status.code >= 400 && status.code < 500 && status.code != http.StatusTooManyRequests
+			if refused || entry.Attempts >= maxFeedEntryAttempts {
+				poll.GaveUp++
+				entry.Status = "failed"
+			}
+		default:
+			poll.Crawled++
+			entry.Status, entry.LastError = "crawled", ""
+		}
+		if err := store.UpdateFeedEntry(entry); err != nil && updateErr == nil {
+			updateErr = err
+		}
+	})
+
+	// New entries are fetched even if their page was crawled recently
+	for _, entry := range entries {
+		f.Add(&FrontierEntry{
+			URL:       entry.Link,
+			Crawl:     crawl.id,
+			MaxDepth:  1,
+			Priority:  seedPriority,
+			Published: entry.Published,
+		}, true)
+	}
+	release()
+	<-crawl.done
+
+	mu.Lock()
+	defer mu.Unlock()
+	return updateErr
+}
+
+// WatchFeeds polls the feeds that are due every tick. It never returns.
+func WatchFeeds(tick time.Duration) {
+	for {
+		pollDueFeeds()
+		time.Sleep(tick)
+	}
+}
+
+func pollDueFeeds() {
+	if store == nil {
+		return
+	}
+	feeds, err := store.GetFeeds()
+	if err != nil {
+		log.Printf("Error listing feeds: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range feeds {
+		feedsPollingMu.Lock()
+		polling := feedsPolling[feeds[i].ID]
+		feedsPollingMu.Unlock()
+		if polling || feeds[i].NextPollAt.After(now) {
+			continue
+		}
+		go func(feed *database.Feed) {
+			if _, err := pollFeed(feed); err != nil {
+				log.Printf("Error polling feed %q: %v", feed.URL, err)
+			}
+		}(&feeds[i])
+	}
+}
@@ -0,0 +1,386 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLocalSourceForbidden is returned for file:// URLs outside the configured
+// local source roots
+var ErrLocalSourceForbidden = errors.New("path is not under a configured local source root")
+
+// DirOptions selects the files indexed from a directory. Patterns are matched
+// against slash separated paths relative to the root; a pattern without a slash
+// matches the file or directory name at any depth and ** matches any number of
+// directories, e.g. "*.md", "vendor" or "docs/**/drafts".
+type DirOptions struct {
+	Include []string // files must match one of these, every supported file if empty
+	Exclude []string // files and directories to skip
+}
+
+// SplitPatterns splits a comma separated list of patterns, dropping empty items
+func SplitPatterns(value string) []string {
+	var patterns []string
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// DirStats counts what an IndexDirectory run did
+type DirStats struct {
+	Root      string `json:"root"`
+	Added     int    `json:"added"`
+	Updated   int    `json:"updated"`
+	Unchanged int    `json:"unchanged"`
+	Removed   int    `json:"removed"`
+	Failed    int    `json:"failed"`
+}
+
+// manifestEntry records the state of a local file when it was last indexed
+type manifestEntry struct {
+	DocID   string    `json:"doc_id"`
+	File    string    `json:"file"` // the stored copy's name in the data directory
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+}
+
+// manifestName is the file in the data directory that maps file:// URLs to
+// manifest entries
+const manifestName = "local-sources.manifest"
+
+// manifestMu serializes directory runs, which read and rewrite the manifest
+var manifestMu sync.Mutex
+
+func loadManifest(dir string) (map[string]*manifestEntry, error) {
+	manifest := make(map[string]*manifestEntry)
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func saveManifest(dir string, manifest map[string]*manifestEntry) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated manifest
+	tmp := filepath.Join(dir, manifestName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(dir, manifestName))
+}
+
+// fileURL is the URL a local file is indexed under
+func fileURL(filePath string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filePath)}).String()
+}
+
+// LocalSourcePath returns the directory or file named by a file:// URL, which
+// must be under one of the configured local source roots once symlinks along
+// the way are resolved
+func LocalSourcePath(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") || u.Path == "" {
+		return "", fmt.Errorf("invalid file URL %q", rawURL)
+	}
+	filePath := filepath.Clean(filepath.FromSlash(u.Path))
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	for _, root := range cfg.LocalSourceRoots {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filePath, nil
+		}
+	}
+	return "", ErrLocalSourceForbidden
+}
+
+// Validate checks that every pattern is well formed
+func (o DirOptions) Validate() error {
+	for _, pattern := range append(append([]string{}, o.Include...), o.Exclude...) {
+		if _, err := path.Match(strings.Trim(pattern, "/"), ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (o DirOptions) included(rel string) bool {
+	if len(o.Include) == 0 {
+		return true
+	}
+	return matchAny(o.Include, rel)
+}
+
+func (o DirOptions) excluded(rel string) bool {
+	return matchAny(o.Exclude, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a relative slash separated path against a pattern
+func matchGlob(pattern, rel string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if pattern == "" {
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// IndexDirectory walks a directory, or a single file, and indexes the files
+// with a supported content type into idx under their file:// URLs. Hidden files
+// and directories are skipped. A manifest in the data directory remembers each
+// file's modification time, size and hash, so later runs only re-index files
+// that changed and drop files that are gone.
+//
+// With a nil idx only the stored snapshot in the data directory is updated,
+// for LoadSnapshot and offline tools to pick up.
+func IndexDirectory(idx *Index, root string, opts DirOptions) (*DirStats, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", root, err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	manifest, err := loadManifest(cfg.DataURL)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &dirSource{
+		idx:      idx,
+		manifest: manifest,
+		stats:    &DirStats{Root: root},
+	}
+
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Skipping %s: %v", filePath, err)
+			if d != nil && d.IsDir() && filePath != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel := d.Name()
+		if filePath != root {
+			r, _ := filepath.Rel(root, filePath)
+			rel = filepath.ToSlash(r)
+			if strings.HasPrefix(d.Name(), ".") || opts.excluded(rel) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		// Symlinks under the root are not followed
+		if !d.Type().IsRegular() || !opts.included(rel) {
+			return nil
+		}
+		if NormalizeContentType(strings.TrimPrefix(filepath.Ext(filePath), ".")) == "" {
+			return nil
+		}
+
+		u := fileURL(filePath)
+		seen[u] = true
+		src.indexFile(filePath, u)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking %s: %w", root, err)
+	}
+
+	// Files indexed from this root before that are gone or no longer selected
+	prefix := strings.TrimSuffix(fileURL(root), "/") + "/"
+	for u, entry := range manifest {
+		if !seen[u] && (u == fileURL(root) || strings.HasPrefix(u, prefix)) {
+			src.remove(u, entry)
+		}
+	}
+
+	if err := saveManifest(cfg.DataURL, manifest); err != nil {
+		return src.stats, err
+	}
+
+	log.Printf("Indexed %s: %d added, %d updated, %d unchanged, %d removed, %d failed",
+		root, src.stats.Added, src.stats.Updated, src.stats.Unchanged, src.stats.Removed, src.stats.Failed)
+	return src.stats, nil
+}
+
+// dirSource is the state of one IndexDirectory run, idx is nil when only the
+// snapshot is updated
+type dirSource struct {
+	idx      *Index
+	manifest map[string]*manifestEntry
+	stats    *DirStats
+}
+
+// current reports whether the stored and indexed copies of an unchanged file
+// are still in place
+func (s *dirSource) current(entry *manifestEntry) bool {
+	if _, err := os.Stat(filepath.Join(cfg.DataURL, entry.File)); err != nil {
+		return false
+	}
+	return s.idx == nil || s.idx.HasDocument(entry.DocID)
+}
+
+func (s *dirSource) indexFile(filePath, u string) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		log.Printf("Skipping %s: %v", filePath, err)
+		s.stats.Failed++
+		return
+	}
+
+	entry := s.manifest[u]
+	if entry != nil && entry.ModTime.Equal(info.ModTime()) && entry.Size == info.Size() && s.current(entry) {
+		s.stats.Unchanged++
+		return
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		log.Printf("Skipping %s: %v", filePath, err)
+		s.stats.Failed++
+		return
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	// A touched file with the same content only needs its manifest entry updated
+	if entry != nil && entry.SHA256 == hash && s.current(entry) {
+		entry.ModTime, entry.Size = info.ModTime(), info.Size()
+		s.stats.Unchanged++
+		return
+	}
+
+	docID := documentID(u)
+	doc, stored, err := analyzeAndStore(docID, u, data, &FetchInfo{}, nil)
+	if err != nil {
+		log.Printf("Error indexing %s: %v", filePath, err)
+		s.stats.Failed++
+		// Forget the file so the next run tries again
+		delete(s.manifest, u)
+		return
+	}
+	if s.idx != nil {
+		s.idx.replaceDocuments([]*analyzedDocument{doc})
+		recordIndexed(s.idx, docID, len(doc.fields[FieldBody]))
+	}
+
+	if entry == nil {
+		s.stats.Added++
+	} else {
+		s.stats.Updated++
+	}
+	s.manifest[u] = &manifestEntry{
+		DocID:   docID,
+		File:    filepath.Base(stored),
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		SHA256:  hash,
+	}
+}
+
+// remove drops a deleted file from the index, the data directory and the manifest
+func (s *dirSource) remove(u string, entry *manifestEntry) {
+	if s.idx != nil {
+		s.idx.RemoveDocument(entry.DocID)
+		if store != nil {
+			if err := store.RemoveIndexedDocument(entry.DocID); err != nil {
+				log.Printf("Error removing %s from database: %v", entry.DocID, err)
+			}
+		}
+	}
+	if err := os.Remove(filepath.Join(cfg.DataURL, entry.File)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing stored copy of %s: %v", u, err)
+	}
+
+	docURLMu.Lock()
+	delete(DocURLMap, entry.DocID)
+	delete(docFetchInfo, entry.DocID)
+	docURLMu.Unlock()
+
+	delete(s.manifest, u)
+	s.stats.Removed++
+}
@@ -0,0 +1,811 @@
+package service
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// frontierLogName is the file in the data directory the frontier is kept in:
+// one JSON record per line for every URL queued, fetched or retried, replayed
+// on start so crawls pick up where they left off
+const frontierLogName = "frontier.log"
+
+const (
+	// frontierWorkers is how many URLs are fetched at once, across hosts
+	frontierWorkers = 10
+
+	// maxFetchAttempts is how often a URL that failed with a server or network
+	// error is tried before it's dropped
+	maxFetchAttempts = 3
+
+	// seedPriority is the priority of seed URLs; links found on a page get
+	// half the priority of the page
+	seedPriority = 1.0
+)
+
+// FrontierEntry is a URL waiting to be fetched
+type FrontierEntry struct {
+	URL       string     `json:"url"`
+	Crawl     string     `json:"crawl"`
+	Depth     int        `json:"depth"`
+	MaxDepth  int        `json:"max_depth"` // links are followed while Depth+1 < MaxDepth
+	Priority  float64    `json:"priority"`
+	From      string     `json:"from,omitempty"` // the page the URL was found on
+	NotBefore time.Time  `json:"not_before"`     // when the URL may be fetched
+	Attempts  int        `json:"attempts,omitempty"`
+	Published *time.Time `json:"published,omitempty"` // known from a feed
+
+	host  string // the host the URL is fetched from
+	seq   uint64 // queue order among entries of equal priority
+	index int    // position in its host's queue
+}
+
+// frontierRecord is one line of the frontier log
+type frontierRecord struct {
+	Op    string         `json:"op"` // add, retry, done, seen, pause or resume
+	Entry *FrontierEntry `json:"entry,omitempty"`
+	URL   string         `json:"url,omitempty"`
+	At    time.Time      `json:"at"`
+}
+
+// frontierQueue orders entries by priority, then by the order they were queued
+type frontierQueue []*FrontierEntry
+
+// entryBefore reports whether a is to be fetched before b
+func entryBefore(a, b *FrontierEntry) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.seq < b.seq
+}
+
+func (q frontierQueue) Len() int           { return len(q) }
+func (q frontierQueue) Less(i, j int) bool { return entryBefore(q[i], q[j]) }
+func (q frontierQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *frontierQueue) Push(x any) {
+	e := x.(*FrontierEntry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+func (q *frontierQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// frontierHost queues the entries of one host whose NotBefore has passed, so a
+// host we must wait for doesn't hold up workers that could fetch from others
+type frontierHost struct {
+	name     string
+	ready    frontierQueue
+	inFlight int       // entries handed to workers
+	nextAt   time.Time // earliest hand-out of another entry
+	index    int       // position in readyHosts, -1 when not in it
+}
+
+// frontierHostQueue orders hosts by their first entry
+type frontierHostQueue []*frontierHost
+
+func (q frontierHostQueue) Len() int           { return len(q) }
+func (q frontierHostQueue) Less(i, j int) bool { return entryBefore(q[i].ready[0], q[j].ready[0]) }
+func (q frontierHostQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *frontierHostQueue) Push(x any) {
+	h := x.(*frontierHost)
+	h.index = len(*q)
+	*q = append(*q, h)
+}
+func (q *frontierHostQueue) Pop() any {
+	old := *q
+	h := old[len(old)-1]
+	h.index = -1
+	*q = old[:len(old)-1]
+	return h
+}
+
+// frontierCrawl tracks the URLs of one crawl still queued or being fetched.
+// Its context is cancelled when the crawl is.
+type frontierCrawl struct {
+	id      string
+	seed    string
+	pending int // entries queued or in flight, plus holds
+	stats   *CrawlStats
+	done    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	fetched func(e *FrontierEntry, err error) // see Frontier.watch
+}
+
+// Frontier is the crawler's persistent queue of URLs to fetch and the set of
+// URLs it fetched recently. Workers take the highest priority URL whose
+// NotBefore has passed and whose host may be requested again.
+type Frontier struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	records  int // lines in the log, to know when to compact it
+	seq      uint64
+	paused   bool
+	stopped  bool
+	loading  bool                      // while the log is read back
+	changed  chan struct{}             // closed and replaced whenever workers may have work
+	delayed  map[string]*FrontierEntry // queued with a NotBefore in the future
+	queued   map[string]*FrontierEntry // every queued entry, ready or delayed
+	inFlight map[string]*FrontierEntry
+	seen     map[string]time.Time // when each URL was last queued
+	crawls   map[string]*frontierCrawl
+	workers  sync.WaitGroup
+
+	hosts      map[string]*frontierHost // hosts with ready entries or fetches underway
+	readyHosts frontierHostQueue        // hosts with ready entries that may be requested now
+	waiting    map[string]*frontierHost // hosts with ready entries that must be waited for
+}
+
+var (
+	frontier     *Frontier
+	frontierOnce sync.Once
+)
+
+// StartFrontier loads the frontier from the data directory and starts fetching
+// the URLs left in it. Crawls start it on first use otherwise.
+func StartFrontier() *Frontier {
+	frontierOnce.Do(func() {
+		f, err := openFrontier(filepath.Join(cfg.DataURL, frontierLogName))
+		if err != nil {
+			log.Printf("Error loading crawl frontier, starting empty: %v", err)
+			f = newFrontier("")
+		}
+		// Jobs are watched before the workers can finish their crawls
+		resumeCrawlJobs(f)
+		for i := 0; i < frontierWorkers; i++ {
+			f.workers.Add(1)
+			go f.work()
+		}
+		frontier = f
+	})
+	return frontier
+}
+
+// StopFrontier stops the workers, waiting for their fetches to finish until
+// ctx is done. URLs still queued or being fetched are fetched after the next
+// start.
+func StopFrontier(ctx context.Context) {
+	if frontier == nil {
+		return
+	}
+	f := frontier
+	f.mu.Lock()
+	f.stopped = true
+	f.notify()
+	f.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		f.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("Stopping crawl frontier with fetches underway: %v", ctx.Err())
+	}
+	// Resumed jobs count on from what they had fetched so far
+	saveRunningCrawlJobs()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+func newFrontier(path string) *Frontier {
+	return &Frontier{
+		path:     path,
+		changed:  make(chan struct{}),
+		delayed:  make(map[string]*FrontierEntry),
+		queued:   make(map[string]*FrontierEntry),
+		inFlight: make(map[string]*FrontierEntry),
+		seen:     make(map[string]time.Time),
+		crawls:   make(map[string]*frontierCrawl),
+		hosts:    make(map[string]*frontierHost),
+		waiting:  make(map[string]*frontierHost),
+	}
+}
+
+// openFrontier replays the frontier log at path, then compacts it
+func openFrontier(path string) (*Frontier, error) {
+	f := newFrontier(path)
+
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		f.loading = true
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			var record frontierRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				log.Printf("Skipping line %d of %s: malformed record", line, frontierLogName)
+				continue
+			}
+			f.replay(record)
+		}
+		file.Close()
+		f.loading = false
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", frontierLogName, err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+	if len(f.queued) > 0 {
+		log.Printf("Resuming %d queued URLs of %d crawls", len(f.queued), len(f.crawls))
+	}
+	return f, nil
+}
+
+func (f *Frontier) replay(record frontierRecord) {
+	switch record.Op {
+	case "add", "retry":
+		if record.Entry == nil {
+			return
+		}
+		old, requeued := f.queued[record.Entry.URL]
+		if requeued {
+			f.remove(old)
+		}
+		f.seen[record.Entry.URL] = record.At
+		f.enqueue(record.Entry)
+		if requeued {
+			f.finish(old.Crawl)
+		}
+	case "done":
+		if e, ok := f.queued[record.URL]; ok {
+			f.remove(e)
+			f.finish(e.Crawl)
+		}
+	case "seen":
+		f.seen[record.URL] = record.At
+	case "pause":
+		f.paused = true
+	case "resume":
+		f.paused = false
+	}
+}
+
+// compact rewrites the log with only the records needed to rebuild the
+// frontier, dropping URLs seen longer ago than the recrawl interval.
+// f.mu must be held.
+func (f *Frontier) compact() error {
+	if f.path == "" {
+		return nil
+	}
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := f.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	records := 0
+	write := func(record frontierRecord) {
+		enc.Encode(record)
+		records++
+	}
+
+	if f.paused {
+		write(frontierRecord{Op: "pause", At: time.Now()})
+	}
+	expired := time.Now().Add(-cfg.RecrawlInterval)
+	for url, at := range f.seen {
+		if _, queued := f.queued[url]; queued {
+			continue
+		}
+		if at.Before(expired) {
+			delete(f.seen, url)
+			continue
+		}
+		write(frontierRecord{Op: "seen", URL: url, At: at})
+	}
+	for url, e := range f.queued {
+		write(frontierRecord{Op: "add", Entry: e, At: f.seen[url]})
+	}
+	// URLs being fetched are queued again in case the fetch never finishes
+	for url, e := range f.inFlight {
+		write(frontierRecord{Op: "add", Entry: e, At: f.seen[url]})
+	}
+
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return err
+	}
+
+	f.file, err = os.OpenFile(f.path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.records = records
+	return err
+}
+
+// write appends a record to the log, compacting it once most of its lines
+// are stale. f.mu must be held.
+func (f *Frontier) write(record frontierRecord) {
+	if f.file == nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing %s: %v", frontierLogName, err)
+		return
+	}
+	f.records++
+
+	if live := len(f.seen) + len(f.queued); f.records > 10000 && f.records > 4*live {
+		if err := f.compact(); err != nil {
+			log.Printf("Error compacting %s: %v", frontierLogName, err)
+		}
+	}
+}
+
+// notify wakes the workers. f.mu must be held.
+func (f *Frontier) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// enqueue adds an entry to the queue and counts it in its crawl. f.mu must be held.
+func (f *Frontier) enqueue(e *FrontierEntry) {
+	f.seq++
+	e.seq = f.seq
+	if u, err := url.Parse(e.URL); err == nil {
+		e.host = u.Host
+	}
+	f.queued[e.URL] = e
+	if time.Now().Before(e.NotBefore) {
+		f.delayed[e.URL] = e
+	} else {
+		f.pushReady(e)
+	}
+
+	c := f.crawl(e.Crawl, "")
+	c.pending++
+	if c.seed == "" && e.Depth == 0 && e.From == "" {
+		c.seed = e.URL
+	}
+}
+
+// remove takes an entry off the queue without counting it off its crawl.
+// f.mu must be held.
+func (f *Frontier) remove(e *FrontierEntry) {
+	delete(f.queued, e.URL)
+	if _, ok := f.delayed[e.URL]; ok {
+		delete(f.delayed, e.URL)
+	} else {
+		h := f.hosts[e.host]
+		heap.Remove(&h.ready, e.index)
+		f.settleHost(h)
+	}
+}
+
+// pushReady queues an entry whose NotBefore has passed with its host. A host
+// new to the queue waits until next checks it may be requested. f.mu must be
+// held.
+func (f *Frontier) pushReady(e *FrontierEntry) {
+	h, ok := f.hosts[e.host]
+	if !ok {
+		h = &frontierHost{name: e.host, index: -1}
+		f.hosts[e.host] = h
+	}
+	heap.Push(&h.ready, e)
+	if h.index >= 0 {
+		heap.Fix(&f.readyHosts, h.index)
+	} else {
+		f.waiting[h.name] = h
+	}
+}
+
+// settleHost updates a host's place after its queue or fetches changed,
+// forgetting it once it has neither. f.mu must be held.
+func (f *Frontier) settleHost(h *frontierHost) {
+	if h.ready.Len() > 0 {
+		if h.index >= 0 {
+			heap.Fix(&f.readyHosts, h.index)
+		}
+		return
+	}
+	if h.index >= 0 {
+		heap.Remove(&f.readyHosts, h.index)
+	}
+	delete(f.waiting, h.name)
+	if h.inFlight == 0 {
+		delete(f.hosts, h.name)
+	}
+}
+
+// hostReadyAt returns when another entry of a host may be handed out, and
+// false when the host must first finish one of its fetches. f.mu must be held.
+func (f *Frontier) hostReadyAt(h *frontierHost) (time.Time, bool) {
+	if h.inFlight >= max(cfg.CrawlHostConcurrency, 1) {
+		return time.Time{}, false
+	}
+	at := hostLimiterReadyAt(h.name)
+	if at.Before(h.nextAt) {
+		at = h.nextAt
+	}
+	return at, true
+}
+
+// crawl returns the state of a crawl, creating it if needed. f.mu must be held.
+func (f *Frontier) crawl(id, seed string) *frontierCrawl {
+	c, ok := f.crawls[id]
+	if !ok {
+		c = &frontierCrawl{id: id, seed: seed, stats: &CrawlStats{}, done: make(chan struct{})}
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		f.crawls[id] = c
+	}
+	return c
+}
+
+// finish counts off one pending entry or hold of a crawl. f.mu must be held.
+func (f *Frontier) finish(id string) {
+	c, ok := f.crawls[id]
+	if !ok {
+		return
+	}
+	if c.pending--; c.pending <= 0 {
+		delete(f.crawls, id)
+		close(c.done)
+		if f.loading {
+			return
+		}
+		outcome := "Finished"
+		if c.ctx.Err() != nil {
+			outcome = "Cancelled"
+		}
+		log.Printf("%s crawl %s of %q: %d pages fetched, %d failed, %d retried, %d disallowed by robots.txt, %d throttled",
+			outcome, id, c.seed, c.stats.Fetched, c.stats.Failed, c.stats.Retried, c.stats.Disallowed, c.stats.Throttled)
+	}
+}
+
+// newCrawl starts a crawl that stays open until release is called, so URLs
+// can be queued to it before any are fetched. Its done channel is closed once
+// it's released and all its URLs are fetched. An empty id gets a random one.
+func (f *Frontier) newCrawl(id, seed string) (crawl *frontierCrawl, release func()) {
+	if id == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+
+	f.mu.Lock()
+	crawl = f.crawl(id, seed)
+	crawl.pending++
+	f.mu.Unlock()
+
+	var once sync.Once
+	return crawl, func() {
+		once.Do(func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.finish(id)
+		})
+	}
+}
+
+// watch has fn called with the outcome of every URL of a crawl that won't be
+// tried again, before the crawl can be done. URLs dropped by Cancel aren't
+// passed to it.
+func (f *Frontier) watch(crawl *frontierCrawl, fn func(e *FrontierEntry, err error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	crawl.fetched = fn
+}
+
+// Add queues an entry unless its URL is already queued, or was queued within
+// the recrawl interval and requeue is false, or its crawl was cancelled. It
+// reports whether it was added.
+func (f *Frontier) Add(e *FrontierEntry, requeue bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if c, ok := f.crawls[e.Crawl]; ok && c.ctx.Err() != nil {
+		return false
+	}
+	if _, ok := f.queued[e.URL]; ok {
+		return false
+	}
+	if _, ok := f.inFlight[e.URL]; ok {
+		return false
+	}
+	if at, ok := f.seen[e.URL]; ok && !requeue && time.Since(at) < cfg.RecrawlInterval {
+		return false
+	}
+
+	now := time.Now()
+	f.seen[e.URL] = now
+	f.enqueue(e)
+	f.write(frontierRecord{Op: "add", Entry: e, At: now})
+	f.notify()
+	return true
+}
+
+// next blocks until an entry may be fetched and marks it in flight. It
+// returns nil once the frontier is stopped.
+func (f *Frontier) next() *FrontierEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		if f.stopped {
+			return nil
+		}
+
+		// Delayed entries that came due join their host's queue
+		now := time.Now()
+		var wake time.Time
+		wakeAt := func(at time.Time) {
+			if wake.IsZero() || at.Before(wake) {
+				wake = at
+			}
+		}
+		for url, e := range f.delayed {
+			if !now.Before(e.NotBefore) {
+				delete(f.delayed, url)
+				f.pushReady(e)
+			} else {
+				wakeAt(e.NotBefore)
+			}
+		}
+
+		if !f.paused {
+			// Hosts that may be requested again join the ready hosts; those
+			// at their limit of fetches are woken when one finishes
+			for name, h := range f.waiting {
+				if at, ok := f.hostReadyAt(h); ok && !now.Before(at) {
+					delete(f.waiting, name)
+					heap.Push(&f.readyHosts, h)
+				} else if ok {
+					wakeAt(at)
+				}
+			}
+
+			for f.readyHosts.Len() > 0 {
+				h := heap.Pop(&f.readyHosts).(*frontierHost)
+				// The host may have been throttled since it joined
+				if at, ok := f.hostReadyAt(h); !ok || now.Before(at) {
+					f.waiting[h.name] = h
+					if ok {
+						wakeAt(at)
+					}
+					continue
+				}
+
+				e := heap.Pop(&h.ready).(*FrontierEntry)
+				h.inFlight++
+				h.nextAt = now.Add(cfg.CrawlHostDelay)
+				if h.ready.Len() > 0 {
+					f.waiting[h.name] = h
+				}
+				delete(f.queued, e.URL)
+				f.inFlight[e.URL] = e
+				return e
+			}
+		}
+
+		changed := f.changed
+		var timer <-chan time.Time
+		if !wake.IsZero() {
+			timer = time.After(time.Until(wake))
+		}
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer:
+		}
+		f.mu.Lock()
+	}
+}
+
+// done records the outcome of fetching an entry, queueing it again after a
+// server or network error until it runs out of attempts. Only the last
+// attempt is counted as fetched or failed in the crawl's stats.
+func (f *Frontier) done(e *FrontierEntry, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.inFlight, e.URL)
+	if h, ok := f.hosts[e.host]; ok {
+		h.inFlight--
+		f.settleHost(h)
+	}
+	// The host may take another entry
+	f.notify()
+
+	c := f.crawl(e.Crawl, "")
+	if err != nil && c.ctx.Err() == nil && retryableFetchError(err) && e.Attempts+1 < maxFetchAttempts {
+		c.stats.addRetried()
+		e.Attempts++
+		e.NotBefore = time.Now().Add(time.Minute << (e.Attempts - 1))
+		f.enqueue(e)
+		f.finish(e.Crawl) // enqueue counted the entry again
+		f.write(frontierRecord{Op: "retry", Entry: e, At: f.seen[e.URL]})
+		return
+	}
+
+	c.stats.record(err)
+	f.write(frontierRecord{Op: "done", URL: e.URL, At: time.Now()})
+	if c.fetched != nil {
+		// The crawl stays open until the hook returns
+		fetched := c.fetched
+		f.mu.Unlock()
+		fetched(e, err)
+		f.mu.Lock()
+	}
+	f.finish(e.Crawl)
+}
+
+// work fetches entries until the frontier stops, queueing the links of each
+// page fetched
+func (f *Frontier) work() {
+	defer f.workers.Done()
+	for {
+		e := f.next()
+		if e == nil {
+			return
+		}
+
+		f.mu.Lock()
+		c := f.crawl(e.Crawl, "")
+		f.mu.Unlock()
+
+		links, err := crawlPage(c.ctx, e.URL, e.Published, c.stats)
+		if err == nil && e.Depth+1 < e.MaxDepth {
+			for link := range links {
+				if link == "" {
+					continue
+				}
+				f.Add(&FrontierEntry{
+					URL:      link,
+					Crawl:    e.Crawl,
+					Depth:    e.Depth + 1,
+					MaxDepth: e.MaxDepth,
+					Priority: e.Priority / 2,
+					From:     e.URL,
+				}, false)
+			}
+		}
+		f.done(e, err)
+	}
+}
+
+// Cancel stops a crawl: its queued URLs are dropped and the fetches underway
+// are abandoned. It reports whether the crawl was still going.
+func (f *Frontier) Cancel(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.crawls[id]
+	if !ok || c.ctx.Err() != nil {
+		return false
+	}
+	c.cancel()
+	for url, e := range f.queued {
+		if e.Crawl != id {
+			continue
+		}
+		f.remove(e)
+		f.write(frontierRecord{Op: "done", URL: url, At: time.Now()})
+		f.finish(id)
+	}
+	return true
+}
+
+// SetPaused stops or restarts handing out URLs. Fetches underway finish.
+func (f *Frontier) SetPaused(paused bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.paused == paused {
+		return
+	}
+	f.paused = paused
+	op := "resume"
+	if paused {
+		op = "pause"
+	}
+	f.write(frontierRecord{Op: op, At: time.Now()})
+	f.notify()
+}
+
+// CrawlProgress is the state of a crawl with URLs left in the frontier
+type CrawlProgress struct {
+	ID      string      `json:"id"`
+	Seed    string      `json:"seed,omitempty"`
+	Pending int         `json:"pending"`
+	Stats   *CrawlStats `json:"stats"`
+}
+
+// FrontierStatus summarizes the frontier
+type FrontierStatus struct {
+	Paused   bool            `json:"paused"`
+	Queued   int             `json:"queued"`
+	Delayed  int             `json:"delayed"` // waiting for a retry
+	InFlight int             `json:"in_flight"`
+	Seen     int             `json:"seen"`
+	Crawls   []CrawlProgress `json:"crawls"`
+}
+
+func (f *Frontier) Status() *FrontierStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := &FrontierStatus{
+		Paused:   f.paused,
+		Queued:   len(f.queued),
+		Delayed:  len(f.delayed),
+		InFlight: len(f.inFlight),
+		Seen:     len(f.seen),
+		Crawls:   []CrawlProgress{},
+	}
+	for _, c := range f.crawls {
+		status.Crawls = append(status.Crawls, CrawlProgress{
+			ID:      c.id,
+			Seed:    c.seed,
+			Pending: c.pending,
+			Stats:   c.stats.Snapshot(),
+		})
+	}
+	return status
+}
+
+// retryableFetchError reports whether a fetch may succeed if tried again
+func retryableFetchError(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code >= 500 || status.code == 429
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"fmt"
 	"math"
+	"path"
 	"sort"
 	"strings"
 	"sync"
@@ -22,6 +23,15 @@ type Index struct {
 	avgDL     float64
 	sumDocLen int
 
+	// BM25 scoring parameters
+	bm25 BM25Params
+
+	// Bumped on every removal so cached results never list a removed document
+	removals int
+
+	// Secondary fields such as the title; the body lives in the fields above
+	fields map[string]*fieldIndex
+
 	// Multi-layer cache
 	cache *cache.MultiLayerCache
 
@@ -30,10 +40,170 @@ type Index struct {
 	docMetaMutex sync.RWMutex
 }
 
+// fieldIndex holds the postings and length statistics of one secondary field
+type fieldIndex struct {
+	postings  map[string]map[string][]int
+	docLen    map[string]int
+	docFreq   map[string]int
+	sumDocLen int
+}
+
+func newFieldIndex() *fieldIndex {
+	return &fieldIndex{
+		postings: make(map[string]map[string][]int),
+		docLen:   make(map[string]int),
+		docFreq:  make(map[string]int),
+	}
+}
+
+// add indexes a document's tokens in this field
+func (f *fieldIndex) add(docID string, tokens []Token) {
+	f.docLen[docID] = len(tokens)
+	f.sumDocLen += len(tokens)
+
+	seen := map[string]bool{}
+	for _, token := range tokens {
+		if f.postings[token.Term] == nil {
+			f.postings[token.Term] = make(map[string][]int)
+		}
+		f.postings[token.Term][docID] = append(f.postings[token.Term][docID], token.Position)
+
+		if !seen[token.Term] {
+			f.docFreq[token.Term]++
+			seen[token.Term] = true
+		}
+	}
+}
+
+// remove drops a document's postings from this field
+func (f *fieldIndex) remove(docID string) {
+	length, found := f.docLen[docID]
+	if !found {
+		return
+	}
+	for term, docs := range f.postings {
+		if _, ok := docs[docID]; !ok {
+			continue
+		}
+		delete(docs, docID)
+		f.docFreq[term]--
+		if len(docs) == 0 {
+			delete(f.postings, term)
+			delete(f.docFreq, term)
+		}
+	}
+	delete(f.docLen, docID)
+	f.sumDocLen -= length
+}
+
+// hasPhrase reports whether the document contains the tokens at the same
+// relative positions. Tokens sharing a position are alternatives, and gaps left
+// by removed stopwords must line up, so "war of the worlds" only matches a
+// document where "war" is three positions before "world".
+func (f *fieldIndex) hasPhrase(docID string, tokens []Token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+
+	first := tokens[0].Position
+	for _, token := range tokens {
+		if token.Position < first {
+			first = token.Position
+		}
+	}
+
+	// For every occurrence of a first-position alternative, check the rest
+	for _, anchor := range tokens {
+		if anchor.Position != first {
+			continue
+		}
+		for _, start := range f.postings[anchor.Term][docID] {
+			if f.phraseAt(docID, tokens, start-first) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// termPostings returns the positions of a query term in each document
+// containing it. A phrase is found at the position of its first term wherever
+// the rest follow it.
+func (f *fieldIndex) termPostings(qt queryTerm) map[string][]int {
+	if len(qt.Phrase) == 0 {
+		return f.postings[qt.Term]
+	}
+
+	tokens := make([]Token, len(qt.Phrase))
+	for i, term := range qt.Phrase {
+		tokens[i] = Token{Term: term, Position: i}
+	}
+	postings := make(map[string][]int)
+	for docID, starts := range f.postings[qt.Phrase[0]] {
+		for _, start := range starts {
+			if f.phraseAt(docID, tokens, start) {
+				postings[docID] = append(postings[docID], start)
+			}
+		}
+	}
+	return postings
+}
+
+// phraseAt checks that every query position has an alternative at offset+position
+func (f *fieldIndex) phraseAt(docID string, tokens []Token, offset int) bool {
+	matched := make(map[int]bool)
+	wanted := make(map[int]bool)
+	for _, token := range tokens {
+		wanted[token.Position] = true
+		if matched[token.Position] {
+			continue
+		}
+		for _, pos := range f.postings[token.Term][docID] {
+			if pos == offset+token.Position {
+				matched[token.Position] = true
+				break
+			}
+		}
+	}
+	return len(matched) == len(wanted)
+}
+
+// defaultFieldWeights scale each secondary field's BM25 score relative to the body
+var defaultFieldWeights = map[string]float64{
+	FieldHeadings:    1.2,
+	FieldTitle:       1.5,
+	FieldBoilerplate: 0.1,
+}
+
+// BM25Params holds the tunable parameters of the BM25 scoring function
+type BM25Params struct {
+	K1 float64 `json:"k1"`
+	B  float64 `json:"b"`
+}
+
+// DefaultBM25Params returns the standard BM25 parameters used by the engine
+func DefaultBM25Params() BM25Params {
+	return BM25Params{K1: 1.5, B: 0.75}
+}
+
 type DocumentMetadata struct {
-	URL        string    `json:"url"`
-	Title      string    `json:"title"`
-	Length     int       `json:"length"`
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Headings    []string `json:"headings,omitempty"`
+	Canonical   string   `json:"canonical,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	Charset     string   `json:"charset,omitempty"`
+	Length      int      `json:"length"`
+	Language    string   `json:"language,omitempty"`
+
+	// Filterable and facetable fields from the page's structured data
+	Type       string          `json:"type,omitempty"`
+	Authors    []string        `json:"authors,omitempty"`
+	Published  *time.Time      `json:"published,omitempty"`
+	Modified   *time.Time      `json:"modified,omitempty"`
+	Structured *StructuredData `json:"structured,omitempty"`
+
 	IndexedAt  time.Time `json:"indexed_at"`
 	LastAccess time.Time `json:"last_access"`
 }
@@ -42,8 +212,14 @@ type SearchResult struct {
 	DocID    string            `json:"doc_id"`
 	URL      string            `json:"url"`
 	Title    string            `json:"title"`
+	Snippet  string            `json:"snippet,omitempty"`
 	Score    float64           `json:"score"`
 	Metadata *DocumentMetadata `json:"metadata,omitempty"`
+
+	// Experiment attribution, set when the result was served under an experiment
+	Experiment string `json:"experiment,omitempty"`
+	Team       string `json:"team,omitempty"`
+	Impression string `json:"impression,omitempty"`
 }
 
 type CachedSearchResults struct {
@@ -51,6 +227,8 @@ type CachedSearchResults struct {
 	Query     string         `json:"query"`
 	Timestamp time.Time      `json:"timestamp"`
 	TotalDocs int            `json:"total_docs"`
+	Removals  int            `json:"removals"`
+	Clicks    int            `json:"clicks"` // click model version of the query
 }
 
 func NewInvertedIndex() *Index {
@@ -66,19 +244,86 @@ func NewInvertedIndex() *Index {
 		fmt.Printf("Failed to initialize cache: %v\n", err)
 	}
 
+	return newIndex(multiCache)
+}
+
+// NewUncachedIndex creates an index without the multi-layer cache, for offline
+// tools that must not share cached postings or query results with the server
+func NewUncachedIndex() *Index {
+	return newIndex(nil)
+}
+
+func newIndex(multiCache *cache.MultiLayerCache) *Index {
 	return &Index{
 		index:        make(map[string]map[string][]int),
 		docLen:       make(map[string]int),
 		docFreq:      make(map[string]int),
+		bm25:         DefaultBM25Params(),
+		fields:       make(map[string]*fieldIndex),
 		cache:        multiCache,
 		docMetaCache: make(map[string]*DocumentMetadata),
 	}
 }
 
+// SetBM25Params changes the scoring parameters and drops cached query results
+// that were ranked with the previous ones
+func (idx *Index) SetBM25Params(params BM25Params) {
+	idx.mu.Lock()
+	idx.bm25 = params
+	idx.mu.Unlock()
+
+	if idx.cache != nil {
+		idx.cache.Clear()
+	}
+}
+
+// BM25Params returns the scoring parameters currently in use
+func (idx *Index) BM25Params() BM25Params {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.bm25
+}
+
+// bodyField views the body postings as a field, callers hold idx.mu
+func (idx *Index) bodyField() *fieldIndex {
+	return &fieldIndex{
+		postings:  idx.index,
+		docLen:    idx.docLen,
+		docFreq:   idx.docFreq,
+		sumDocLen: idx.sumDocLen,
+	}
+}
+
 func (idx *Index) AddDocument(docID string, tokens []string) {
+	idx.AddAnalyzedDocument(docID, map[string][]Token{FieldBody: positionTokens(tokens)}, nil)
+}
+
+// AddAnalyzedDocument indexes a document whose fields were already analyzed.
+// Metadata fields left empty are filled in from what the index knows.
+func (idx *Index) AddAnalyzedDocument(docID string, fields map[string][]Token, metadata *DocumentMetadata) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.addAnalyzedDocument(docID, fields, metadata)
+}
+
+// replaceDocuments indexes a batch of analyzed documents under a single lock,
+// replacing earlier versions. It reports which documents were already indexed.
+func (idx *Index) replaceDocuments(docs []*analyzedDocument) []bool {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
+	replaced := make([]bool, len(docs))
+	for i, doc := range docs {
+		replaced[i] = idx.removeDocument(doc.docID)
+		idx.addAnalyzedDocument(doc.docID, doc.fields, doc.metadata)
+	}
+	return replaced
+}
+
+// addAnalyzedDocument does the work of AddAnalyzedDocument, idx.mu must be held
+func (idx *Index) addAnalyzedDocument(docID string, fields map[string][]Token, metadata *DocumentMetadata) {
+	tokens := fields[FieldBody]
+
 	// Check if document already exists in cache
 	cacheKey := "doc:" + docID
 	if idx.cache != nil {
@@ -99,18 +344,31 @@ func (idx *Index) AddDocument(docID string, tokens []string) {
 	// Track which terms we've already bumped document frequency for
 	seen := map[string]bool{}
 
-	for pos, token := range tokens {
+	for _, token := range tokens {
+		term := token.Term
+
 		// Init new term in index if needed
-		if idx.index[token] == nil {
-			idx.index[token] = make(map[string][]int)
+		if idx.index[term] == nil {
+			idx.index[term] = make(map[string][]int)
 		}
-		idx.index[token][docID] = append(idx.index[token][docID], pos)
+		idx.index[term][docID] = append(idx.index[term][docID], token.Position)
 
 		// Bump doc frequency once for each term
-		if !seen[token] {
-			idx.docFreq[token]++
-			seen[token] = true
+		if !seen[term] {
+			idx.docFreq[term]++
+			seen[term] = true
+		}
+	}
+
+	// Index the secondary fields
+	for name, fieldTokens := range fields {
+		if name == FieldBody || len(fieldTokens) == 0 {
+			continue
+		}
+		if idx.fields[name] == nil {
+			idx.fields[name] = newFieldIndex()
 		}
+		idx.fields[name].add(docID, fieldTokens)
 	}
 
 	// Recompute avg doc length
@@ -122,13 +380,18 @@ func (idx *Index) AddDocument(docID string, tokens []string) {
 	url := DocURLMap[docID]
 	docURLMu.RUnlock()
 
-	metadata := &DocumentMetadata{
-		URL:        url,
-		Title:      extractTitleFromURL(url),
-		Length:     len(tokens),
-		IndexedAt:  time.Now(),
-		LastAccess: time.Now(),
+	if metadata == nil {
+		metadata = &DocumentMetadata{}
+	}
+	if metadata.URL == "" {
+		metadata.URL = url
 	}
+	if metadata.Title == "" {
+		metadata.Title = extractTitleFromURL(url)
+	}
+	metadata.Length = len(tokens)
+	metadata.IndexedAt = time.Now()
+	metadata.LastAccess = time.Now()
 
 	// Cache document metadata
 	idx.docMetaMutex.Lock()
@@ -138,7 +401,7 @@ func (idx *Index) AddDocument(docID string, tokens []string) {
 	// Cache the document in multi-layer cache
 	if idx.cache != nil {
 		docData := map[string]interface{}{
-			"tokens":     tokens,
+			"tokens":     tokenTerms(tokens),
 			"metadata":   metadata,
 			"indexed_at": time.Now(),
 		}
@@ -149,12 +412,23 @@ func (idx *Index) AddDocument(docID string, tokens []string) {
 }
 
 func (idx *Index) Search(query string, topK int) []SearchResult {
+	return idx.SearchWith(SearchRequest{Query: query, TopK: topK})
+}
+
+// SearchWith runs a search request, serving repeated requests from the query cache
+func (idx *Index) SearchWith(req SearchRequest) []SearchResult {
 	start := time.Now()
+	query := req.Query
+	req = req.normalized()
+	cacheKey := req.cacheKey()
+
+	// Results ranked before the query's latest clicks miss their boost
+	clicks := Clicks.Version(NormalizeQuery(query))
 
 	// Check query result cache first
 	if idx.cache != nil {
-		if cached, found := idx.cache.GetQueryResult(query); found {
-			if cachedResults, ok := cached.(CachedSearchResults); ok {
+		if cached, found := idx.cache.GetQueryResult(cacheKey); found {
+			if cachedResults, ok := cached.(CachedSearchResults); ok && cachedResults.Removals == idx.removalCount() && cachedResults.Clicks == clicks {
 				// Update access times for returned documents
 				for _, result := range cachedResults.Results {
 					idx.updateDocumentAccess(result.DocID)
@@ -167,7 +441,7 @@ func (idx *Index) Search(query string, topK int) []SearchResult {
 	}
 
 	// Cache miss - perform actual search
-	results := idx.performSearch(query, topK)
+	results := idx.performSearch(req)
 
 	// Cache the results
 	if idx.cache != nil {
@@ -176,8 +450,10 @@ func (idx *Index) Search(query string, topK int) []SearchResult {
 			Query:     query,
 			Timestamp: time.Now(),
 			TotalDocs: idx.docCount,
+			Removals:  idx.removalCount(),
+			Clicks:    clicks,
 		}
-		idx.cache.SetQueryResult(query, cachedResults)
+		idx.cache.SetQueryResult(cacheKey, cachedResults)
 	}
 
 	searchTime := time.Since(start)
@@ -187,22 +463,93 @@ func (idx *Index) Search(query string, topK int) []SearchResult {
 	return results
 }
 
-func (idx *Index) performSearch(query string, topK int) []SearchResult {
-	terms := Tokenize(query)
-	terms = tokenDeduper(terms)
-
+func (idx *Index) performSearch(req SearchRequest) []SearchResult {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
+	scores, terms := idx.scoreDocuments(req)
+
+	results := make([]SearchResult, 0, len(scores))
+	for docID, score := range scores {
+		// Get document metadata
+		metadata := idx.getDocumentMetadata(docID)
+		if !req.matches(metadata) {
+			continue
+		}
+
+		docURLMu.RLock()
+		url := DocURLMap[docID]
+		docURLMu.RUnlock()
+
+		result := SearchResult{
+			DocID:    docID,
+			URL:      url,
+			Title:    metadata.Title,
+			Snippet:  metadata.Description,
+			Score:    score,
+			Metadata: metadata,
+		}
+		results = append(results, result)
+
+		// Update document access time
+		idx.updateDocumentAccess(docID)
+	}
+
+	// Sort by score descending
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	// Let the profile's rerankers adjust the ranked list before trimming
+	if req.Profile != nil && len(req.Profile.Rerankers) > 0 {
+		idx.rerank(req.Profile, terms, results)
+	}
+
+	// Trim to topK
+	if len(results) > req.TopK {
+		results = results[:req.TopK]
+	}
+
+	return results
+}
+
+// scoreDocuments scores every document matching the request's query, before
+// its filters are applied, and returns the analyzed query terms along with
+// the scores. idx.mu must be held for reading.
+func (idx *Index) scoreDocuments(req SearchRequest) (map[string]float64, []string) {
+	query, profile := req.Query, req.Profile
+	languages := req.queryLanguages()
+
+	analyzed := analyzeQuery(FieldBody, query, languages)
+
+	// A query made only of stopwords ("the who") is matched against the
+	// stopwords removed from the body instead of matching nothing
+	stopwordsOnly := len(analyzed) == 0
+	if stopwordsOnly {
+		analyzed = analyzeQueryStopwords(query, languages)
+	}
+	terms := tokenDeduper(analyzed)
+
+	// Expand the query with synonyms, weighted below the original terms
+	queryTerms := Synonyms.Expand(analyzed, cfg.SynonymWeight)
+
+	body := idx.bodyField()
+	if stopwordsOnly {
+		if body = idx.fields[FieldStopwords]; body == nil {
+			return map[string]float64{}, terms
+		}
+	}
+
 	candidates := map[string]struct{}{}
 
 	// Check term cache first
-	for _, term := range terms {
+	for _, qt := range queryTerms {
+		term := qt.Term
 		cacheKey := "term:" + term
 		var postings map[string][]int
 		var found bool
 
-		if idx.cache != nil {
+		if idx.cache != nil && !stopwordsOnly {
 			if cached, exists := idx.cache.Get(cacheKey); exists {
 				if p, ok := cached.(map[string][]int); ok {
 					postings = p
@@ -213,14 +560,13 @@ func (idx *Index) performSearch(query string, topK int) []SearchResult {
 
 		if !found {
 			// Term not in cache, get from index
-			var ok bool
-			postings, ok = idx.index[term]
-			if !ok {
+			postings = body.termPostings(qt)
+			if len(postings) == 0 {
 				continue // Term not in any doc
 			}
 
 			// Cache the term postings
-			if idx.cache != nil {
+			if idx.cache != nil && !stopwordsOnly {
 				idx.cache.Set(cacheKey, postings)
 			}
 		}
@@ -230,18 +576,29 @@ func (idx *Index) performSearch(query string, topK int) []SearchResult {
 		}
 	}
 
+	// Resolve ranking parameters from the profile, falling back to the index defaults
+	params, clickBoost := idx.bm25, cfg.ClickBoost
+	if profile != nil {
+		params, clickBoost = profile.BM25, profile.ClickBoost
+	}
+
 	// Calculate BM25 scores
 	scores := map[string]float64{}
 	N := float64(idx.docCount)
-	k1, b := 1.5, 0.75
+	k1, b := params.K1, params.B
+	avgdl := float64(body.sumDocLen) / float64(len(body.docLen))
 
-	for _, term := range terms {
-		postings := idx.index[term]
-		df := float64(idx.docFreq[term])
+	for _, qt := range queryTerms {
+		postings := body.termPostings(qt)
+		df := float64(len(postings))
 		if df == 0 {
 			continue
 		}
 		idf := math.Log((N - df + 0.5) / (df + 0.5)) // BM25 IDF
+		if stopwordsOnly {
+			// Stopwords occur in most documents, where the classic IDF goes negative
+			idf = math.Log1p((N - df + 0.5) / (df + 0.5))
+		}
 
 		for docID := range candidates {
 			positions := postings[docID]
@@ -249,46 +606,58 @@ func (idx *Index) performSearch(query string, topK int) []SearchResult {
 			if tf == 0 {
 				continue
 			}
-			dl := float64(idx.docLen[docID])
-			avgdl := idx.avgDL
+			dl := float64(body.docLen[docID])
 			scoreTerm := idf * (tf * (k1 + 1)) / (tf + k1*(1-b+b*(dl/avgdl)))
-			scores[docID] += scoreTerm
+			scores[docID] += qt.Weight * scoreTerm
 		}
 	}
 
-	results := make([]SearchResult, 0, len(scores))
-	for docID, score := range scores {
-		// Get document metadata
-		metadata := idx.getDocumentMetadata(docID)
+	// Add the weighted BM25 scores of the secondary fields
+	for name, field := range idx.fields {
+		weight := profile.fieldWeight(name)
+		if stopwordsOnly || name == FieldStopwords || weight <= 0 || field.sumDocLen == 0 {
+			continue
+		}
+		avgFieldLen := float64(field.sumDocLen) / float64(len(field.docLen))
+		fieldTerms := Synonyms.Expand(analyzeQuery(name, query, languages), cfg.SynonymWeight)
 
-		docURLMu.RLock()
-		url := DocURLMap[docID]
-		docURLMu.RUnlock()
+		for _, qt := range fieldTerms {
+			postings := field.termPostings(qt)
+			df := float64(len(postings))
+			if df == 0 {
+				continue
+			}
+			idf := math.Log((N - df + 0.5) / (df + 0.5))
 
-		result := SearchResult{
-			DocID:    docID,
-			URL:      url,
-			Title:    metadata.Title,
-			Score:    score,
-			Metadata: metadata,
+			for docID, positions := range postings {
+				tf := float64(len(positions))
+				dl := float64(field.docLen[docID])
+				scoreTerm := idf * (tf * (k1 + 1)) / (tf + k1*(1-b+b*(dl/avgFieldLen)))
+				scores[docID] += weight * qt.Weight * scoreTerm
+			}
 		}
-		results = append(results, result)
-
-		// Update document access time
-		idx.updateDocumentAccess(docID)
 	}
 
-	// Sort by score descending
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Score > results[j].Score
-	})
+	// Without content words to rank by, documents containing the query as a
+	// phrase come first
+	if stopwordsOnly && len(terms) > 1 {
+		_, phrase := AnalyzerFor(FieldBody).AnalyzeWithStopwords(query, languages[0])
+		for docID := range scores {
+			if body.hasPhrase(docID, phrase) {
+				scores[docID] *= 2
+			}
+		}
+	}
 
-	// Trim to topK
-	if len(results) > topK {
-		results = results[:topK]
+	// Apply the click-model boost learned from result clicks
+	if clickBoost > 0 {
+		normalized := NormalizeQuery(query)
+		for docID := range scores {
+			scores[docID] += Clicks.Boost(normalized, docID, clickBoost)
+		}
 	}
 
-	return results
+	return scores, terms
 }
 
 func (idx *Index) getDocumentMetadata(docID string) *DocumentMetadata {
@@ -322,13 +691,16 @@ func (idx *Index) updateDocumentAccess(docID string) {
 	}
 }
 
+// extractTitleFromURL makes up a title for pages without a <title>, OpenGraph
+// title or heading
 func extractTitleFromURL(url string) string {
 	if url == "" {
 		return "Untitled Document"
 	}
+	if strings.HasPrefix(url, "file://") {
+		return path.Base(url)
+	}
 
-	// Simple title extraction from URL
-	// In a real implementation, you'd parse the HTML <title> tag
 	parts := strings.Split(url, "/")
 	if len(parts) > 2 {
 		domain := parts[2]
@@ -428,6 +800,76 @@ func (idx *Index) OptimizeCache() {
 	}
 }
 
+// HasDocument reports whether a document is in the index
+func (idx *Index) HasDocument(docID string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	_, found := idx.docLen[docID]
+	return found
+}
+
+func (idx *Index) removalCount() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.removals
+}
+
+// RemoveDocument deletes a document's postings, statistics and metadata so it
+// can be dropped or indexed again. It reports whether the document was indexed.
+func (idx *Index) RemoveDocument(docID string) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.removeDocument(docID)
+}
+
+// removeDocument does the work of RemoveDocument, idx.mu must be held
+func (idx *Index) removeDocument(docID string) bool {
+	// The disk cache outlives the process, a stale entry would block re-adding
+	if idx.cache != nil {
+		idx.cache.Delete("doc:" + docID)
+	}
+
+	length, found := idx.docLen[docID]
+	if !found {
+		return false
+	}
+
+	for term, docs := range idx.index {
+		if _, ok := docs[docID]; !ok {
+			continue
+		}
+		delete(docs, docID)
+		idx.docFreq[term]--
+		if len(docs) == 0 {
+			delete(idx.index, term)
+			delete(idx.docFreq, term)
+			// Cached postings share the deleted map, a new one is made if the term returns
+			if idx.cache != nil {
+				idx.cache.Delete("term:" + term)
+			}
+		}
+	}
+	for _, field := range idx.fields {
+		field.remove(docID)
+	}
+
+	delete(idx.docLen, docID)
+	idx.docCount--
+	idx.sumDocLen -= length
+	idx.avgDL = 0
+	if idx.docCount > 0 {
+		idx.avgDL = float64(idx.sumDocLen) / float64(idx.docCount)
+	}
+	idx.removals++
+
+	idx.docMetaMutex.Lock()
+	delete(idx.docMetaCache, docID)
+	idx.docMetaMutex.Unlock()
+
+	fmt.Printf("Document %q removed from index\n", docID)
+	return true
+}
+
 // InvalidateDocument removes a document from all caches
 func (idx *Index) InvalidateDocument(docID string) {
 	// This would be called when a document is updated or deleted
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mush1e/IndexStream-v2/internal/database"
+)
+
+var (
+	ErrCrawlJobNotFound = errors.New("crawl job not found")
+	ErrCrawlJobFinished = errors.New("crawl job already finished")
+)
+
+const (
+	// crawlJobIndexWait bounds how long a finished job waits for its pages to
+	// be indexed before its counts are recorded
+	crawlJobIndexWait = time.Minute
+
+	// crawlJobCancelWait is how long cancelling a job waits for the fetches
+	// underway to stop before reporting it
+	crawlJobCancelWait = 10 * time.Second
+
+	// crawlJobSaveInterval is how often the counts of a running job are saved,
+	// so a restart loses few of them
+	crawlJobSaveInterval = 30 * time.Second
+)
+
+// runningJob is a crawl job whose outcome isn't recorded yet; recorded is
+// closed once it is
+type runningJob struct {
+	crawl    *frontierCrawl
+	recorded chan struct{}
+}
+
+var (
+	runningJobs   = make(map[int]*runningJob)
+	runningJobsMu sync.Mutex
+)
+
+// crawlJobID is the ID of a job's crawl in the frontier
+func crawlJobID(id int) string {
+	return "job-" + strconv.Itoa(id)
+}
+
+// StartCrawlJob records a crawl job for a seed URL and crawls it recursively
+// like CrawlRecursive, in the background
+func StartCrawlJob(seedURL string) (*database.CrawlJob, error) {
+	if store == nil {
+		return nil, ErrNoDatabase
+	}
+	job, err := store.CreateCrawlJob(seedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	f := StartFrontier()
+	crawl, release := f.newCrawl(crawlJobID(job.ID), seedURL)
+	if err := store.UpdateCrawlJobStatus(job.ID, "running", 0, ""); err != nil {
+		log.Printf("Error marking crawl job %d running: %v", job.ID, err)
+	}
+	now := time.Now()
+	job.Status, job.StartedAt = "running", &now
+	watchCrawlJob(job.ID, crawl)
+
+	go func() {
+		defer release()
+		queueRecursiveCrawl(f, crawl, seedURL)
+	}()
+
+	log.Printf("Started crawl job %d of %q", job.ID, seedURL)
+	return job, nil
+}
+
+// watchCrawlJob records the outcome of a job once its crawl is done
+func watchCrawlJob(id int, crawl *frontierCrawl) {
+	job := &runningJob{crawl: crawl, recorded: make(chan struct{})}
+	runningJobsMu.Lock()
+	runningJobs[id] = job
+	runningJobsMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(crawlJobSaveInterval)
+		for running := true; running; {
+			select {
+			case <-crawl.done:
+				running = false
+			case <-ticker.C:
+				saveCrawlJobCounts(id, crawl)
+			}
+		}
+		ticker.Stop()
+		// Pages fetched last may still be on their way into the index
+		if crawl.ctx.Err() == nil {
+			crawl.stats.waitIndexed(crawlJobIndexWait)
+		}
+		recordCrawlJob(id, crawl)
+
+		runningJobsMu.Lock()
+		delete(runningJobs, id)
+		runningJobsMu.Unlock()
+		close(job.recorded)
+	}()
+}
+
+// recordCrawlJob stores the status and counts of a job whose crawl is done
+func recordCrawlJob(id int, crawl *frontierCrawl) {
+	counts := saveCrawlJobCounts(id, crawl)
+	status, errorMsg := "completed", ""
+	switch {
+	case crawl.ctx.Err() != nil:
+		status = "cancelled"
+	case counts.Fetched == 0 && counts.Failed > 0:
+		status, errorMsg = "failed", "no page could be fetched"
+	}
+
+	if err := store.UpdateCrawlJobStatus(id, status, counts.Fetched, errorMsg); err != nil {
+		log.Printf("Error saving status of crawl job %d: %v", id, err)
+	}
+	log.Printf("Crawl job %d %s: %d fetched, %d failed, %d retried, %d skipped, %d indexed",
+		id, status, counts.Fetched, counts.Failed, counts.Retried, counts.Skipped, counts.Indexed)
+}
+
+// saveCrawlJobCounts stores the counts of a job's crawl so far and returns them
+func saveCrawlJobCounts(id int, crawl *frontierCrawl) *database.CrawlJob {
+	job := &database.CrawlJob{ID: id}
+	setCrawlJobCounts(job, crawl.stats.Snapshot())
+	if err := store.UpdateCrawlJobCounts(job); err != nil {
+		log.Printf("Error saving counts of crawl job %d: %v", id, err)
+	}
+	return job
+}
+
+// saveRunningCrawlJobs stores the counts of the jobs still running, so their
+// crawls go on from them after the next start
+func saveRunningCrawlJobs() {
+	runningJobsMu.Lock()
+	defer runningJobsMu.Unlock()
+	for id, running := range runningJobs {
+		saveCrawlJobCounts(id, running.crawl)
+	}
+}
+
+// setCrawlJobCounts copies the counts of a crawl to its job
+func setCrawlJobCounts(job *database.CrawlJob, counts *CrawlStats) {
+	job.Fetched, job.Failed, job.Retried, job.Indexed = counts.Fetched, counts.Failed, counts.Retried, counts.Indexed
+	job.Skipped = counts.Disallowed + counts.Unchanged
+	job.Throttled, job.Bytes, job.Waited = counts.Throttled, counts.Bytes, counts.Waited
+}
+
+// resumedCrawlStats returns the counts a job had saved when it was
+// interrupted. Skipped pages are counted as disallowed, as the two aren't
+// saved apart.
+func resumedCrawlStats(job *database.CrawlJob) *CrawlStats {
+	return &CrawlStats{
+		Fetched:    job.Fetched,
+		Failed:     job.Failed,
+		Retried:    job.Retried,
+		Disallowed: job.Skipped,
+		Indexed:    job.Indexed,
+		Throttled:  job.Throttled,
+		Bytes:      job.Bytes,
+		Waited:     job.Waited,
+	}
+}
+
+// resumeCrawlJobs watches the jobs whose crawls were resumed from the
+// frontier, counting on from their saved counts, and fails the ones that left
+// nothing in it
+func resumeCrawlJobs(f *Frontier) {
+	if store == nil {
+		return
+	}
+	jobs, err := store.GetActiveCrawlJobs()
+	if err != nil {
+		log.Printf("Error listing crawl jobs to resume: %v", err)
+		return
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		f.mu.Lock()
+		crawl, ok := f.crawls[crawlJobID(job.ID)]
+		if ok {
+			crawl.stats = resumedCrawlStats(job)
+		}
+		f.mu.Unlock()
+		if ok {
+			log.Printf("Resuming crawl job %d of %q", job.ID, job.URL)
+			watchCrawlJob(job.ID, crawl)
+			continue
+		}
+		if err := store.UpdateCrawlJobStatus(job.ID, "failed", 0, "interrupted by a restart"); err != nil {
+			log.Printf("Error saving status of crawl job %d: %v", job.ID, err)
+		}
+	}
+}
+
+// withLiveCounts fills in the counts of a job still running from its crawl; a
+// job whose fetches are being abandoned shows as cancelling
+func withLiveCounts(job *database.CrawlJob) {
+	runningJobsMu.Lock()
+	running, ok := runningJobs[job.ID]
+	runningJobsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	setCrawlJobCounts(job, running.crawl.stats.Snapshot())
+	job.PagesFound = job.Fetched
+	if running.crawl.ctx.Err() != nil {
+		job.Status = "cancelling"
+	}
+}
+
+// GetCrawlJob returns a crawl job with its counts so far
+func GetCrawlJob(id int) (*database.CrawlJob, error) {
+	if store == nil {
+		return nil, ErrNoDatabase
+	}
+	job, err := store.GetCrawlJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, ErrCrawlJobNotFound
+	}
+	withLiveCounts(job)
+	return job, nil
+}
+
+// CrawlJobs lists the most recent crawl jobs, newest first
+func CrawlJobs(limit int) ([]database.CrawlJob, error) {
+	if store == nil {
+		return nil, ErrNoDatabase
+	}
+	jobs, err := store.GetCrawlJobs(limit)
+	if err != nil {
+		return nil, err
+	}
+	if jobs == nil {
+		jobs = []database.CrawlJob{}
+	}
+	for i := range jobs {
+		withLiveCounts(&jobs[i])
+	}
+	return jobs, nil
+}
+
+// CancelCrawlJob cancels a running job, dropping its queued pages and
+// abandoning its fetches underway. Pages already fetched stay indexed.
+func CancelCrawlJob(id int) (*database.CrawlJob, error) {
+	if _, err := GetCrawlJob(id); err != nil {
+		return nil, err
+	}
+
+	runningJobsMu.Lock()
+	running, ok := runningJobs[id]
+	runningJobsMu.Unlock()
+	if !ok || !StartFrontier().Cancel(running.crawl.id) {
+		return nil, ErrCrawlJobFinished
+	}
+	log.Printf("Cancelling crawl job %d", id)
+
+	select {
+	case <-running.recorded:
+	case <-time.After(crawlJobCancelWait):
+	}
+	return GetCrawlJob(id)
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DefaultLanguage is used when a document's language can't be determined
+const DefaultLanguage = "english"
+
+// languageCodes maps ISO 639-1 codes to the Snowball language names we can stem
+var languageCodes = map[string]string{
+	"en": "english",
+	"fr": "french",
+	"es": "spanish",
+	"ru": "russian",
+	"sv": "swedish",
+	"no": "norwegian",
+	"nb": "norwegian",
+	"nn": "norwegian",
+	"hu": "hungarian",
+}
+
+// NormalizeLanguage maps a language tag ("en-US", "fr", "swedish") to a supported
+// Snowball language name, or "" if the language isn't supported
+func NormalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return ""
+	}
+
+	// Content-Language may list several languages, the first one wins
+	tag, _, _ = strings.Cut(tag, ",")
+	if name, ok := languageCodes[tag]; ok {
+		return name
+	}
+	for _, name := range languageCodes {
+		if tag == name {
+			return name
+		}
+	}
+
+	primary, _, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	return languageCodes[strings.TrimSpace(primary)]
+}
+
+// languageSamples train the trigram profiles of the detector
+var languageSamples = map[string]string{
+	"english": `All human beings are born free and equal in dignity and rights. They are endowed with
+		reason and conscience and should act towards one another in a spirit of brotherhood. Everyone
+		has the right to life, liberty and security of person. This is the page where you can find
+		the latest news about the project, with information that was written by the people who work
+		on it and which they would like to share with all of you. There is more about it in the
+		documentation, so read through the other sections when you have the time.`,
+	"french": `Tous les êtres humains naissent libres et égaux en dignité et en droits. Ils sont doués
+		de raison et de conscience et doivent agir les uns envers les autres dans un esprit de
+		fraternité. Tout individu a droit à la vie, à la liberté et à la sûreté de sa personne. Cette
+		page présente les dernières nouvelles du projet, avec des informations qui ont été écrites par
+		les personnes qui y travaillent et qu'elles souhaitent partager avec vous. Vous trouverez plus
+		de détails dans la documentation, que nous vous invitons à lire quand vous aurez le temps.`,
+	"spanish": `Todos los seres humanos nacen libres e iguales en dignidad y derechos y, dotados como
+		están de razón y conciencia, deben comportarse fraternalmente los unos con los otros. Todo
+		individuo tiene derecho a la vida, a la libertad y a la seguridad de su persona. Esta es la
+		página donde puede encontrar las últimas noticias del proyecto, con información escrita por
+		las personas que trabajan en él y que quieren compartir con todos ustedes. Hay más detalles en
+		la documentación, así que lea las otras secciones cuando tenga tiempo.`,
+	"russian": `Все люди рождаются свободными и равными в своем достоинстве и правах. Они наделены
+		разумом и совестью и должны поступать в отношении друг друга в духе братства. Каждый человек
+		имеет право на жизнь, на свободу и на личную неприкосновенность. Это страница, где вы можете
+		найти последние новости проекта, с информацией, которую написали люди, которые над ним
+		работают, и которой они хотят поделиться с вами. Больше подробностей есть в документации.`,
+	"swedish": `Alla människor är födda fria och lika i värde och rättigheter. De har utrustats med
+		förnuft och samvete och bör handla gentemot varandra i en anda av broderskap. Var och en har
+		rätt till liv, frihet och personlig säkerhet. Det här är sidan där du hittar de senaste
+		nyheterna om projektet, med information som har skrivits av de personer som arbetar med det
+		och som de vill dela med er alla. Det finns mer om det i dokumentationen, så läs de andra
+		avsnitten när du har tid.`,
+	"norwegian": `Alle mennesker er født frie og med samme menneskeverd og menneskerettigheter. De er
+		utstyrt med fornuft og samvittighet og bør handle mot hverandre i brorskapets ånd. Enhver har
+		rett til liv, frihet og personlig sikkerhet. Dette er siden hvor du finner de siste nyhetene om
+		prosjektet, med informasjon som er skrevet av de som jobber med det og som de ønsker å dele
+		med dere alle. Det står mer om dette i dokumentasjonen, så les de andre delene når du har tid.`,
+	"hungarian": `Minden emberi lény szabadon születik és egyenlő méltósága és joga van. Az emberek,
+		ésszel és lelkiismerettel bírván, egymással szemben testvéri szellemben kell hogy
+		viseltessenek. Minden személynek joga van az élethez, a szabadsághoz és a személyi
+		biztonsághoz. Ez az az oldal, ahol megtalálod a projekt legfrissebb híreit, olyan
+		információkkal, amelyeket a rajta dolgozó emberek írtak, és amelyeket szeretnének megosztani
+		veletek. A dokumentációban többet olvashatsz erről, ha lesz időd.`,
+}
+
+// detectionOrder fixes the order languages are compared in, so ties always go
+// the same way; the default language comes first
+var detectionOrder = []string{"english", "french", "spanish", "russian", "swedish", "norwegian", "hungarian"}
+
+const (
+	// trigramProfileSize is the number of ranked trigrams kept per profile
+	trigramProfileSize = 300
+
+	// minDetectionLetters is the least amount of text we try to classify
+	minDetectionLetters = 10
+)
+
+var languageProfiles = buildLanguageProfiles()
+
+func buildLanguageProfiles() map[string]map[string]int {
+	profiles := make(map[string]map[string]int, len(languageSamples))
+	for language, sample := range languageSamples {
+		profiles[language] = trigramProfile(sample)
+	}
+	return profiles
+}
+
+// trigramProfile ranks the most frequent character trigrams of text, padding words
+// with '_' so prefixes and suffixes are captured
+func trigramProfile(text string) map[string]int {
+	counts := make(map[string]int)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		runes := []rune("_" + word + "_")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+
+	grams := make([]string, 0, len(counts))
+	for gram := range counts {
+		grams = append(grams, gram)
+	}
+	sort.Slice(grams, func(i, j int) bool {
+		if counts[grams[i]] != counts[grams[j]] {
+			return counts[grams[i]] > counts[grams[j]]
+		}
+		return grams[i] < grams[j]
+	})
+	if len(grams) > trigramProfileSize {
+		grams = grams[:trigramProfileSize]
+	}
+
+	profile := make(map[string]int, len(grams))
+	for rank, gram := range grams {
+		profile[gram] = rank
+	}
+	return profile
+}
+
+// DetectLanguage guesses the language of text with a Cavnar-Trenkle trigram
+// classifier. It returns "" when there's too little text to decide.
+func DetectLanguage(text string) string {
+	letters, latin, cyrillic := 0, 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.Is(unicode.Latin, r) {
+				latin++
+			} else if unicode.Is(unicode.Cyrillic, r) {
+				cyrillic++
+			}
+		}
+	}
+	if letters < minDetectionLetters {
+		return ""
+	}
+
+	// Mostly CJK, Thai or another script we have no stemmer for
+	if (latin+cyrillic)*2 < letters {
+		return ""
+	}
+
+	// Russian is the only supported language written in Cyrillic
+	if cyrillic*2 > letters {
+		return "russian"
+	}
+
+	// Long documents don't need more than a few thousand characters to classify
+	if len(text) > 4096 {
+		text = text[:4096]
+	}
+	docProfile := trigramProfile(text)
+
+	best, bestDistance := "", -1
+	for _, language := range detectionOrder {
+		profile := languageProfiles[language]
+		distance := 0
+		for gram, rank := range docProfile {
+			if langRank, ok := profile[gram]; ok {
+				distance += abs(rank - langRank)
+			} else {
+				distance += trigramProfileSize
+			}
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = language, distance
+		}
+	}
+	return best
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
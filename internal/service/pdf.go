@@ -0,0 +1,864 @@
+package service
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfExtractor pulls text out of PDFs without external tools. It walks the
+// page tree, inflates the Flate-compressed content streams and reads their
+// text showing operators, mapping glyph codes through the ToUnicode CMap of
+// the font each string is shown in. Scanned PDFs and exotic encodings yield
+// little or no text.
+type pdfExtractor struct{}
+
+var (
+	pdfObjectStart = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfStreamStart = regexp.MustCompile(`stream\r?\n`)
+	pdfLength      = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+	pdfTitle       = regexp.MustCompile(`/Title\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+	pdfSubject     = regexp.MustCompile(`/Subject\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+	pdfLang        = regexp.MustCompile(`/Lang\s*\(([^)]*)\)`)
+)
+
+const (
+	// maxPDFStreamSize bounds how much a single stream may inflate to
+	maxPDFStreamSize = 32 << 20
+
+	// maxPDFInflatedSize bounds how much all streams of a document may inflate
+	// to together, so many small compressed streams can't exhaust memory
+	maxPDFInflatedSize = 64 << 20
+
+	// maxPDFPageDepth bounds how deeply page tree nodes may nest
+	maxPDFPageDepth = 32
+
+	// maxCMapCodes bounds how many codes a ToUnicode CMap may map, and how
+	// many mappings it may write, as each bfrange can cover 65536 codes
+	maxCMapCodes = 1 << 17
+)
+
+func (pdfExtractor) Extract(data []byte) (*ExtractedDocument, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("%PDF-")) {
+		return nil, fmt.Errorf("not a pdf")
+	}
+
+	var text strings.Builder
+	parsePDF(data).writeText(&text)
+
+	doc := &ExtractedDocument{Text: strings.TrimSpace(collapseSpaces(text.String()))}
+	if m := pdfTitle.FindSubmatch(data); m != nil {
+		doc.Title = strings.TrimSpace(pdfDecodeString(pdfStringBytes(m[1]), nil))
+	}
+	if m := pdfSubject.FindSubmatch(data); m != nil {
+		doc.Description = strings.TrimSpace(pdfDecodeString(pdfStringBytes(m[1]), nil))
+	}
+	if m := pdfLang.FindSubmatch(data); m != nil {
+		doc.Language = string(m[1])
+	}
+	if doc.Text == "" {
+		return nil, fmt.Errorf("no extractable text in pdf")
+	}
+	return doc, nil
+}
+
+// pdfObject is an indirect object: its value, the stream dictionary for
+// streams, and the raw stream data
+type pdfObject struct {
+	value  []byte
+	stream []byte
+}
+
+// pdfDocument indexes the objects of a PDF and decodes streams as they are
+// needed, all of them sharing one inflation budget
+type pdfDocument struct {
+	objects map[int]*pdfObject
+	decoded map[int][]byte
+	cmaps   map[int]map[string]string // ToUnicode CMaps by stream object
+	forms   map[int]bool              // form XObjects whose text was read
+	budget  int
+
+	soleCMap       map[string]string
+	soleCMapLooked bool
+}
+
+func parsePDF(data []byte) *pdfDocument {
+	pdf := &pdfDocument{
+		objects: make(map[int]*pdfObject),
+		decoded: make(map[int][]byte),
+		cmaps:   make(map[int]map[string]string),
+		forms:   make(map[int]bool),
+		budget:  maxPDFInflatedSize,
+	}
+
+	for offset := 0; offset < len(data); {
+		loc := pdfObjectStart.FindSubmatchIndex(data[offset:])
+		if loc == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(data[offset+loc[2] : offset+loc[3]]))
+		obj, next := readPDFObject(data, offset+loc[1])
+		pdf.objects[num] = obj
+		offset = next
+	}
+
+	// PDF 1.5 files pack most dictionaries into compressed object streams
+	for _, num := range pdf.objectNumbers() {
+		if obj := pdf.objects[num]; obj.stream != nil && pdfName(pdfDict(obj.value)["/Type"]) == "/ObjStm" {
+			pdf.unpackObjectStream(num)
+		}
+	}
+	return pdf
+}
+
+// readPDFObject reads the object whose body starts at start, returning it and
+// the offset just past it
+func readPDFObject(data []byte, start int) (*pdfObject, int) {
+	end := bytes.Index(data[start:], []byte("endobj"))
+	loc := pdfStreamStart.FindIndex(data[start:])
+	if loc == nil || (end >= 0 && end < loc[0]) {
+		if end < 0 {
+			return &pdfObject{value: data[start:]}, len(data)
+		}
+		return &pdfObject{value: data[start : start+end]}, start + end + len("endobj")
+	}
+
+	value := data[start : start+loc[0]]
+	streamStart := start + loc[1]
+
+	// Trust a direct /Length when endstream follows it, binary data may
+	// contain anything
+	if m := pdfLength.FindSubmatch(value); m != nil && len(m[2]) == 0 {
+		if n, err := strconv.Atoi(string(m[1])); err == nil && streamStart+n <= len(data) {
+			rest := bytes.TrimLeft(data[streamStart+n:], "\r\n ")
+			if bytes.HasPrefix(rest, []byte("endstream")) {
+				next := len(data) - len(rest) + len("endstream")
+				return &pdfObject{value: value, stream: data[streamStart : streamStart+n]}, next
+			}
+		}
+	}
+
+	streamEnd := bytes.Index(data[streamStart:], []byte("endstream"))
+	if streamEnd < 0 {
+		return &pdfObject{value: value, stream: data[streamStart:]}, len(data)
+	}
+	raw := bytes.TrimRight(data[streamStart:streamStart+streamEnd], "\r\n")
+	return &pdfObject{value: value, stream: raw}, streamStart + streamEnd + len("endstream")
+}
+
+// unpackObjectStream adds the objects packed into an object stream, unless
+// they are also defined directly
+func (pdf *pdfDocument) unpackObjectStream(num int) {
+	dict := pdfDict(pdf.objects[num].value)
+	count, first := pdfInt(dict["/N"]), pdfInt(dict["/First"])
+	data := pdf.stream(num)
+	if data == nil || first <= 0 || first > len(data) {
+		return
+	}
+
+	header := strings.Fields(string(data[:first]))
+	for i := 0; i+1 < len(header) && i/2 < count; i += 2 {
+		objNum, err := strconv.Atoi(header[i])
+		if err != nil {
+			continue
+		}
+		offset, err := strconv.Atoi(header[i+1])
+		if err != nil || offset < 0 || first+offset > len(data) {
+			continue
+		}
+		end := len(data)
+		if i+3 < len(header) {
+			if next, err := strconv.Atoi(header[i+3]); err == nil && next >= offset && first+next <= len(data) {
+				end = first + next
+			}
+		}
+		if pdf.objects[objNum] == nil {
+			pdf.objects[objNum] = &pdfObject{value: data[first+offset : end]}
+		}
+	}
+}
+
+func (pdf *pdfDocument) objectNumbers() []int {
+	nums := make([]int, 0, len(pdf.objects))
+	for num := range pdf.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	return nums
+}
+
+// stream returns the decoded data of an object's stream: streams without
+// filters as they are and FlateDecode streams inflated. Other filters yield
+// nil, as does everything once the document's inflation budget is spent.
+func (pdf *pdfDocument) stream(num int) []byte {
+	if decoded, ok := pdf.decoded[num]; ok {
+		return decoded
+	}
+	obj := pdf.objects[num]
+	if obj == nil || obj.stream == nil {
+		return nil
+	}
+
+	var decoded []byte
+	switch {
+	case bytes.Contains(obj.value, []byte("/FlateDecode")):
+		decoded = pdf.inflate(obj.stream)
+	case !bytes.Contains(obj.value, []byte("/Filter")):
+		decoded = obj.stream
+	}
+	pdf.decoded[num] = decoded
+	return decoded
+}
+
+func (pdf *pdfDocument) inflate(raw []byte) []byte {
+	if pdf.budget <= 0 {
+		return nil
+	}
+	r, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+
+	// Truncated streams are common, keep what inflated
+	decoded, _ := io.ReadAll(io.LimitReader(r, int64(min(maxPDFStreamSize, pdf.budget))))
+	pdf.budget -= len(decoded)
+	if len(decoded) == 0 {
+		return nil
+	}
+	return decoded
+}
+
+// resolve follows an indirect reference to the tokens of the object's value
+func (pdf *pdfDocument) resolve(value [][]byte) [][]byte {
+	num, ok := pdfRef(value)
+	if !ok {
+		return value
+	}
+	if obj := pdf.objects[num]; obj != nil {
+		return pdfTokens(obj.value)
+	}
+	return nil
+}
+
+// pdfPage is a page's content streams and the resources it uses, which may be
+// inherited from the page tree
+type pdfPage struct {
+	contents  [][]byte
+	resources [][]byte
+}
+
+// pages returns the pages in reading order by walking the page tree from the
+// catalog, or every page object in object order when there is no tree
+func (pdf *pdfDocument) pages() []pdfPage {
+	var pages []pdfPage
+	visited := make(map[int]bool)
+
+	var walk func(num int, resources [][]byte, depth int)
+	walk = func(num int, resources [][]byte, depth int) {
+		obj := pdf.objects[num]
+		if obj == nil || visited[num] || depth > maxPDFPageDepth {
+			return
+		}
+		visited[num] = true
+
+		dict := pdfDict(obj.value)
+		if own, ok := dict["/Resources"]; ok {
+			resources = own
+		}
+		kids, ok := dict["/Kids"]
+		if !ok {
+			pages = append(pages, pdfPage{contents: dict["/Contents"], resources: resources})
+			return
+		}
+		for _, kid := range pdfRefs(pdf.resolve(kids)) {
+			walk(kid, resources, depth+1)
+		}
+	}
+
+	for _, num := range pdf.objectNumbers() {
+		obj := pdf.objects[num]
+		if !bytes.Contains(obj.value, []byte("/Catalog")) {
+			continue
+		}
+		dict := pdfDict(obj.value)
+		if root, ok := pdfRef(dict["/Pages"]); ok && pdfName(dict["/Type"]) == "/Catalog" {
+			walk(root, nil, 0)
+		}
+	}
+	if len(pages) > 0 {
+		return pages
+	}
+
+	for _, num := range pdf.objectNumbers() {
+		obj := pdf.objects[num]
+		if !bytes.Contains(obj.value, []byte("/Page")) {
+			continue
+		}
+		dict := pdfDict(obj.value)
+		if pdfName(dict["/Type"]) != "/Page" {
+			continue
+		}
+
+		resources, ok := dict["/Resources"]
+		for node, depth := dict, 0; !ok && depth < maxPDFPageDepth; depth++ {
+			parent, isRef := pdfRef(node["/Parent"])
+			if !isRef || pdf.objects[parent] == nil {
+				break
+			}
+			node = pdfDict(pdf.objects[parent].value)
+			resources, ok = node["/Resources"]
+		}
+		pages = append(pages, pdfPage{contents: dict["/Contents"], resources: resources})
+	}
+	return pages
+}
+
+// writeText appends the text of every page, or of every stream that shows
+// text when the document has no pages we can find
+func (pdf *pdfDocument) writeText(text *strings.Builder) {
+	pages := pdf.pages()
+	for _, page := range pages {
+		for _, num := range pdf.contentStreams(page.contents) {
+			if content := pdf.stream(num); content != nil {
+				pdf.contentText(content, page.resources, text)
+				text.WriteString("\n")
+			}
+		}
+	}
+	if len(pages) > 0 {
+		return
+	}
+
+	for _, num := range pdf.objectNumbers() {
+		obj := pdf.objects[num]
+		if obj.stream == nil || pdf.forms[num] || bytes.Contains(obj.value, []byte("/Image")) ||
+			bytes.Contains(obj.value, []byte("/FontFile")) || bytes.Contains(obj.value, []byte("/Length1")) {
+			continue
+		}
+		content := pdf.stream(num)
+		if content == nil || bytes.Contains(content, []byte("begincmap")) || !bytes.Contains(content, []byte("BT")) {
+			continue
+		}
+		pdf.contentText(content, pdfDict(obj.value)["/Resources"], text)
+		text.WriteString("\n")
+	}
+}
+
+// contentStreams returns the objects of a page's /Contents, a single stream
+// or an array of them
+func (pdf *pdfDocument) contentStreams(contents [][]byte) []int {
+	if num, ok := pdfRef(contents); ok {
+		if obj := pdf.objects[num]; obj != nil && obj.stream != nil {
+			return []int{num}
+		}
+		contents = pdf.resolve(contents)
+	}
+	return pdfRefs(contents)
+}
+
+// fonts maps the font names of a resource dictionary to their ToUnicode CMaps.
+// Names are local to the resources: subset fonts usually number their glyphs
+// from 1, so the same code means different characters in different fonts.
+func (pdf *pdfDocument) fonts(resources [][]byte) map[string]map[string]string {
+	fonts := make(map[string]map[string]string)
+	fontDict := pdfDictTokens(pdf.resolve(pdfDictTokens(pdf.resolve(resources))["/Font"]))
+	for name, font := range fontDict {
+		fonts[name] = pdf.cmap(font)
+	}
+	return fonts
+}
+
+// cmap returns the ToUnicode CMap of a font, nil when it has none
+func (pdf *pdfDocument) cmap(font [][]byte) map[string]string {
+	num, ok := pdfRef(pdfDictTokens(pdf.resolve(font))["/ToUnicode"])
+	if !ok {
+		return nil
+	}
+	if cmap, ok := pdf.cmaps[num]; ok {
+		return cmap
+	}
+
+	var cmap map[string]string
+	if stream := pdf.stream(num); stream != nil {
+		cmap = make(map[string]string)
+		parseToUnicodeCMap(stream, cmap)
+	}
+	pdf.cmaps[num] = cmap
+	return cmap
+}
+
+// fallbackCMap is used for fonts missing from the resources: the document's
+// only ToUnicode CMap, or nil when it has none or several
+func (pdf *pdfDocument) fallbackCMap() map[string]string {
+	if pdf.soleCMapLooked {
+		return pdf.soleCMap
+	}
+	pdf.soleCMapLooked = true
+
+	var found [][]byte
+	seen := make(map[int]bool)
+	for _, num := range pdf.objectNumbers() {
+		obj := pdf.objects[num]
+		if !bytes.Contains(obj.value, []byte("/ToUnicode")) {
+			continue
+		}
+		font := pdfTokens(obj.value)
+		if ref, ok := pdfRef(pdfDictTokens(font)["/ToUnicode"]); ok && !seen[ref] {
+			seen[ref] = true
+			found = font
+		}
+	}
+	if len(seen) == 1 {
+		pdf.soleCMap = pdf.cmap(found)
+	}
+	return pdf.soleCMap
+}
+
+// contentText appends the text shown by a content stream's Tj, TJ, ' and "
+// operators, starting a new line where the text position moves. Strings are
+// decoded with the CMap of the font selected by Tf, and the text of form
+// XObjects drawn with Do is read in place.
+func (pdf *pdfDocument) contentText(content []byte, resources [][]byte, text *strings.Builder) {
+	fonts := pdf.fonts(resources)
+	cmap := pdf.fallbackCMap()
+
+	var operands [][]byte
+	for _, token := range pdfTokens(content) {
+		switch string(token) {
+		case "Tf":
+			if len(operands) >= 2 {
+				font, ok := fonts[string(operands[len(operands)-2])]
+				if !ok {
+					font = pdf.fallbackCMap()
+				}
+				cmap = font
+			}
+		case "Do":
+			if len(operands) > 0 {
+				pdf.formText(operands[len(operands)-1], resources, text)
+			}
+		case "Tj", "'", "\"":
+			if len(operands) > 0 {
+				if token[0] != 'T' {
+					text.WriteString("\n")
+				}
+				text.WriteString(pdfDecodeString(pdfStringBytes(operands[len(operands)-1]), cmap))
+			}
+		case "TJ":
+			for _, operand := range operands {
+				if len(operand) == 0 {
+					continue
+				}
+				if operand[0] == '(' || operand[0] == '<' {
+					text.WriteString(pdfDecodeString(pdfStringBytes(operand), cmap))
+				} else if n, err := strconv.ParseFloat(string(operand), 64); err == nil && n < -200 {
+					// A large negative adjustment is a word space
+					text.WriteString(" ")
+				}
+			}
+		case "Td", "TD", "T*", "Tm", "ET":
+			text.WriteString(" ")
+		}
+		if isPDFOperator(token) {
+			operands = operands[:0]
+		} else {
+			operands = append(operands, token)
+		}
+	}
+}
+
+// formText appends the text of a form XObject the first time it is drawn.
+// Forms without resources of their own use those of the stream drawing them.
+func (pdf *pdfDocument) formText(name []byte, resources [][]byte, text *strings.Builder) {
+	xobjects := pdfDictTokens(pdf.resolve(pdfDictTokens(pdf.resolve(resources))["/XObject"]))
+	num, ok := pdfRef(xobjects[string(name)])
+	if !ok || pdf.forms[num] || pdf.objects[num] == nil {
+		return
+	}
+	pdf.forms[num] = true
+
+	dict := pdfDict(pdf.objects[num].value)
+	if pdfName(dict["/Subtype"]) != "/Form" {
+		return
+	}
+	if own, ok := dict["/Resources"]; ok {
+		resources = own
+	}
+	if content := pdf.stream(num); content != nil {
+		text.WriteString("\n")
+		pdf.contentText(content, resources, text)
+		text.WriteString("\n")
+	}
+}
+
+// pdfDict parses the top-level entries of the first dictionary in data into
+// the tokens of their values
+func pdfDict(data []byte) map[string][][]byte {
+	return pdfDictTokens(pdfTokens(data))
+}
+
+func pdfDictTokens(tokens [][]byte) map[string][][]byte {
+	entries := make(map[string][][]byte)
+	i := 0
+	for i < len(tokens) && string(tokens[i]) != "<<" {
+		i++
+	}
+	for i++; i < len(tokens) && string(tokens[i]) != ">>"; {
+		key := tokens[i]
+		i++
+		if key[0] != '/' {
+			continue
+		}
+		n := pdfValueLen(tokens[i:])
+		entries[string(key)] = tokens[i : i+n]
+		i += n
+	}
+	return entries
+}
+
+// pdfValueLen returns how many tokens the value at the start of tokens spans:
+// a whole dictionary or array, an indirect reference or a single token
+func pdfValueLen(tokens [][]byte) int {
+	if len(tokens) == 0 {
+		return 0
+	}
+	switch string(tokens[0]) {
+	case "<<", "[":
+		depth := 0
+		for i, token := range tokens {
+			switch string(token) {
+			case "<<", "[":
+				depth++
+			case ">>", "]":
+				depth--
+			}
+			if depth == 0 {
+				return i + 1
+			}
+		}
+		return len(tokens)
+	}
+	if len(tokens) >= 3 && string(tokens[2]) == "R" && isPDFInt(tokens[0]) && isPDFInt(tokens[1]) {
+		return 3
+	}
+	return 1
+}
+
+// pdfRef returns the object number of an indirect reference value
+func pdfRef(value [][]byte) (int, bool) {
+	if len(value) != 3 || string(value[2]) != "R" {
+		return 0, false
+	}
+	num, err := strconv.Atoi(string(value[0]))
+	return num, err == nil
+}
+
+// pdfRefs returns the object numbers of all indirect references among tokens
+func pdfRefs(tokens [][]byte) []int {
+	var nums []int
+	for i := 0; i+2 < len(tokens); i++ {
+		if num, ok := pdfRef(tokens[i : i+3]); ok && isPDFInt(tokens[i+1]) {
+			nums = append(nums, num)
+			i += 2
+		}
+	}
+	return nums
+}
+
+func pdfName(value [][]byte) string {
+	if len(value) != 1 {
+		return ""
+	}
+	return string(value[0])
+}
+
+func pdfInt(value [][]byte) int {
+	if len(value) != 1 {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(value[0]))
+	return n
+}
+
+func isPDFInt(token []byte) bool {
+	if len(token) == 0 {
+		return false
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseToUnicodeCMap reads the bfchar and bfrange sections of a ToUnicode CMap,
+// stopping after maxCMapCodes mappings
+func parseToUnicodeCMap(stream []byte, cmap map[string]string) {
+	written := 0
+	set := func(code []byte, text string) bool {
+		cmap[string(code)] = text
+		written++
+		return written < maxCMapCodes && len(cmap) < maxCMapCodes
+	}
+
+	tokens := pdfTokens(stream)
+	for i := 0; i < len(tokens); i++ {
+		switch string(tokens[i]) {
+		case "beginbfchar":
+			for i++; i+1 < len(tokens) && string(tokens[i]) != "endbfchar"; i += 2 {
+				if !set(pdfHexBytes(tokens[i]), utf16Hex(tokens[i+1])) {
+					return
+				}
+			}
+		case "beginbfrange":
+			for i++; i+2 < len(tokens) && string(tokens[i]) != "endbfrange"; i += 3 {
+				lo, hi := pdfHexBytes(tokens[i]), pdfHexBytes(tokens[i+1])
+				if len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+					continue
+				}
+				from, to := bytesToInt(lo), bytesToInt(hi)
+				if to < from || to-from > 0xFFFF {
+					continue
+				}
+
+				if bytes.Equal(tokens[i+2], []byte("[")) {
+					// [<dst1> <dst2> ...] lists each destination
+					j := i + 3
+					for code := from; code <= to && j < len(tokens) && string(tokens[j]) != "]"; code, j = code+1, j+1 {
+						if !set(intToBytes(code, len(lo)), utf16Hex(tokens[j])) {
+							return
+						}
+					}
+					for j < len(tokens) && string(tokens[j]) != "]" {
+						j++
+					}
+					i = j - 2
+					continue
+				}
+
+				dst := []rune(utf16Hex(tokens[i+2]))
+				if len(dst) == 0 {
+					continue
+				}
+				for code := from; code <= to; code++ {
+					mapped := append([]rune{}, dst...)
+					mapped[len(mapped)-1] += rune(code - from)
+					if !set(intToBytes(code, len(lo)), string(mapped)) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+func isPDFOperator(token []byte) bool {
+	if len(token) == 0 {
+		return false
+	}
+	c := token[0]
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '\'' || c == '"' || c == '*'
+}
+
+// pdfTokens splits PDF syntax into strings, hex strings, names, numbers,
+// operators and array brackets. Dictionaries are returned as their delimiters.
+func pdfTokens(data []byte) [][]byte {
+	var tokens [][]byte
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0:
+			i++
+		case c == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			start, depth := i, 0
+			for ; i < len(data); i++ {
+				if data[i] == '\\' {
+					i++
+					continue
+				}
+				if data[i] == '(' {
+					depth++
+				} else if data[i] == ')' {
+					depth--
+					if depth == 0 {
+						i++
+						break
+					}
+				}
+			}
+			tokens = append(tokens, data[start:min(i, len(data))])
+		case c == '<' && i+1 < len(data) && data[i+1] == '<', c == '>' && i+1 < len(data) && data[i+1] == '>':
+			tokens = append(tokens, data[i:i+2])
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				return tokens
+			}
+			tokens = append(tokens, data[i:i+end+1])
+			i += end + 1
+		case c == '[' || c == ']' || c == '{' || c == '}':
+			tokens = append(tokens, data[i:i+1])
+			i++
+		default:
+			start := i
+			for i++; i < len(data) && !bytes.ContainsRune([]byte(" \n\r\t\f\x00()<>[]{}/%"), rune(data[i])); i++ {
+			}
+			tokens = append(tokens, data[start:i])
+		}
+	}
+	return tokens
+}
+
+// pdfStringBytes decodes a (literal) or <hex> string token to its bytes
+func pdfStringBytes(token []byte) []byte {
+	if len(token) >= 2 && token[0] == '<' {
+		return pdfHexBytes(token)
+	}
+	if len(token) < 2 || token[0] != '(' {
+		return nil
+	}
+
+	body := token[1 : len(token)-1]
+	out := make([]byte, 0, len(body))
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' || i+1 == len(body) {
+			out = append(out, body[i])
+			continue
+		}
+		i++
+		switch c := body[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '\r', '\n':
+			// Line continuation
+			if c == '\r' && i+1 < len(body) && body[i+1] == '\n' {
+				i++
+			}
+		default:
+			if c >= '0' && c <= '7' {
+				n, j := 0, i
+				for ; j < len(body) && j < i+3 && body[j] >= '0' && body[j] <= '7'; j++ {
+					n = n*8 + int(body[j]-'0')
+				}
+				out = append(out, byte(n))
+				i = j - 1
+			} else {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+func pdfHexBytes(token []byte) []byte {
+	hex := make([]byte, 0, len(token))
+	for _, c := range bytes.Trim(token, "<>") {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			hex = append(hex, c)
+		}
+	}
+	if len(hex)%2 == 1 {
+		hex = append(hex, '0')
+	}
+
+	out := make([]byte, len(hex)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(string(hex[2*i:2*i+2]), 16, 8)
+		out[i] = byte(v)
+	}
+	return out
+}
+
+// pdfDecodeString maps glyph codes through the CMap, trying two-byte codes
+// before single bytes. Without a mapping, UTF-16 strings marked by a BOM are
+// decoded as such and anything else is read as Latin-1.
+func pdfDecodeString(raw []byte, cmap map[string]string) string {
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		return utf16BE(raw[2:])
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(raw); {
+		if len(cmap) > 0 {
+			if i+1 < len(raw) {
+				if s, ok := cmap[string(raw[i:i+2])]; ok {
+					out.WriteString(s)
+					i += 2
+					continue
+				}
+			}
+			if s, ok := cmap[string(raw[i:i+1])]; ok {
+				out.WriteString(s)
+				i++
+				continue
+			}
+		}
+		if raw[i] >= 0x20 || raw[i] == '\n' || raw[i] == '\t' {
+			out.WriteRune(rune(raw[i]))
+		}
+		i++
+	}
+	return out.String()
+}
+
+func utf16Hex(token []byte) string {
+	return utf16BE(pdfHexBytes(token))
+}
+
+func utf16BE(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+func bytesToInt(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+func intToBytes(n, size int) []byte {
+	out := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		out[i] = byte(n)
+		n >>= 8
+	}
+	return out
+}
+
+// collapseSpaces squeezes runs of spaces and tabs left by text positioning
+func collapseSpaces(s string) string {
+	lines := strings.Split(s, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
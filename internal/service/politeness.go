@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxThrottleRetries is how many times a request answered with 429 or 503
+	// is tried again
+	maxThrottleRetries = 2
+
+	// maxHostBackoff caps how long a host that keeps throttling us is left
+	// alone, and how long a request waits for its host before giving up
+	maxHostBackoff = 10 * time.Minute
+
+	// hostLimiterPruneInterval is how often the limiters of idle hosts are
+	// dropped
+	hostLimiterPruneInterval = time.Minute
+
+	// hostBusyRecheck is how soon a host whose slots are all taken by requests
+	// the frontier didn't hand out is looked at again
+	hostBusyRecheck = time.Second
+)
+
+// hostLimiter spaces out and bounds the concurrent requests to one host. Its
+// delay grows each time the host throttles us and shrinks back as requests
+// succeed.
+type hostLimiter struct {
+	slots chan struct{}
+	users int // callers holding the limiter, guarded by hostLimitersMu
+
+	mu      sync.Mutex
+	next    time.Time     // earliest start of the next request
+	penalty time.Duration // added to the delay after 429 and 503 responses
+}
+
+var (
+	hostLimiters       = make(map[string]*hostLimiter)
+	hostLimitersMu     sync.Mutex
+	hostLimitersPruned time.Time
+)
+
+// hostLimiterFor returns the limiter of a host, which the caller hands back
+// with putHostLimiter once done with it
+func hostLimiterFor(host string) *hostLimiter {
+	hostLimitersMu.Lock()
+	defer hostLimitersMu.Unlock()
+	if time.Since(hostLimitersPruned) > hostLimiterPruneInterval {
+		pruneHostLimiters()
+	}
+	h, ok := hostLimiters[host]
+	if !ok {
+		h = &hostLimiter{slots: make(chan struct{}, max(cfg.CrawlHostConcurrency, 1))}
+		hostLimiters[host] = h
+	}
+	h.users++
+	return h
+}
+
+func putHostLimiter(h *hostLimiter) {
+	hostLimitersMu.Lock()
+	h.users--
+	hostLimitersMu.Unlock()
+}
+
+// pruneHostLimiters drops the limiters nobody holds whose host we're free to
+// request again without delay, which a new limiter would do just the same. It
+// must be called with hostLimitersMu held.
+func pruneHostLimiters() {
+	now := time.Now()
+	for host, h := range hostLimiters {
+		h.mu.Lock()
+		idle := h.users == 0 && h.penalty == 0 && !h.next.After(now)
+		h.mu.Unlock()
+		if idle {
+			delete(hostLimiters, host)
+		}
+	}
+	hostLimitersPruned = now
+}
+
+// acquire waits for a free slot and for the host's turn, and returns how long
+// it waited. delay is the spacing the host asked for, which may exceed ours.
+func (h *hostLimiter) acquire(ctx context.Context, delay time.Duration) (time.Duration, error) {
+	start := time.Now()
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		return time.Since(start), ctx.Err()
+	}
+
+	h.mu.Lock()
+	now := time.Now()
+	turn := h.next
+	if turn.Before(now) {
+		turn = now
+	}
+	if turn.Sub(now) > maxHostBackoff {
+		h.mu.Unlock()
+		<-h.slots
+		return time.Since(start), fmt.Errorf("host is backing us off until %s", turn.Format(time.RFC3339))
+	}
+	h.next = turn.Add(max(delay, cfg.CrawlHostDelay) + h.penalty)
+	h.mu.Unlock()
+
+	if err := sleepContext(ctx, turn.Sub(now)); err != nil {
+		<-h.slots
+		return time.Since(start), err
+	}
+	return time.Since(start), nil
+}
+
+// hostLimiterReadyAt returns when a request to a host could start without
+// waiting for its limiter
+func hostLimiterReadyAt(host string) time.Time {
+	hostLimitersMu.Lock()
+	h, ok := hostLimiters[host]
+	hostLimitersMu.Unlock()
+	if !ok {
+		return time.Time{}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.slots) == cap(h.slots) {
+		return time.Now().Add(hostBusyRecheck)
+	}
+	return h.next
+}
+
+func (h *hostLimiter) release() {
+	<-h.slots
+}
+
+// throttled slows the host down after a 429 or 503 response, waiting at least
+// as long as its Retry-After asked
+func (h *hostLimiter) throttled(retryAfter time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.penalty = min(max(2*h.penalty, time.Second), maxHostBackoff)
+	resume := time.Now().Add(max(retryAfter, h.penalty))
+	if resume.After(h.next) {
+		h.next = resume
+	}
+}
+
+// succeeded halves the slowdown left from earlier throttling
+func (h *hostLimiter) succeeded() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.penalty /= 2; h.penalty < 100*time.Millisecond {
+		h.penalty = 0
+	}
+}
+
+// HostPoliteness is the throttling state of a host
+type HostPoliteness struct {
+	Host     string `json:"host"`
+	InFlight int    `json:"in_flight"`
+	Penalty  int64  `json:"penalty_ms"`
+	NextAt   string `json:"next_at,omitempty"`
+}
+
+// HostsPoliteness reports the hosts the crawler has slowed down for, most
+// penalized first
+func HostsPoliteness() []HostPoliteness {
+	hostLimitersMu.Lock()
+	defer hostLimitersMu.Unlock()
+
+	hosts := []HostPoliteness{}
+	now := time.Now()
+	for host, h := range hostLimiters {
+		h.mu.Lock()
+		state := HostPoliteness{Host: host, InFlight: len(h.slots), Penalty: h.penalty.Milliseconds()}
+		if h.next.After(now) {
+			state.NextAt = h.next.Format(time.RFC3339)
+		}
+		h.mu.Unlock()
+		if state.InFlight > 0 || state.Penalty > 0 || state.NextAt != "" {
+			hosts = append(hosts, state)
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Penalty > hosts[j].Penalty })
+	return hosts
+}
+
+// crawlRate holds the earliest start of the crawler's next request and the
+// earliest time more body bytes may be read, when global limits are set
+var (
+	crawlRateMu     sync.Mutex
+	nextRequestAt   time.Time
+	nextBandwidthAt time.Time
+)
+
+// waitRequestRate waits for the crawler-wide requests per second limit
+func waitRequestRate(ctx context.Context) (time.Duration, error) {
+	if cfg.CrawlMaxRPS <= 0 {
+		return 0, nil
+	}
+	crawlRateMu.Lock()
+	now := time.Now()
+	turn := nextRequestAt
+	if turn.Before(now) {
+		turn = now
+	}
+	nextRequestAt = turn.Add(time.Duration(float64(time.Second) / cfg.CrawlMaxRPS))
+	crawlRateMu.Unlock()
+
+	return turn.Sub(now), sleepContext(ctx, turn.Sub(now))
+}
+
+// sleepContext sleeps for d, or until ctx is done and returns its error
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bandwidthReader reads a response body within the crawler-wide bandwidth limit
+type bandwidthReader struct {
+	r io.Reader
+}
+
+func (b bandwidthReader) Read(p []byte) (int, error) {
+	if cfg.CrawlMaxBytesPerSec <= 0 {
+		return b.r.Read(p)
+	}
+	n, err := b.r.Read(p[:min(len(p), 32<<10)])
+
+	crawlRateMu.Lock()
+	now := time.Now()
+	// A second's worth of bytes may be read in a burst after an idle spell
+	if nextBandwidthAt.Before(now.Add(-time.Second)) {
+		nextBandwidthAt = now.Add(-time.Second)
+	}
+	nextBandwidthAt = nextBandwidthAt.Add(time.Duration(float64(n) / float64(cfg.CrawlMaxBytesPerSec) * float64(time.Second)))
+	wait := nextBandwidthAt.Sub(now)
+	crawlRateMu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as a date
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// politeGet fetches a URL once its host and the crawler's global limits allow
+// another request, retrying after the host throttles us. The body of a 200
+// response is read and returned; the response body is always closed. Waiting
+// and the request are abandoned once ctx is done.
+func politeGet(ctx context.Context, rawURL string, crawlDelay time.Duration, stats *CrawlStats) (*http.Response, []byte, error) {
+	return politeFetch(ctx, rawURL, nil, 0, crawlDelay, stats)
+}
+
+// politeFetch is politeGet sending extra headers, such as the validators of a
+// conditional GET, and reading at most limit bytes of the body when limit is
+// positive
+func politeFetch(ctx context.Context, rawURL string, header http.Header, limit int64, crawlDelay time.Duration, stats *CrawlStats) (*http.Response, []byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	host := hostLimiterFor(u.Host)
+	defer putHostLimiter(host)
+
+	for attempt := 0; ; attempt++ {
+		waited, err := host.acquire(ctx, crawlDelay)
+		if err != nil {
+			stats.addWait(waited)
+			return nil, nil, err
+		}
+		rateWaited, err := waitRequestRate(ctx)
+		stats.addWait(waited + rateWaited)
+		if err != nil {
+			host.release()
+			return nil, nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			host.release()
+			return nil, nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := crawlClient.Do(req)
+		if err != nil {
+			host.release()
+			return nil, nil, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			resp.Body.Close()
+			host.throttled(parseRetryAfter(resp.Header.Get("Retry-After")))
+			host.release()
+			stats.addThrottled()
+			if attempt < maxThrottleRetries {
+				continue
+			}
+			return resp, nil, nil
+		}
+
+		var body []byte
+		if resp.StatusCode == http.StatusOK {
+			var r io.Reader = bandwidthReader{resp.Body}
+			if limit > 0 {
+				r = io.LimitReader(r, limit)
+			}
+			body, err = io.ReadAll(r)
+		}
+		resp.Body.Close()
+		host.succeeded()
+		host.release()
+		stats.addBytes(len(body))
+		return resp, body, err
+	}
+}
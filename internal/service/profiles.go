@@ -0,0 +1,301 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProfileName is the profile used when no experiment applies
+const DefaultProfileName = "default"
+
+// RankingProfile is a named ranking configuration: scorer parameters, score
+// boosts and an ordered list of rerankers applied to the BM25 ranking
+type RankingProfile struct {
+	Name       string     `json:"name"`
+	BM25       BM25Params `json:"bm25"`
+	ClickBoost float64    `json:"click_boost"`
+	ExactBoost float64    `json:"exact_boost"`
+	Rerankers  []string   `json:"rerankers,omitempty"`
+
+	// Per-field score weights overriding the defaults, 0 disables a field
+	FieldWeights map[string]float64 `json:"field_weights,omitempty"`
+}
+
+// fieldWeight returns the weight of a secondary field, safe on a nil profile
+func (p *RankingProfile) fieldWeight(field string) float64 {
+	if p != nil {
+		if weight, ok := p.FieldWeights[field]; ok {
+			return weight
+		}
+	}
+	if weight, ok := defaultFieldWeights[field]; ok {
+		return weight
+	}
+	return 1
+}
+
+// SearchRequest describes a query against the index
+type SearchRequest struct {
+	Query    string
+	TopK     int
+	Profile  *RankingProfile // nil ranks with the index defaults
+	Language string          // language of the query, detected when empty
+
+	// Filters; empty values match every document
+	ContentType     string
+	Author          string    // one of the document's authors, case insensitive
+	PublishedAfter  time.Time // inclusive; documents without a date never match
+	PublishedBefore time.Time // exclusive
+}
+
+// normalized maps the language and content type to the names the index uses
+func (req SearchRequest) normalized() SearchRequest {
+	req.Language = NormalizeLanguage(req.Language)
+	if contentType := NormalizeContentType(req.ContentType); contentType != "" {
+		req.ContentType = contentType
+	}
+	return req
+}
+
+// matches applies the request's filters to a document
+func (req SearchRequest) matches(metadata *DocumentMetadata) bool {
+	if req.ContentType != "" && metadata.ContentType != req.ContentType {
+		return false
+	}
+	if req.Author != "" && !containsFold(metadata.Authors, req.Author) {
+		return false
+	}
+	if !req.PublishedAfter.IsZero() || !req.PublishedBefore.IsZero() {
+		published := metadata.Published
+		if published == nil ||
+			(!req.PublishedAfter.IsZero() && published.Before(req.PublishedAfter)) ||
+			(!req.PublishedBefore.IsZero() && !published.Before(req.PublishedBefore)) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, want string) bool {
+	want = strings.TrimSpace(want)
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheKey identifies the request in the query result cache
+func (req SearchRequest) cacheKey() string {
+	profile := DefaultProfileName
+	if req.Profile != nil {
+		profile = req.Profile.Name
+	}
+	return fmt.Sprintf("%s|k=%d|profile=%s|lang=%s|type=%s|author=%s|after=%s|before=%s",
+		req.Query, req.TopK, profile, req.Language, req.ContentType, strings.ToLower(req.Author),
+		formatFilterTime(req.PublishedAfter), formatFilterTime(req.PublishedBefore))
+}
+
+func formatFilterTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// queryLanguages returns the languages the query is analyzed in. An explicit
+// language is used alone; a detected one is paired with the default language,
+// since short queries are easily misdetected.
+func (req SearchRequest) queryLanguages() []string {
+	if req.Language != "" {
+		return []string{req.Language}
+	}
+
+	detected := DetectLanguage(req.Query)
+	if detected == "" || detected == DefaultLanguage {
+		return []string{DefaultLanguage}
+	}
+	return []string{detected, DefaultLanguage}
+}
+
+// analyzeQuery analyzes a query for a field once per language, first language first
+func analyzeQuery(field, query string, languages []string) []string {
+	return queryTermsPerLanguage(languages, func(language string) []Token {
+		return AnalyzerFor(field).AnalyzeLang(query, language)
+	})
+}
+
+// analyzeQueryStopwords returns the query terms the body analyzer removes as
+// stopwords, in each of the query languages
+func analyzeQueryStopwords(query string, languages []string) []string {
+	return queryTermsPerLanguage(languages, func(language string) []Token {
+		_, stopped := AnalyzerFor(FieldBody).AnalyzeWithStopwords(query, language)
+		return stopped
+	})
+}
+
+func queryTermsPerLanguage(languages []string, analyze func(language string) []Token) []string {
+	if len(languages) == 1 {
+		return tokenTerms(analyze(languages[0]))
+	}
+
+	var terms []string
+	seen := make(map[string]bool)
+	for _, language := range languages {
+		for _, token := range analyze(language) {
+			if !seen[token.Term] {
+				seen[token.Term] = true
+				terms = append(terms, token.Term)
+			}
+		}
+	}
+	return terms
+}
+
+// Reranker adjusts result scores in place; it runs with the index read lock held
+type Reranker func(idx *Index, profile *RankingProfile, terms []string, results []SearchResult)
+
+var rerankers = map[string]Reranker{
+	"exact_match": rerankExactMatch,
+	"shallow_url": rerankShallowURL,
+}
+
+// rerank applies the profile's rerankers in order and re-sorts the results
+func (idx *Index) rerank(profile *RankingProfile, terms []string, results []SearchResult) {
+	for _, name := range profile.Rerankers {
+		if reranker, ok := rerankers[name]; ok {
+			reranker(idx, profile, terms, results)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+}
+
+// rerankExactMatch boosts documents containing every query term by the profile's exact boost
+func rerankExactMatch(idx *Index, profile *RankingProfile, terms []string, results []SearchResult) {
+	if profile.ExactBoost <= 0 || len(terms) <= 1 {
+		return
+	}
+
+	for i := range results {
+		matchesAll := true
+		for _, term := range terms {
+			if _, found := idx.index[term][results[i].DocID]; !found {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			results[i].Score *= profile.ExactBoost
+		}
+	}
+}
+
+// rerankShallowURL slightly prefers documents closer to the root of their site
+func rerankShallowURL(idx *Index, profile *RankingProfile, terms []string, results []SearchResult) {
+	for i := range results {
+		u, err := url.Parse(results[i].URL)
+		if err != nil {
+			continue
+		}
+		depth := len(strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' }))
+		results[i].Score /= 1 + 0.1*math.Log1p(float64(depth))
+	}
+}
+
+var (
+	profiles   = map[string]*RankingProfile{}
+	profilesMu sync.RWMutex
+)
+
+// rankingConfig is the on-disk format of RANKING_PROFILES
+type rankingConfig struct {
+	Profiles    []*RankingProfile `json:"profiles"`
+	Experiments []*Experiment     `json:"experiments"`
+}
+
+// LoadRankingConfig reads ranking profiles and experiments from a JSON file
+func LoadRankingConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading ranking config: %w", err)
+	}
+
+	var rc rankingConfig
+	if err := json.Unmarshal(data, &rc); err != nil {
+		return fmt.Errorf("parsing ranking config: %w", err)
+	}
+
+	loaded := map[string]*RankingProfile{}
+	for _, p := range rc.Profiles {
+		if p.Name == "" || p.Name == DefaultProfileName {
+			return fmt.Errorf("profile name %q is empty or reserved", p.Name)
+		}
+		if p.BM25 == (BM25Params{}) {
+			p.BM25 = DefaultBM25Params()
+		}
+		for _, name := range p.Rerankers {
+			if _, ok := rerankers[name]; !ok {
+				return fmt.Errorf("profile %q: unknown reranker %q", p.Name, name)
+			}
+		}
+		loaded[p.Name] = p
+	}
+
+	// Nothing is applied until the experiments are known to be valid
+	// against the new profiles
+	if err := validateExperiments(rc.Experiments); err != nil {
+		return err
+	}
+	for _, exp := range rc.Experiments {
+		for _, name := range []string{exp.Control, exp.Treatment} {
+			if _, ok := loaded[name]; !ok && name != DefaultProfileName {
+				return fmt.Errorf("experiment %q: unknown profile %q", exp.Name, name)
+			}
+		}
+	}
+
+	profilesMu.Lock()
+	profiles = loaded
+	profilesMu.Unlock()
+
+	return Experiments.Set(rc.Experiments)
+}
+
+// GetProfile returns a ranking profile by name, or nil if it doesn't exist
+func GetProfile(name string) *RankingProfile {
+	if name == DefaultProfileName {
+		return &RankingProfile{
+			Name:       DefaultProfileName,
+			BM25:       InvertedIndex.BM25Params(),
+			ClickBoost: cfg.ClickBoost,
+		}
+	}
+
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	return profiles[name]
+}
+
+// ListProfiles returns all ranking profiles including the default one
+func ListProfiles() []*RankingProfile {
+	profilesMu.RLock()
+	list := make([]*RankingProfile, 0, len(profiles)+1)
+	for _, p := range profiles {
+		list = append(list, p)
+	}
+	profilesMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return append([]*RankingProfile{GetProfile(DefaultProfileName)}, list...)
+}
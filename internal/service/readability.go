@@ -0,0 +1,215 @@
+package service
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// skippedElements never contain readable text
+var skippedElements = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Math:     true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Canvas:   true,
+}
+
+// boilerplateElements hold navigation and page chrome rather than content
+var boilerplateElements = map[atom.Atom]bool{
+	atom.Nav:    true,
+	atom.Header: true,
+	atom.Footer: true,
+	atom.Aside:  true,
+	atom.Form:   true,
+	atom.Menu:   true,
+}
+
+var boilerplateRoles = map[string]bool{
+	"navigation":    true,
+	"banner":        true,
+	"contentinfo":   true,
+	"complementary": true,
+	"search":        true,
+	"menu":          true,
+	"menubar":       true,
+	"dialog":        true,
+	"alertdialog":   true,
+}
+
+// boilerplateTokens are the classes and ids of typical page chrome. They're
+// matched against whole tokens, so wrappers like "has-sidebar" or
+// "header-content" aren't mistaken for chrome.
+var boilerplateTokens = map[string]bool{
+	"nav": true, "navbar": true, "navigation": true, "menu": true, "site-nav": true, "main-nav": true,
+	"header": true, "site-header": true, "page-header": true,
+	"footer": true, "site-footer": true, "page-footer": true,
+	"sidebar": true, "breadcrumb": true, "breadcrumbs": true,
+	"cookie": true, "cookies": true, "cookie-banner": true, "cookie-notice": true, "consent": true, "gdpr": true,
+	"banner": true, "popup": true, "modal": true, "newsletter": true, "subscribe": true,
+	"share": true, "sharing": true, "share-buttons": true, "social": true, "social-links": true,
+	"related": true, "related-posts": true, "advert": true, "advertisement": true, "ad": true, "ads": true,
+	"sponsor": true, "sponsored": true, "comment": true, "comments": true, "pagination": true, "skip-link": true,
+}
+
+// blockElements delimit the blocks whose link density is measured
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true,
+	atom.Main: true, atom.Li: true, atom.Ul: true, atom.Ol: true, atom.Td: true,
+	atom.Th: true, atom.Table: true, atom.Blockquote: true, atom.Pre: true,
+	atom.Dd: true, atom.Dt: true, atom.Figure: true, atom.Body: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+const (
+	// maxLinkDensity is the share of link text above which a block is navigation
+	maxLinkDensity = 0.5
+
+	// minContentChars is the least main content we trust; below it all
+	// readable text is treated as content
+	minContentChars = 200
+)
+
+// textStats counts the text under an element and how much of it is link text
+type textStats struct {
+	text, linkText int
+}
+
+// extractContent splits the readable text of a page into its main content and
+// boilerplate, readability style. Scripts, styles and similar elements are
+// dropped. If the page marks its content with <main>, role="main" or <article>,
+// everything outside it is boilerplate. Navigation elements, chrome recognized
+// by class or id, and link-heavy blocks are boilerplate wherever they appear.
+func extractContent(root *html.Node) (content, boilerplate string) {
+	stats := make(map[*html.Node]textStats)
+	measureText(root, false, stats)
+
+	mainRoot := findMainContent(root, stats)
+
+	var contentText, boilerplateText, allText strings.Builder
+	var walk func(node *html.Node, inMain, chrome bool, block *html.Node)
+	walk = func(node *html.Node, inMain, chrome bool, block *html.Node) {
+		switch node.Type {
+		case html.ElementNode:
+			if skippedElements[node.DataAtom] {
+				return
+			}
+			if node == mainRoot {
+				inMain = true
+			}
+			// An article's own header holds its title and byline
+			if isBoilerplateElement(node) && !(inMain && node.DataAtom == atom.Header) {
+				chrome = true
+			}
+			if blockElements[node.DataAtom] {
+				block = node
+			}
+		case html.TextNode:
+			text := strings.TrimSpace(node.Data)
+			if text == "" {
+				return
+			}
+			allText.WriteString(text)
+			allText.WriteString(" ")
+
+			isContent := (mainRoot == nil || inMain) && !chrome && !linkHeavy(stats[block])
+			if isContent {
+				contentText.WriteString(text)
+				contentText.WriteString(" ")
+			} else {
+				boilerplateText.WriteString(text)
+				boilerplateText.WriteString(" ")
+			}
+			return
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child, inMain, chrome, block)
+		}
+	}
+	walk(root, false, false, nil)
+
+	content = strings.TrimSpace(contentText.String())
+	boilerplate = strings.TrimSpace(boilerplateText.String())
+
+	// Rather index a page whole than keep almost nothing of it
+	if len(content) < minContentChars && len(boilerplate) > len(content) {
+		return strings.TrimSpace(allText.String()), ""
+	}
+	return content, boilerplate
+}
+
+// measureText fills stats for every element under node and returns node's totals
+func measureText(node *html.Node, inLink bool, stats map[*html.Node]textStats) textStats {
+	if node.Type == html.TextNode {
+		n := len(strings.TrimSpace(node.Data))
+		if inLink {
+			return textStats{text: n, linkText: n}
+		}
+		return textStats{text: n}
+	}
+	if node.Type == html.ElementNode && skippedElements[node.DataAtom] {
+		return textStats{}
+	}
+
+	inLink = inLink || (node.Type == html.ElementNode && node.DataAtom == atom.A)
+	var total textStats
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		childStats := measureText(child, inLink, stats)
+		total.text += childStats.text
+		total.linkText += childStats.linkText
+	}
+	if node.Type == html.ElementNode {
+		stats[node] = total
+	}
+	return total
+}
+
+// findMainContent returns the element the page marks as its main content: the
+// first <main> or role="main", otherwise the <article> with the most text
+func findMainContent(root *html.Node, stats map[*html.Node]textStats) *html.Node {
+	var main, article *html.Node
+	traverseDOMTree(root, func(node *html.Node) {
+		if node.Type != html.ElementNode {
+			return
+		}
+		if main == nil && (node.DataAtom == atom.Main || attrValue(node, "role") == "main") {
+			main = node
+		}
+		if node.DataAtom == atom.Article && (article == nil || stats[node].text > stats[article].text) {
+			article = node
+		}
+	})
+
+	if main != nil {
+		return main
+	}
+	return article
+}
+
+func isBoilerplateElement(node *html.Node) bool {
+	if boilerplateElements[node.DataAtom] || boilerplateRoles[attrValue(node, "role")] {
+		return true
+	}
+	if node.DataAtom == atom.Body || node.DataAtom == atom.Main || node.DataAtom == atom.Article {
+		return false
+	}
+	for _, token := range strings.Fields(attrValue(node, "class") + " " + attrValue(node, "id")) {
+		if boilerplateTokens[strings.ToLower(token)] {
+			return true
+		}
+	}
+	return false
+}
+
+// linkHeavy reports whether a block is mostly link text, like a menu or a list
+// of related articles
+func linkHeavy(s textStats) bool {
+	return s.text > 0 && float64(s.linkText)/float64(s.text) > maxLinkDensity
+}
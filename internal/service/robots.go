@@ -0,0 +1,299 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDisallowed is returned for URLs the site's robots.txt keeps us out of
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+const (
+	// maxRobotsBytes is how much of a robots.txt file is read, as RFC 9309 allows
+	maxRobotsBytes = 500 << 10
+
+	// robotsRetryTTL is how long a robots.txt that couldn't be fetched is
+	// treated as disallowing everything before it's tried again
+	robotsRetryTTL = 10 * time.Minute
+
+	// maxCrawlDelay caps the Crawl-delay a site can ask for
+	maxCrawlDelay = time.Minute
+)
+
+// crawlClient sends every request of the crawler with the configured user agent
+var crawlClient = &http.Client{Transport: userAgentTransport{http.DefaultTransport}}
+
+type userAgentTransport struct {
+	base http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", cfg.UserAgent)
+	return t.base.RoundTrip(req)
+}
+
+// robotsRule is an Allow or Disallow line of a robots.txt group
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// RobotsRules are the parts of a robots.txt file that apply to our crawler
+type RobotsRules struct {
+	rules      []robotsRule
+	CrawlDelay time.Duration
+	Sitemaps   []string
+}
+
+// allowAll and disallowAll stand in when robots.txt is missing or unreachable
+var (
+	allowAll    = &RobotsRules{}
+	disallowAll = &RobotsRules{rules: []robotsRule{{allow: false, pattern: "/"}}}
+)
+
+// robotsProductToken is the name our crawler matches robots.txt groups by,
+// the user agent up to its version
+func robotsProductToken() string {
+	token, _, _ := strings.Cut(cfg.UserAgent, "/")
+	return strings.ToLower(strings.TrimSpace(token))
+}
+
+// ParseRobots reads the rules of the groups matching the product token, or of
+// the * groups when none does. Matching groups are merged.
+func ParseRobots(r io.Reader, productToken string) *RobotsRules {
+	type group struct {
+		agents     []string
+		rules      []robotsRule
+		crawlDelay time.Duration
+	}
+	var groups []*group
+	var current *group
+	inAgents := false
+	rules := &RobotsRules{}
+
+	scanner := bufio.NewScanner(io.LimitReader(r, maxRobotsBytes))
+	// A single line may take up the whole file, like a long list of sitemaps
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRobotsBytes)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share the group that follows them
+			if !inAgents {
+				current = &group{}
+				groups = append(groups, current)
+				inAgents = true
+			}
+			agent, _, _ := strings.Cut(value, "/")
+			current.agents = append(current.agents, strings.ToLower(strings.TrimSpace(agent)))
+			continue
+		case "allow", "disallow":
+			if current != nil && value != "" {
+				current.rules = append(current.rules, robotsRule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			if seconds, err := strconv.ParseFloat(value, 64); current != nil && err == nil && seconds > 0 {
+				current.crawlDelay = min(time.Duration(seconds*float64(time.Second)), maxCrawlDelay)
+			}
+		case "sitemap":
+			rules.Sitemaps = append(rules.Sitemaps, value)
+		}
+		inAgents = false
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading robots.txt, keeping the rules before the error: %v", err)
+	}
+
+	for _, agent := range []string{productToken, "*"} {
+		matched := false
+		for _, g := range groups {
+			for _, a := range g.agents {
+				if a == agent {
+					rules.rules = append(rules.rules, g.rules...)
+					rules.CrawlDelay = max(rules.CrawlDelay, g.crawlDelay)
+					matched = true
+					break
+				}
+			}
+		}
+		if matched {
+			break
+		}
+	}
+	return rules
+}
+
+// Allowed reports whether a URL may be fetched. The longest matching rule
+// wins and Allow wins a tie; URLs no rule matches are allowed.
+func (r *RobotsRules) Allowed(u *url.URL) bool {
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+	if target == "/robots.txt" {
+		return true
+	}
+
+	allowed, longest := true, -1
+	for _, rule := range r.rules {
+		if !robotsPatternMatch(rule.pattern, target) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allowed, longest = rule.allow, len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// robotsPatternMatch matches a path against a rule, where * matches any run of
+// characters and a trailing $ anchors the rule at the end of the path
+func robotsPatternMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	last := len(parts) - 1
+	if last == 0 {
+		return !anchored || pos == len(path)
+	}
+
+	// Taking the leftmost match of each middle part leaves the most room for
+	// the parts after it
+	for _, part := range parts[1:last] {
+		i := strings.Index(path[pos:], part)
+		if i < 0 {
+			return false
+		}
+		pos += i + len(part)
+	}
+
+	if anchored {
+		return len(path)-pos >= len(parts[last]) && strings.HasSuffix(path, parts[last])
+	}
+	return strings.Contains(path[pos:], parts[last])
+}
+
+// robotsEntry caches the rules of one site; ready is closed once they're fetched
+type robotsEntry struct {
+	ready   chan struct{}
+	rules   *RobotsRules
+	expires time.Time
+}
+
+var (
+	robotsCache   = make(map[string]*robotsEntry)
+	robotsCacheMu sync.Mutex
+)
+
+// robotsFor returns the rules for a site, fetching its robots.txt when it isn't
+// cached or has expired. Concurrent callers wait for the same fetch.
+func robotsFor(u *url.URL) *RobotsRules {
+	site := u.Scheme + "://" + u.Host
+
+	robotsCacheMu.Lock()
+	entry, ok := robotsCache[site]
+	if ok {
+		select {
+		case <-entry.ready:
+			if time.Now().Before(entry.expires) {
+				robotsCacheMu.Unlock()
+				return entry.rules
+			}
+		default:
+			robotsCacheMu.Unlock()
+			<-entry.ready
+			return entry.rules
+		}
+	}
+	previous := entry
+	entry = &robotsEntry{ready: make(chan struct{})}
+	robotsCache[site] = entry
+	robotsCacheMu.Unlock()
+
+	rules, ttl := fetchRobots(site)
+	if rules == nil {
+		// An unreachable robots.txt keeps the last rules we had, or shuts the
+		// site out until it can be read
+		rules = disallowAll
+		if previous != nil {
+			rules = previous.rules
+		}
+	}
+	entry.rules, entry.expires = rules, time.Now().Add(ttl)
+	close(entry.ready)
+	return rules
+}
+
+// fetchRobots downloads and parses a site's robots.txt. It returns nil rules
+// when the file is unreachable, along with how long the result may be cached.
+func fetchRobots(site string) (*RobotsRules, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The site's Crawl-delay isn't known yet, so ours spaces this request out
+	resp, body, err := politeFetch(ctx, site+"/robots.txt", nil, maxRobotsBytes, 0, nil)
+	if err != nil {
+		log.Printf("Failed to fetch robots.txt of %s: %v", site, err)
+		return nil, robotsRetryTTL
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return ParseRobots(bytes.NewReader(body), robotsProductToken()), cfg.RobotsCacheTTL
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		log.Printf("robots.txt of %s unavailable: %s", site, resp.Status)
+		return nil, robotsRetryTTL
+	default:
+		// Any other client error means the site has no rules for crawlers
+		return allowAll, cfg.RobotsCacheTTL
+	}
+}
+
+// crawlDelayFor returns the Crawl-delay the site of a URL asks for
+func crawlDelayFor(rawURL string) time.Duration {
+	u, err := url.Parse(rawURL)
+	if err != nil || !isValidHTTPURL(u) {
+		return 0
+	}
+	return robotsFor(u).CrawlDelay
+}
+
+// checkRobots returns the rules for a URL's site, or ErrDisallowed when they
+// keep us out of the URL
+func checkRobots(rawURL string) (*RobotsRules, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !isValidHTTPURL(u) {
+		return allowAll, nil
+	}
+	rules := robotsFor(u)
+	if !rules.Allowed(u) {
+		return nil, ErrDisallowed
+	}
+	return rules, nil
+}
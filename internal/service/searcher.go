@@ -20,6 +20,13 @@ func NewSearchEngine() *SearchEngine {
 	}
 }
 
+// NewSearchEngineWithIndex creates a search engine over a specific index
+func NewSearchEngineWithIndex(idx *Index) *SearchEngine {
+	return &SearchEngine{
+		index: idx,
+	}
+}
+
 // SearchOptions provides configuration for search operations
 type SearchOptions struct {
 	MaxResults    int
@@ -57,8 +64,12 @@ func (se *SearchEngine) PerformSearch(query string, options SearchOptions) ([]En
 	se.mu.RLock()
 	defer se.mu.RUnlock()
 
-	// Tokenize and prepare search terms
+	// Tokenize and prepare search terms; a query of only stopwords still
+	// matches the stopwords removed from documents
 	terms := Tokenize(query)
+	if len(terms) == 0 {
+		terms = analyzeQueryStopwords(query, []string{DefaultLanguage})
+	}
 	if len(terms) == 0 {
 		return []EnhancedSearchResult{}, fmt.Errorf("no valid search terms found")
 	}
@@ -118,11 +129,16 @@ func (se *SearchEngine) PerformSearch(query string, options SearchOptions) ([]En
 
 // findMatchedTerms identifies which search terms were found in a document
 func (se *SearchEngine) findMatchedTerms(docID string, searchTerms []string) []string {
+	se.index.mu.RLock()
+	defer se.index.mu.RUnlock()
+
 	var matched []string
 
 	for _, term := range searchTerms {
-		if postings, exists := se.index.index[term]; exists {
-			if _, found := postings[docID]; found {
+		if _, found := se.index.index[term][docID]; found {
+			matched = append(matched, term)
+		} else if stopwords := se.index.fields[FieldStopwords]; stopwords != nil {
+			if _, found := stopwords.postings[term][docID]; found {
 				matched = append(matched, term)
 			}
 		}
@@ -131,32 +147,30 @@ func (se *SearchEngine) findMatchedTerms(docID string, searchTerms []string) []s
 	return matched
 }
 
-// hasExactMatch checks if the document contains the exact query phrase
+// hasExactMatch checks if the document contains the query as a phrase, with
+// removed stopwords leaving the same gaps in both
 func (se *SearchEngine) hasExactMatch(docID, query string) bool {
-	// This is a simplified implementation
-	// In a real system, you'd want to check for exact phrase matches
-	queryTerms := Tokenize(query)
-	if len(queryTerms) <= 1 {
-		return false
-	}
+	tokens, stopped := AnalyzerFor(FieldBody).AnalyzeWithStopwords(query, "")
 
-	// Check if all consecutive terms appear in the document
-	foundCount := 0
-	for _, term := range queryTerms {
-		if postings, exists := se.index.index[term]; exists {
-			if _, found := postings[docID]; found {
-				foundCount++
-			}
-		}
+	se.index.mu.RLock()
+	defer se.index.mu.RUnlock()
+	field := se.index.bodyField()
+	if len(tokens) == 0 {
+		tokens, field = stopped, se.index.fields[FieldStopwords]
+	}
+	if len(tokens) <= 1 || field == nil {
+		return false
 	}
 
-	return foundCount == len(queryTerms)
+	return field.hasPhrase(docID, tokens)
 }
 
 // GetDocumentStats returns statistics about a specific document
 func (se *SearchEngine) GetDocumentStats(docID string) map[string]interface{} {
 	se.mu.RLock()
 	defer se.mu.RUnlock()
+	se.index.mu.RLock()
+	defer se.index.mu.RUnlock()
 
 	stats := make(map[string]interface{})
 
@@ -189,6 +203,8 @@ func (se *SearchEngine) GetDocumentStats(docID string) map[string]interface{} {
 func (se *SearchEngine) GetIndexStats() map[string]interface{} {
 	se.mu.RLock()
 	defer se.mu.RUnlock()
+	se.index.mu.RLock()
+	defer se.index.mu.RUnlock()
 
 	stats := map[string]interface{}{
 		"total_documents":    se.index.docCount,
@@ -216,6 +232,8 @@ func (se *SearchEngine) SuggestSimilar(partialQuery string, maxSuggestions int)
 
 	var suggestions []string
 
+	se.index.mu.RLock()
+	defer se.index.mu.RUnlock()
 	for term := range se.index.index {
 		if strings.HasPrefix(term, partialQuery) && term != partialQuery {
 			suggestions = append(suggestions, term)
@@ -0,0 +1,115 @@
+package service
+
+import "unicode"
+
+// scriptClass groups scripts by how their words are delimited
+type scriptClass int
+
+const (
+	// Scripts that separate words with spaces (Latin, Cyrillic, Greek...)
+	scriptSpaced scriptClass = iota
+	// Han, Hiragana, Katakana and Hangul are indexed as overlapping bigrams
+	scriptCJK
+	// Thai, Lao, Khmer and Myanmar don't mark word boundaries either, we index
+	// overlapping bigrams of character clusters instead of using a dictionary
+	scriptSoutheastAsian
+)
+
+func runeScript(r rune) scriptClass {
+	switch {
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		return scriptCJK
+	// The prolonged sound mark and iteration marks are Common but belong to Japanese words
+	case r == 'ー' || r == '々' || r == 'ゝ' || r == 'ゞ' || r == 'ヽ' || r == 'ヾ':
+		return scriptCJK
+	case unicode.In(r, unicode.Thai, unicode.Lao, unicode.Khmer, unicode.Myanmar):
+		return scriptSoutheastAsian
+	}
+	return scriptSpaced
+}
+
+// isWordRune reports whether r can be part of a word. Combining marks count, as
+// Thai vowels and Devanagari matras would otherwise split words apart.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// segmentWords turns whitespace or punctuation delimited words into positioned
+// tokens. Words mixing scripts are split at script boundaries, and runs of
+// scripts written without spaces are broken into overlapping bigrams, each at
+// its own position, so "東京都" becomes 東京, 京都.
+func segmentWords(words []string) []Token {
+	tokens := make([]Token, 0, len(words))
+	for _, word := range words {
+		for _, run := range scriptRuns(word) {
+			for _, term := range segmentRun(run) {
+				tokens = append(tokens, Token{Term: term, Position: len(tokens)})
+			}
+		}
+	}
+	return tokens
+}
+
+type scriptRun struct {
+	text  []rune
+	class scriptClass
+}
+
+// scriptRuns splits a word wherever the script class changes. Combining marks
+// stay with the rune they modify.
+func scriptRuns(word string) []scriptRun {
+	var runs []scriptRun
+	for _, r := range word {
+		class := runeScript(r)
+		if n := len(runs); n > 0 && (unicode.IsMark(r) || runs[n-1].class == class) {
+			runs[n-1].text = append(runs[n-1].text, r)
+			continue
+		}
+		runs = append(runs, scriptRun{text: []rune{r}, class: class})
+	}
+	return runs
+}
+
+func segmentRun(run scriptRun) []string {
+	switch run.class {
+	case scriptCJK:
+		return bigrams(runeUnits(run.text))
+	case scriptSoutheastAsian:
+		return bigrams(clusterUnits(run.text))
+	}
+	return []string{string(run.text)}
+}
+
+func runeUnits(runes []rune) []string {
+	units := make([]string, len(runes))
+	for i, r := range runes {
+		units[i] = string(r)
+	}
+	return units
+}
+
+// clusterUnits groups each base character with the marks that follow it, so
+// bigrams never separate a Thai consonant from its vowel or tone mark
+func clusterUnits(runes []rune) []string {
+	var units []string
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i == len(runes) || !unicode.IsMark(runes[i]) {
+			units = append(units, string(runes[start:i]))
+			start = i
+		}
+	}
+	return units
+}
+
+// bigrams joins adjacent units; a lone unit is kept as a unigram
+func bigrams(units []string) []string {
+	if len(units) < 2 {
+		return units
+	}
+	grams := make([]string, 0, len(units)-1)
+	for i := 0; i+1 < len(units); i++ {
+		grams = append(grams, units[i]+units[i+1])
+	}
+	return grams
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SitemapEntry is a page listed in a sitemap
+type SitemapEntry struct {
+	Loc      string     `json:"loc"`
+	LastMod  *time.Time `json:"lastmod,omitempty"`
+	Priority float64    `json:"priority"`
+}
+
+// SitemapStats counts what a sitemap crawl did
+type SitemapStats struct {
+	Sitemaps   int `json:"sitemaps"`
+	Listed     int `json:"listed"`
+	Crawled    int `json:"crawled"`
+	Unchanged  int `json:"unchanged"` // indexed after their <lastmod>
+	Skipped    int `json:"skipped"`   // queued or crawled recently by another crawl
+	Failed     int `json:"failed"`
+	Disallowed int `json:"disallowed"` // kept out by robots.txt
+}
+
+const (
+	// defaultSitemapPriority is the priority of entries without <priority>
+	defaultSitemapPriority = 0.5
+
+	// maxSitemapFiles bounds how many sitemaps a sitemap index can pull in
+	maxSitemapFiles = 100
+
+	// maxSitemapBytes is the uncompressed size limit of the sitemap protocol
+	maxSitemapBytes = 50 << 20
+)
+
+// sitemapXML matches both <urlset> sitemaps and <sitemapindex> files
+type sitemapXML struct {
+	URLs     []sitemapURLXML `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+type sitemapURLXML struct {
+	Loc      string `xml:"loc"`
+	LastMod  string `xml:"lastmod"`
+	Priority string `xml:"priority"`
+}
+
+// DiscoverSitemaps returns the sitemaps of a page's site: those listed in
+// robots.txt, and /sitemap.xml
+func DiscoverSitemaps(pageURL string) []string {
+	u, err := url.Parse(pageURL)
+	if err != nil || !isValidHTTPURL(u) {
+		return nil
+	}
+	root := &url.URL{Scheme: u.Scheme, Host: u.Host}
+	robotsURL := root.ResolveReference(&url.URL{Path: "/robots.txt"}).String()
+
+	var sitemaps []string
+	for _, s := range robotsFor(u).Sitemaps {
+		if loc := preprocessRawURL(s, robotsURL); loc != "" {
+			sitemaps = append(sitemaps, loc)
+		}
+	}
+	defaultSitemap := root.ResolveReference(&url.URL{Path: "/sitemap.xml"}).String()
+	for _, s := range sitemaps {
+		if s == defaultSitemap {
+			return sitemaps
+		}
+	}
+	return append(sitemaps, defaultSitemap)
+}
+
+// FetchSitemaps reads the sitemaps of a site, following sitemap index files,
+// and returns up to maxURLs of the pages they list, highest priority and most
+// recently modified first. Pages on another host than site are dropped, as
+// the sitemap protocol requires; the sitemaps themselves may be elsewhere,
+// since a site's robots.txt may list sitemaps on other hosts. It stops with
+// ctx's error once ctx is done.
+func FetchSitemaps(ctx context.Context, site string, sitemapURLs []string, maxURLs int) ([]SitemapEntry, int, error) {
+	queue := append([]string{}, sitemapURLs...)
+	seenSitemaps := make(map[string]bool)
+	seenPages := make(map[string]bool)
+	var entries []SitemapEntry
+	var lastErr error
+	fetched := 0
+
+	for len(queue) > 0 && fetched < maxSitemapFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, fetched, err
+		}
+		sitemapURL := queue[0]
+		queue = queue[1:]
+		if seenSitemaps[sitemapURL] {
+			continue
+		}
+		seenSitemaps[sitemapURL] = true
+
+		doc, err := fetchSitemap(ctx, sitemapURL)
+		if err != nil {
+			log.Printf("Skipping sitemap %s: %v", sitemapURL, err)
+			lastErr = err
+			continue
+		}
+		fetched++
+
+		for _, child := range doc.Sitemaps {
+			if loc := preprocessRawURL(strings.TrimSpace(child.Loc), sitemapURL); loc != "" {
+				queue = append(queue, loc)
+			}
+		}
+		for _, u := range doc.URLs {
+			loc := preprocessRawURL(strings.TrimSpace(u.Loc), sitemapURL)
+			if loc == "" || seenPages[loc] || !sameHost(loc, site) {
+				continue
+			}
+			seenPages[loc] = true
+
+			priority, err := strconv.ParseFloat(strings.TrimSpace(u.Priority), 64)
+			if err != nil || priority < 0 || priority > 1 {
+				priority = defaultSitemapPriority
+			}
+			entries = append(entries, SitemapEntry{
+				Loc:      loc,
+				LastMod:  parseStructuredDate(u.LastMod),
+				Priority: priority,
+			})
+		}
+	}
+
+	if fetched == 0 && lastErr != nil {
+		return nil, 0, lastErr
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if a.LastMod != nil && b.LastMod != nil {
+			return a.LastMod.After(*b.LastMod)
+		}
+		return a.LastMod != nil
+	})
+	if maxURLs > 0 && len(entries) > maxURLs {
+		entries = entries[:maxURLs]
+	}
+	return entries, fetched, nil
+}
+
+// fetchSitemap downloads and parses one sitemap, which may be gzipped or a
+// plain text list of URLs
+func fetchSitemap(ctx context.Context, sitemapURL string) (*sitemapXML, error) {
+	resp, data, err := politeFetch(ctx, sitemapURL, nil, maxSitemapBytes, crawlDelayFor(sitemapURL), nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("opening gzip sitemap: %w", err)
+		}
+		data, err = io.ReadAll(io.LimitReader(gz, maxSitemapBytes))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing sitemap: %w", err)
+		}
+	}
+
+	// A byte order mark would hide the opening < of an XML sitemap
+	data = bytes.TrimPrefix(data, utf8BOM)
+
+	doc := &sitemapXML{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '<' {
+		// Text sitemaps list one absolute URL per line; anything else is
+		// not a page
+		for _, line := range strings.Split(string(trimmed), "\n") {
+			u, err := url.Parse(strings.TrimSpace(line))
+			if err == nil && u.IsAbs() && isValidHTTPURL(u) {
+				doc.URLs = append(doc.URLs, sitemapURLXML{Loc: u.String()})
+			}
+		}
+		return doc, nil
+	}
+
+	if err := newXMLDecoder(data).Decode(doc); err != nil {
+		return nil, fmt.Errorf("parsing sitemap: %w", err)
+	}
+	return doc, nil
+}
+
+func sameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// sitemapEntryChanged reports whether a page may have changed since it was
+// indexed, going by its <lastmod>
+func sitemapEntryChanged(entry SitemapEntry) bool {
+	docID := documentID(entry.Loc)
+	if entry.LastMod == nil || !InvertedIndex.HasDocument(docID) {
+		return true
+	}
+	return InvertedIndex.getDocumentMetadata(docID).IndexedAt.Before(*entry.LastMod)
+}
+
+// queueSitemapEntries adds the pages that may have changed since they were
+// indexed to a crawl, without following their links. It returns how many were
+// queued, and how many were left out as unchanged.
+func queueSitemapEntries(f *Frontier, crawl string, entries []SitemapEntry, maxDepth int) (queued, unchanged int) {
+	for _, entry := range entries {
+		if !sitemapEntryChanged(entry) {
+			unchanged++
+			continue
+		}
+
+		// A page modified since it was indexed is fetched again even if it
+		// was crawled recently
+		requeue := entry.LastMod != nil && InvertedIndex.HasDocument(documentID(entry.Loc))
+		if f.Add(&FrontierEntry{
+			URL:      entry.Loc,
+			Crawl:    crawl,
+			Depth:    max(maxDepth-1, 0),
+			MaxDepth: maxDepth,
+			Priority: entry.Priority,
+		}, requeue) {
+			queued++
+		}
+	}
+	return queued, unchanged
+}
+
+// CrawlSitemap crawls the pages listed in a sitemap or sitemap index
+func CrawlSitemap(sitemapURL string) (*SitemapStats, error) {
+	log.Printf("Starting sitemap crawl of %q", sitemapURL)
+
+	entries, fetched, err := FetchSitemaps(context.Background(), sitemapURL, []string{sitemapURL}, cfg.SitemapMaxURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	f := StartFrontier()
+	crawl, release := f.newCrawl("", sitemapURL)
+	queued, unchanged := queueSitemapEntries(f, crawl.id, entries, 1)
+	release()
+	<-crawl.done
+
+	crawled := crawl.stats.Snapshot()
+	stats := &SitemapStats{
+		Sitemaps:   fetched,
+		Listed:     len(entries),
+		Crawled:    crawled.Fetched,
+		Unchanged:  unchanged,
+		Skipped:    len(entries) - unchanged - queued,
+		Failed:     crawled.Failed,
+		Disallowed: crawled.Disallowed,
+	}
+
+	log.Printf("Finished sitemap crawl of %q: %d of %d pages crawled, %d unchanged, %d skipped, %d failed, %d disallowed",
+		sitemapURL, stats.Crawled, stats.Listed, stats.Unchanged, stats.Skipped, stats.Failed, stats.Disallowed)
+	return stats, nil
+}
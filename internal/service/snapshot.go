@@ -0,0 +1,219 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// snapshotLogName is the file in the data directory that lists the URL and
+// response details of every stored document, one JSON object per line, so a
+// snapshot can be reindexed as it was fetched. Later lines win.
+const snapshotLogName = "documents.log"
+
+// snapshotCompactLines is the least number of lines a log is compacted at
+const snapshotCompactLines = 10000
+
+var snapshotLogMu sync.Mutex
+
+// snapshotLogSizes tracks the lines of each directory's log and how many it
+// had after its last compaction, to compact it once it has doubled. Guarded
+// by snapshotLogMu.
+var snapshotLogSizes = make(map[string]*snapshotLogSize)
+
+type snapshotLogSize struct {
+	lines     int
+	compacted int
+}
+
+// snapshotRecord is one line of the snapshot log
+type snapshotRecord struct {
+	DocID string `json:"doc_id"`
+	URL   string `json:"url,omitempty"`
+	FetchInfo
+}
+
+func appendSnapshotLog(dir string, record snapshotRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	snapshotLogMu.Lock()
+	defer snapshotLogMu.Unlock()
+
+	path := filepath.Join(dir, snapshotLogName)
+	size, ok := snapshotLogSizes[dir]
+	if !ok {
+		lines := countLines(path)
+		size = &snapshotLogSize{lines: lines, compacted: lines}
+		snapshotLogSizes[dir] = size
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if size.lines++; size.lines > snapshotCompactLines && size.lines > 2*size.compacted {
+		if err := compactSnapshotLog(dir); err != nil {
+			log.Printf("Error compacting %s: %v", snapshotLogName, err)
+		}
+	}
+	return nil
+}
+
+// countLines counts the lines of a file, 0 if it can't be read
+func countLines(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	lines := 0
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		if err != nil {
+			return lines
+		}
+	}
+}
+
+// readSnapshotLog returns the latest record of each document in a log along
+// with the number of lines read
+func readSnapshotLog(path string) (map[string]snapshotRecord, int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	// Lines have no length limit: a pushed document's fields alone may take
+	// megabytes, and one long line mustn't lose the rest of the log
+	records := make(map[string]snapshotRecord)
+	r := bufio.NewReader(f)
+	line := 0
+	for {
+		data, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			line++
+			var record snapshotRecord
+			if jsonErr := json.Unmarshal(data, &record); jsonErr != nil || record.DocID == "" {
+				log.Printf("Skipping line %d of %s: malformed record", line, snapshotLogName)
+			} else {
+				records[record.DocID] = record
+			}
+		}
+		if err == io.EOF {
+			return records, line, nil
+		}
+		if err != nil {
+			return nil, line, fmt.Errorf("reading %s: %w", snapshotLogName, err)
+		}
+	}
+}
+
+// compactSnapshotLog rewrites a log with only the latest record of each
+// document still stored in dir. snapshotLogMu must be held.
+func compactSnapshotLog(dir string) error {
+	path := filepath.Join(dir, snapshotLogName)
+	records, _, err := readSnapshotLog(path)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if NormalizeContentType(strings.TrimPrefix(filepath.Ext(name), ".")) != "" {
+			stored[strings.TrimSuffix(name, filepath.Ext(name))] = true
+		}
+	}
+
+	docIDs := make([]string, 0, len(records))
+	for docID := range records {
+		if stored[docID] {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	sort.Strings(docIDs)
+
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	for _, docID := range docIDs {
+		enc.Encode(records[docID])
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	snapshotLogSizes[dir] = &snapshotLogSize{lines: len(docIDs), compacted: len(docIDs)}
+	return nil
+}
+
+// restoreSnapshotLog gives the documents stored in dir back the URLs and
+// response details recorded when they were stored
+func restoreSnapshotLog(dir string) error {
+	snapshotLogMu.Lock()
+	defer snapshotLogMu.Unlock()
+
+	records, lines, err := readSnapshotLog(filepath.Join(dir, snapshotLogName))
+	if err != nil {
+		return err
+	}
+
+	docURLMu.Lock()
+	for _, record := range records {
+		if record.URL != "" {
+			DocURLMap[record.DocID] = record.URL
+		}
+		info := record.FetchInfo
+		docFetchInfo[record.DocID] = &info
+	}
+	docURLMu.Unlock()
+
+	// Dropping superseded lines leaves the next load one line per document
+	if lines > len(records) {
+		if err := compactSnapshotLog(dir); err != nil {
+			log.Printf("Error compacting %s: %v", snapshotLogName, err)
+		}
+	}
+	return nil
+}
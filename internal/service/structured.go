@@ -0,0 +1,409 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// StructuredData is the metadata a page publishes about itself as schema.org
+// JSON-LD or microdata and as OpenGraph or Twitter card tags
+type StructuredData struct {
+	Type        string            `json:"type,omitempty"` // schema.org type of the main entity, e.g. "NewsArticle"
+	Name        string            `json:"name,omitempty"` // its headline or name
+	Description string            `json:"description,omitempty"`
+	Authors     []string          `json:"authors,omitempty"`
+	Publisher   string            `json:"publisher,omitempty"`
+	Published   *time.Time        `json:"published,omitempty"`
+	Modified    *time.Time        `json:"modified,omitempty"`
+	Image       string            `json:"image,omitempty"`
+	Breadcrumbs []string          `json:"breadcrumbs,omitempty"`
+	OpenGraph   map[string]string `json:"open_graph,omitempty"` // og:, article: and twitter: tags as published
+}
+
+// mainEntityTypes rank schema.org types by how well they describe a whole page
+var mainEntityTypes = []string{
+	"Article", "NewsArticle", "BlogPosting", "TechArticle", "ScholarlyArticle", "Report",
+	"Product", "Recipe", "Event", "Course", "Book", "Movie", "JobPosting", "QAPage",
+	"FAQPage", "HowTo", "WebPage", "AboutPage", "CollectionPage",
+}
+
+// parseStructuredData reads JSON-LD, microdata and OpenGraph tags from a page.
+// JSON-LD wins over microdata, which wins over OpenGraph.
+func parseStructuredData(root *html.Node) StructuredData {
+	var sd StructuredData
+	var jsonLD []map[string]interface{}
+	openGraph := make(map[string]string)
+
+	traverseDOMTree(root, func(node *html.Node) {
+		if node.Type != html.ElementNode {
+			return
+		}
+		switch node.DataAtom {
+		case atom.Script:
+			if strings.EqualFold(strings.TrimSpace(attrValue(node, "type")), "application/ld+json") && node.FirstChild != nil {
+				var value interface{}
+				if err := json.Unmarshal([]byte(node.FirstChild.Data), &value); err == nil {
+					collectJSONLDNodes(value, &jsonLD)
+				}
+			}
+		case atom.Meta:
+			key := attrValue(node, "property")
+			if key == "" {
+				key = attrValue(node, "name")
+			}
+			key = strings.ToLower(strings.TrimSpace(key))
+			if strings.HasPrefix(key, "og:") || strings.HasPrefix(key, "article:") || strings.HasPrefix(key, "twitter:") {
+				if _, seen := openGraph[key]; !seen {
+					openGraph[key] = strings.TrimSpace(attrValue(node, "content"))
+				}
+			}
+		}
+	})
+
+	applyJSONLD(&sd, jsonLD)
+	if item := mainMicrodataItem(microdataItems(root)); item != nil {
+		applyMicrodata(&sd, item)
+	}
+	applyOpenGraph(&sd, openGraph)
+	if len(openGraph) > 0 {
+		sd.OpenGraph = openGraph
+	}
+	return sd
+}
+
+// collectJSONLDNodes flattens arrays and @graph containers into entity nodes
+func collectJSONLDNodes(value interface{}, nodes *[]map[string]interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			collectJSONLDNodes(item, nodes)
+		}
+	case map[string]interface{}:
+		if graph, ok := v["@graph"]; ok {
+			collectJSONLDNodes(graph, nodes)
+			return
+		}
+		*nodes = append(*nodes, v)
+	}
+}
+
+func jsonLDTypes(node map[string]interface{}) []string {
+	return jsonLDStrings(node["@type"])
+}
+
+// jsonLDStrings reads a value that may be a string, an object with a name or
+// url, or an array of those
+func jsonLDStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if s := strings.TrimSpace(v); s != "" {
+			return []string{s}
+		}
+	case map[string]interface{}:
+		for _, key := range []string{"name", "url", "@id"} {
+			if s, ok := v[key].(string); ok && strings.TrimSpace(s) != "" {
+				return []string{strings.TrimSpace(s)}
+			}
+		}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			values = append(values, jsonLDStrings(item)...)
+		}
+		return values
+	}
+	return nil
+}
+
+func jsonLDString(node map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if values := jsonLDStrings(node[key]); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// mainEntityRank orders entities by mainEntityTypes. Entities of other types,
+// like a site-wide Organization or WebSite block, rank len(mainEntityTypes) and
+// are never taken for the page's main entity.
+func mainEntityRank(types []string) int {
+	best := len(mainEntityTypes)
+	for _, t := range types {
+		for rank, main := range mainEntityTypes {
+			if strings.EqualFold(t, main) && rank < best {
+				best = rank
+			}
+		}
+	}
+	return best
+}
+
+func applyJSONLD(sd *StructuredData, nodes []map[string]interface{}) {
+	var main map[string]interface{}
+	mainRank := len(mainEntityTypes)
+	for _, node := range nodes {
+		types := jsonLDTypes(node)
+		if hasType(types, "BreadcrumbList") {
+			sd.Breadcrumbs = jsonLDBreadcrumbs(node)
+			continue
+		}
+		if rank := mainEntityRank(types); rank < mainRank {
+			main, mainRank = node, rank
+		}
+	}
+	if main == nil {
+		return
+	}
+
+	if types := jsonLDTypes(main); len(types) > 0 {
+		sd.Type = types[0]
+	}
+	sd.Name = jsonLDString(main, "headline", "name")
+	sd.Description = jsonLDString(main, "description")
+	sd.Authors = jsonLDStrings(main["author"])
+	sd.Publisher = jsonLDString(main, "publisher")
+	sd.Published = parseStructuredDate(jsonLDString(main, "datePublished", "dateCreated", "startDate"))
+	sd.Modified = parseStructuredDate(jsonLDString(main, "dateModified"))
+	sd.Image = jsonLDString(main, "image", "thumbnailUrl")
+}
+
+func jsonLDBreadcrumbs(node map[string]interface{}) []string {
+	items, _ := node["itemListElement"].([]interface{})
+	var crumbs []string
+	for _, item := range items {
+		element, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name := jsonLDString(element, "name")
+		if name == "" {
+			if inner, ok := element["item"].(map[string]interface{}); ok {
+				name = jsonLDString(inner, "name")
+			}
+		}
+		if name != "" {
+			crumbs = append(crumbs, name)
+		}
+	}
+	return crumbs
+}
+
+func hasType(types []string, want string) bool {
+	for _, t := range types {
+		if strings.EqualFold(t, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// microItem is a microdata itemscope with its properties; nested items are
+// also recorded as the value of the property that holds them
+type microItem struct {
+	Type     string
+	Props    map[string][]string
+	Children map[string][]*microItem
+}
+
+func newMicroItem(node *html.Node) *microItem {
+	itemType := attrValue(node, "itemtype")
+	if i := strings.LastIndexAny(itemType, "/#"); i >= 0 {
+		itemType = itemType[i+1:]
+	}
+	return &microItem{
+		Type:     strings.TrimSpace(itemType),
+		Props:    make(map[string][]string),
+		Children: make(map[string][]*microItem),
+	}
+}
+
+// microdataItems returns the top-level microdata items of a page
+func microdataItems(root *html.Node) []*microItem {
+	var items []*microItem
+	var walk func(node *html.Node, item *microItem)
+	walk = func(node *html.Node, item *microItem) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			_, scoped := attrLookup(child, "itemscope")
+			props := strings.Fields(attrValue(child, "itemprop"))
+
+			switch {
+			case scoped && len(props) > 0 && item != nil:
+				nested := newMicroItem(child)
+				walk(child, nested)
+				for _, prop := range props {
+					item.Children[prop] = append(item.Children[prop], nested)
+					if name := nested.first("name"); name != "" {
+						item.Props[prop] = append(item.Props[prop], name)
+					}
+				}
+			case scoped:
+				top := newMicroItem(child)
+				items = append(items, top)
+				walk(child, top)
+			default:
+				if item != nil {
+					for _, prop := range props {
+						if value := microdataValue(child); value != "" {
+							item.Props[prop] = append(item.Props[prop], value)
+						}
+					}
+				}
+				walk(child, item)
+			}
+		}
+	}
+	walk(root, nil)
+	return items
+}
+
+func (item *microItem) first(prop string) string {
+	if values := item.Props[prop]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// microdataValue reads a property value the way the microdata spec does
+func microdataValue(node *html.Node) string {
+	if content, ok := attrLookup(node, "content"); ok {
+		return strings.TrimSpace(content)
+	}
+	switch node.DataAtom {
+	case atom.A, atom.Link, atom.Area:
+		return attrValue(node, "href")
+	case atom.Img, atom.Audio, atom.Video, atom.Source, atom.Embed, atom.Iframe:
+		return attrValue(node, "src")
+	case atom.Time:
+		if datetime := attrValue(node, "datetime"); datetime != "" {
+			return datetime
+		}
+	case atom.Data, atom.Meter:
+		return attrValue(node, "value")
+	}
+	return nodeText(node)
+}
+
+func attrLookup(node *html.Node, key string) (string, bool) {
+	for _, attr := range node.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func mainMicrodataItem(items []*microItem) *microItem {
+	var main *microItem
+	mainRank := len(mainEntityTypes)
+	for _, item := range items {
+		if rank := mainEntityRank([]string{item.Type}); rank < mainRank {
+			main, mainRank = item, rank
+		}
+	}
+	return main
+}
+
+// applyMicrodata fills the fields JSON-LD left empty
+func applyMicrodata(sd *StructuredData, item *microItem) {
+	if sd.Type == "" {
+		sd.Type = item.Type
+	}
+	if sd.Name == "" {
+		sd.Name = firstNonEmpty(item.first("headline"), item.first("name"))
+	}
+	if sd.Description == "" {
+		sd.Description = item.first("description")
+	}
+	if len(sd.Authors) == 0 {
+		sd.Authors = item.Props["author"]
+	}
+	if sd.Publisher == "" {
+		sd.Publisher = item.first("publisher")
+	}
+	if sd.Published == nil {
+		sd.Published = parseStructuredDate(firstNonEmpty(item.first("datePublished"), item.first("dateCreated")))
+	}
+	if sd.Modified == nil {
+		sd.Modified = parseStructuredDate(item.first("dateModified"))
+	}
+	if sd.Image == "" {
+		sd.Image = item.first("image")
+	}
+}
+
+// applyOpenGraph fills the fields schema.org data left empty
+func applyOpenGraph(sd *StructuredData, tags map[string]string) {
+	if sd.Type == "" {
+		sd.Type = tags["og:type"]
+	}
+	if sd.Name == "" {
+		sd.Name = firstNonEmpty(tags["og:title"], tags["twitter:title"])
+	}
+	if sd.Description == "" {
+		sd.Description = firstNonEmpty(tags["og:description"], tags["twitter:description"])
+	}
+	if len(sd.Authors) == 0 {
+		if author := firstNonEmpty(tags["article:author"], tags["twitter:creator"]); author != "" {
+			sd.Authors = []string{author}
+		}
+	}
+	if sd.Publisher == "" {
+		sd.Publisher = tags["og:site_name"]
+	}
+	if sd.Published == nil {
+		sd.Published = parseStructuredDate(tags["article:published_time"])
+	}
+	if sd.Modified == nil {
+		sd.Modified = parseStructuredDate(firstNonEmpty(tags["article:modified_time"], tags["og:updated_time"]))
+	}
+	if sd.Image == "" {
+		sd.Image = firstNonEmpty(tags["og:image"], tags["twitter:image"])
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// structuredDateLayouts are the date formats seen in the wild, ISO 8601 first
+var structuredDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// parseStructuredDate returns nil for empty or unparseable dates
+func parseStructuredDate(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	for _, layout := range structuredDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = t.UTC()
+			return &t
+		}
+	}
+	return nil
+}
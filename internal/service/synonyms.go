@@ -0,0 +1,256 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queryTerm is an analyzed query term with its scoring weight. A multi-word
+// synonym is a single queryTerm whose Phrase holds its terms, to be matched as
+// a sequence.
+type queryTerm struct {
+	Term   string
+	Phrase []string
+	Weight float64
+}
+
+// synonymRule maps an analyzed term sequence to its alternatives
+type synonymRule struct {
+	match      []string
+	expansions [][]string
+}
+
+// SynonymEntry is a human readable view of a loaded rule
+type SynonymEntry struct {
+	Match      string   `json:"match"`
+	Expansions []string `json:"expansions"`
+}
+
+// SynonymSet expands query terms using a Solr-format synonyms file:
+//
+//	k8s, kubernetes          equivalent terms, each expands to the others
+//	db => database           one-way mapping, only the left side is expanded
+//	new york, ny, nyc        multi-word entries are matched as term sequences
+type SynonymSet struct {
+	mu       sync.RWMutex
+	path     string
+	modTime  time.Time
+	loadedAt time.Time
+	rules    map[string][]synonymRule // keyed by the first term of the match
+	entries  []SynonymEntry
+}
+
+// Synonyms is the synonym set applied to every query
+var Synonyms = &SynonymSet{rules: make(map[string][]synonymRule)}
+
+// Load reads a synonyms file and atomically replaces the active rules
+func (s *SynonymSet) Load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("reading synonyms: %w", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading synonyms: %w", err)
+	}
+	defer f.Close()
+
+	rules := make(map[string][]synonymRule)
+	var entries []SynonymEntry
+	addRule := func(from []string, to [][]string) {
+		if len(from) == 0 || len(to) == 0 {
+			return
+		}
+		rules[from[0]] = append(rules[from[0]], synonymRule{match: from, expansions: to})
+
+		entry := SynonymEntry{Match: strings.Join(from, " ")}
+		for _, exp := range to {
+			entry.Expansions = append(entry.Expansions, strings.Join(exp, " "))
+		}
+		entries = append(entries, entry)
+	}
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lhs, rhs, oneWay := strings.Cut(line, "=>")
+		from := analyzeSynonymList(lhs)
+		if oneWay {
+			to := analyzeSynonymList(rhs)
+			if len(from) == 0 || len(to) == 0 {
+				return fmt.Errorf("synonyms %s:%d: empty side in mapping", path, lineNo)
+			}
+			for _, phrase := range from {
+				addRule(phrase, to)
+			}
+			continue
+		}
+
+		if len(from) < 2 {
+			return fmt.Errorf("synonyms %s:%d: equivalence needs at least two entries", path, lineNo)
+		}
+		for i, phrase := range from {
+			others := make([][]string, 0, len(from)-1)
+			others = append(others, from[:i]...)
+			others = append(others, from[i+1:]...)
+			addRule(phrase, others)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading synonyms: %w", err)
+	}
+
+	// Prefer the longest match when several rules start with the same term
+	for first := range rules {
+		sort.SliceStable(rules[first], func(i, j int) bool {
+			return len(rules[first][i].match) > len(rules[first][j].match)
+		})
+	}
+
+	s.mu.Lock()
+	s.path = path
+	s.modTime = info.ModTime()
+	s.loadedAt = time.Now()
+	s.rules = rules
+	s.entries = entries
+	s.mu.Unlock()
+
+	log.Printf("Loaded %d synonym rules from %s", len(entries), path)
+	return nil
+}
+
+// analyzeSynonymList splits a comma separated side of a rule into analyzed phrases
+func analyzeSynonymList(list string) [][]string {
+	var phrases [][]string
+	for _, raw := range strings.Split(list, ",") {
+		if terms := Tokenize(raw); len(terms) > 0 {
+			phrases = append(phrases, terms)
+		}
+	}
+	return phrases
+}
+
+// Reload re-reads the current synonyms file
+func (s *SynonymSet) Reload() error {
+	s.mu.RLock()
+	path := s.path
+	s.mu.RUnlock()
+
+	if path == "" {
+		return fmt.Errorf("no synonyms file configured")
+	}
+	if err := s.Load(path); err != nil {
+		return err
+	}
+
+	// Cached results were expanded with the previous rules
+	InvertedIndex.ClearCache()
+	return nil
+}
+
+// Watch reloads the synonyms file at path whenever its modification time changes
+func (s *SynonymSet) Watch(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.RLock()
+		modTime := s.modTime
+		s.mu.RUnlock()
+
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(modTime) {
+			continue
+		}
+		if err := s.Load(path); err != nil {
+			log.Printf("Failed to reload synonyms: %v", err)
+			continue
+		}
+		InvertedIndex.ClearCache()
+	}
+}
+
+// Expand returns the deduplicated query terms followed by their synonyms. Original
+// terms keep weight 1, expansions get weight so they rank below exact matches.
+// Multi-word expansions are returned as phrases.
+func (s *SynonymSet) Expand(terms []string, weight float64) []queryTerm {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	weights := make(map[string]float64, len(terms))
+	order := make([]string, 0, len(terms))
+	phrases := make(map[string][]string)
+	add := func(term string, w float64) {
+		current, seen := weights[term]
+		if !seen {
+			order = append(order, term)
+		}
+		if w > current {
+			weights[term] = w
+		}
+	}
+
+	for _, term := range terms {
+		add(term, 1)
+	}
+
+	for i := range terms {
+		for _, rule := range s.rules[terms[i]] {
+			if !hasPrefixTerms(terms[i:], rule.match) {
+				continue
+			}
+			for _, expansion := range rule.expansions {
+				term := strings.Join(expansion, " ")
+				if len(expansion) > 1 {
+					phrases[term] = expansion
+				}
+				add(term, weight)
+			}
+			break
+		}
+	}
+
+	expanded := make([]queryTerm, len(order))
+	for i, term := range order {
+		expanded[i] = queryTerm{Term: term, Phrase: phrases[term], Weight: weights[term]}
+	}
+	return expanded
+}
+
+func hasPrefixTerms(terms, prefix []string) bool {
+	if len(prefix) > len(terms) {
+		return false
+	}
+	for i := range prefix {
+		if terms[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Info describes the active synonym set for the admin API
+func (s *SynonymSet) Info() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return map[string]interface{}{
+		"path":      s.path,
+		"loaded_at": s.loadedAt,
+		"rules":     len(s.entries),
+		"entries":   s.entries,
+	}
+}
@@ -1,15 +1,28 @@
 package service
 
 import (
+	"fmt"
+	"html"
+	"os"
+	"regexp"
 	"strings"
 	"unicode"
 
 	"github.com/kljensen/snowball"
+	"github.com/kljensen/snowball/english"
+	"github.com/kljensen/snowball/french"
+	"github.com/kljensen/snowball/hungarian"
+	"github.com/kljensen/snowball/norwegian"
+	"github.com/kljensen/snowball/russian"
+	"github.com/kljensen/snowball/spanish"
+	"github.com/kljensen/snowball/swedish"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
 )
 
 // Helper to stem words eg. running -> run
-func wordStemmer(word string) (string, error) {
-	return snowball.Stem(word, "english", true)
+func wordStemmer(word, language string) (string, error) {
+	return snowball.Stem(word, language, true)
 }
 
 func tokenDeduper(tokens []string) []string {
@@ -25,24 +38,424 @@ func tokenDeduper(tokens []string) []string {
 	return dedupedTokenList
 }
 
+// Tokenize analyzes text with the body field analyzer, so indexing and querying
+// always agree on terms
 func Tokenize(doc string) []string {
-	doc = strings.ToLower(doc)
-	var cleanedDoc strings.Builder
+	return AnalyzerFor(FieldBody).Terms(doc)
+}
+
+// Factories used to build analyzers from config, keyed by component type
+var charFilterFactories = map[string]func(componentSpec) (CharFilter, error){
+	"nfkc":              func(componentSpec) (CharFilter, error) { return nfkcFilter{}, nil },
+	"strip_punctuation": func(componentSpec) (CharFilter, error) { return stripPunctuationFilter{}, nil },
+	"html_strip":        func(componentSpec) (CharFilter, error) { return htmlStripFilter{}, nil },
+	"mapping":           newMappingCharFilter,
+}
+
+var tokenizerFactories = map[string]func(componentSpec) (Tokenizer, error){
+	"standard":   func(componentSpec) (Tokenizer, error) { return standardTokenizer{}, nil },
+	"whitespace": func(componentSpec) (Tokenizer, error) { return whitespaceTokenizer{}, nil },
+	"keyword":    func(componentSpec) (Tokenizer, error) { return keywordTokenizer{}, nil },
+	"code":       func(componentSpec) (Tokenizer, error) { return codeTokenizer{}, nil },
+}
+
+var tokenFilterFactories = map[string]func(componentSpec) (TokenFilter, error){
+	"lowercase":     func(componentSpec) (TokenFilter, error) { return lowercaseFilter{}, nil },
+	"case_fold":     func(componentSpec) (TokenFilter, error) { return caseFoldFilter{}, nil },
+	"ascii_folding": newASCIIFoldingFilter,
+	"stop":          newStopFilter,
+	"stemmer":       newStemmerFilter,
+	"length":        newLengthFilter,
+	"ngram":         newNgramFilter,
+}
+
+// nfkcFilter applies Unicode NFKC normalization: composed and decomposed accents
+// become the same runes, full-width forms become their ASCII counterparts and
+// typographic ligatures such as "ﬁ" are expanded
+type nfkcFilter struct{}
+
+func (nfkcFilter) Filter(text string) string {
+	return norm.NFKC.String(text)
+}
+
+// stripPunctuationFilter drops every rune that isn't part of a word or a space
+type stripPunctuationFilter struct{}
+
+func (stripPunctuationFilter) Filter(text string) string {
+	var cleaned strings.Builder
+	cleaned.Grow(len(text))
+	for _, r := range text {
+		if isWordRune(r) || unicode.IsSpace(r) {
+			cleaned.WriteRune(r)
+		}
+	}
+	return cleaned.String()
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// htmlStripFilter removes markup and decodes entities
+type htmlStripFilter struct{}
+
+func (htmlStripFilter) Filter(text string) string {
+	return html.UnescapeString(htmlTagPattern.ReplaceAllString(text, " "))
+}
+
+// mappingCharFilter replaces literal strings, e.g. {"mappings": {"C++": "cplusplus"}}
+type mappingCharFilter struct {
+	replacer *strings.Replacer
+}
+
+func newMappingCharFilter(spec componentSpec) (CharFilter, error) {
+	mappings, _ := spec.Params["mappings"].(map[string]interface{})
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("\"mappings\" is required")
+	}
+
+	pairs := make([]string, 0, len(mappings)*2)
+	for from, to := range mappings {
+		toStr, ok := to.(string)
+		if !ok {
+			return nil, fmt.Errorf("mapping for %q must be a string", from)
+		}
+		pairs = append(pairs, from, toStr)
+	}
+	return mappingCharFilter{replacer: strings.NewReplacer(pairs...)}, nil
+}
+
+func (f mappingCharFilter) Filter(text string) string {
+	return f.replacer.Replace(text)
+}
+
+// whitespaceTokenizer splits on runs of whitespace, then segments scripts
+// written without spaces
+type whitespaceTokenizer struct{}
+
+func (whitespaceTokenizer) Tokenize(text string) []Token {
+	return segmentWords(strings.Fields(text))
+}
+
+// standardTokenizer splits on anything that isn't part of a word, then segments
+// scripts written without spaces
+type standardTokenizer struct{}
+
+func (standardTokenizer) Tokenize(text string) []Token {
+	return segmentWords(strings.FieldsFunc(text, func(r rune) bool {
+		return !isWordRune(r)
+	}))
+}
+
+// keywordTokenizer emits the whole input as a single token
+type keywordTokenizer struct{}
+
+func (keywordTokenizer) Tokenize(text string) []Token {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	return []Token{{Term: text}}
+}
+
+// positionTokens numbers terms sequentially
+func positionTokens(terms []string) []Token {
+	tokens := make([]Token, len(terms))
+	for i, term := range terms {
+		tokens[i] = Token{Term: term, Position: i}
+	}
+	return tokens
+}
+
+type lowercaseFilter struct{}
+
+func (lowercaseFilter) Filter(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = strings.ToLower(tokens[i].Term)
+	}
+	return tokens
+}
+
+// caseFoldFilter applies full Unicode case folding, which unlike lowercasing
+// also maps "ß" to "ss" and final sigma to sigma
+type caseFoldFilter struct{}
+
+func (caseFoldFilter) Filter(tokens []Token) []Token {
+	// A Caser keeps state, so each call gets its own
+	folder := cases.Fold()
+	for i := range tokens {
+		tokens[i].Term = folder.String(tokens[i].Term)
+	}
+	return tokens
+}
+
+// asciiFoldingFilter strips diacritics from Latin and Greek letters and maps
+// letters without a decomposition (ø, ł, ß, æ...) to their closest ASCII
+// spelling. With preserve_original the accented form is kept alongside the
+// folded one, at the same position, so exact-accent matches score higher.
+type asciiFoldingFilter struct {
+	preserveOriginal bool
+}
+
+func newASCIIFoldingFilter(spec componentSpec) (TokenFilter, error) {
+	return asciiFoldingFilter{preserveOriginal: spec.Bool("preserve_original", false)}, nil
+}
+
+var foldingReplacer = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "Æ", "AE", "œ", "oe", "Œ", "OE",
+	"ø", "o", "Ø", "O", "ł", "l", "Ł", "L", "đ", "d", "Đ", "D",
+	"ð", "d", "Ð", "D", "þ", "th", "Þ", "TH", "ı", "i",
+)
+
+// foldTerm removes the marks of Latin and Greek letters only, other scripts
+// such as Japanese use them to tell different letters apart
+func foldTerm(term string) string {
+	var folded strings.Builder
+	foldMarks := false
+	for _, r := range norm.NFD.String(term) {
+		if unicode.IsMark(r) {
+			if foldMarks {
+				continue
+			}
+		} else {
+			foldMarks = unicode.In(r, unicode.Latin, unicode.Greek)
+		}
+		folded.WriteRune(r)
+	}
+	return norm.NFC.String(foldingReplacer.Replace(folded.String()))
+}
+
+func (f asciiFoldingFilter) Filter(tokens []Token) []Token {
+	if !f.preserveOriginal {
+		for i := range tokens {
+			tokens[i].Term = foldTerm(tokens[i].Term)
+		}
+		return tokens
+	}
+
+	folded := make([]Token, 0, len(tokens))
+	for _, token := range tokens {
+		folded = append(folded, Token{Term: foldTerm(token.Term), Position: token.Position})
+		if folded[len(folded)-1].Term != token.Term {
+			folded = append(folded, token)
+		}
+	}
+	return folded
+}
+
+// stopWordFuncs are the stopword lists shipped with the Snowball stemmers
+var stopWordFuncs = map[string]func(string) bool{
+	"english":   english.IsStopWord,
+	"french":    french.IsStopWord,
+	"hungarian": hungarian.IsStopWord,
+	"norwegian": norwegian.IsStopWord,
+	"russian":   russian.IsStopWord,
+	"spanish":   spanish.IsStopWord,
+	"swedish":   swedish.IsStopWord,
+}
+
+// stopwordsFor returns the stopword test of a language: the list configured for
+// it in the analysis config, otherwise the Snowball list
+func stopwordsFor(language string) (func(string) bool, bool) {
+	analyzersMu.RLock()
+	list, ok := stopwordLists[language]
+	analyzersMu.RUnlock()
+	if ok {
+		return func(term string) bool { return list[term] }, true
+	}
+
+	isStopWord, ok := stopWordFuncs[language]
+	return isStopWord, ok
+}
 
-	for _, r := range doc {
-		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
-			cleanedDoc.WriteRune(r)
+// readWordList reads a word list file with one word per line; blank lines and
+// lines starting with '#' are skipped
+func readWordList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading word list: %w", err)
+	}
+
+	var words []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
+		words = append(words, line)
+	}
+	return words, nil
+}
+
+// wordSet case folds words into a set, so lists match analyzed terms
+func wordSet(words []string) map[string]bool {
+	folder := cases.Fold()
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[folder.String(strings.TrimSpace(word))] = true
 	}
+	return set
+}
+
+// stopFilter removes stopwords, leaving a gap at their positions. It uses its
+// own word list, the list of its language, or both. Unless a language is
+// configured it follows the language of the analyzed text.
+type stopFilter struct {
+	language    string
+	useLanguage bool
+	words       map[string]bool
+	pinned      bool
+}
 
-	rawTokens := strings.Fields(cleanedDoc.String())
-	tokens := make([]string, len(rawTokens))
-	for idx, token := range rawTokens {
-		stemmedToken, err := wordStemmer(token)
+func newStopFilter(spec componentSpec) (TokenFilter, error) {
+	words := spec.Strings("words")
+	if path := spec.String("words_path", ""); path != "" {
+		fileWords, err := readWordList(path)
 		if err != nil {
-			stemmedToken = token
+			return nil, err
+		}
+		words = append(words, fileWords...)
+	}
+	f := stopFilter{words: wordSet(words)}
+
+	// A custom word list without a language is used on its own
+	_, hasLanguage := spec.Params["language"]
+	f.pinned = hasLanguage || len(f.words) > 0
+	if len(f.words) == 0 || hasLanguage {
+		f.language = spec.String("language", DefaultLanguage)
+		f.useLanguage = true
+		if _, ok := stopwordsFor(f.language); !ok {
+			return nil, fmt.Errorf("no stopword list for language %q", f.language)
+		}
+	}
+	return f, nil
+}
+
+func (f stopFilter) forLanguage(language string) TokenFilter {
+	if f.pinned {
+		return f
+	}
+	if _, ok := stopwordsFor(language); ok {
+		f.language = language
+	}
+	return f
+}
+
+func (f stopFilter) Filter(tokens []Token) []Token {
+	kept, _ := f.split(tokens)
+	return kept
+}
+
+func (f stopFilter) split(tokens []Token) (kept, stopped []Token) {
+	var isStopWord func(string) bool
+	if f.useLanguage {
+		isStopWord, _ = stopwordsFor(f.language)
+	}
+
+	kept = tokens[:0]
+	for _, token := range tokens {
+		if f.words[token.Term] || (isStopWord != nil && isStopWord(token.Term)) {
+			stopped = append(stopped, token)
+			continue
+		}
+		kept = append(kept, token)
+	}
+	return kept, stopped
+}
+
+// stemmerFilter reduces terms to their Snowball stem. Unless a language is
+// configured it follows the language of the analyzed text.
+type stemmerFilter struct {
+	language string
+	pinned   bool
+}
+
+func newStemmerFilter(spec componentSpec) (TokenFilter, error) {
+	_, pinned := spec.Params["language"]
+	language := spec.String("language", DefaultLanguage)
+	if _, err := wordStemmer("test", language); err != nil {
+		return nil, err
+	}
+	return stemmerFilter{language: language, pinned: pinned}, nil
+}
+
+func (f stemmerFilter) forLanguage(language string) TokenFilter {
+	if f.pinned {
+		return f
+	}
+	if _, err := wordStemmer("test", language); err != nil {
+		return f
+	}
+	f.language = language
+	return f
+}
+
+func (f stemmerFilter) Filter(tokens []Token) []Token {
+	for i := range tokens {
+		if stemmed, err := wordStemmer(tokens[i].Term, f.language); err == nil {
+			tokens[i].Term = stemmed
 		}
-		tokens[idx] = stemmedToken
 	}
 	return tokens
 }
+
+// lengthFilter drops terms shorter than min or longer than max runes
+type lengthFilter struct {
+	min, max int
+}
+
+func newLengthFilter(spec componentSpec) (TokenFilter, error) {
+	f := lengthFilter{min: spec.Int("min", 0), max: spec.Int("max", 0)}
+	if f.max > 0 && f.max < f.min {
+		return nil, fmt.Errorf("max must not be smaller than min")
+	}
+	return f, nil
+}
+
+func (f lengthFilter) Filter(tokens []Token) []Token {
+	kept := tokens[:0]
+	for _, token := range tokens {
+		n := len([]rune(token.Term))
+		if n < f.min || (f.max > 0 && n > f.max) {
+			continue
+		}
+		kept = append(kept, token)
+	}
+	return kept
+}
+
+// ngramFilter replaces each term with its character n-grams, all at the term's position
+type ngramFilter struct {
+	min, max         int
+	preserveOriginal bool
+}
+
+func newNgramFilter(spec componentSpec) (TokenFilter, error) {
+	f := ngramFilter{
+		min:              spec.Int("min", 2),
+		max:              spec.Int("max", 3),
+		preserveOriginal: spec.Bool("preserve_original", false),
+	}
+	if f.min < 1 || f.max < f.min {
+		return nil, fmt.Errorf("need 1 <= min <= max")
+	}
+	return f, nil
+}
+
+func (f ngramFilter) Filter(tokens []Token) []Token {
+	grams := make([]Token, 0, len(tokens))
+	for _, token := range tokens {
+		runes := []rune(token.Term)
+		if f.preserveOriginal || len(runes) < f.min {
+			grams = append(grams, token)
+		}
+		for n := f.min; n <= f.max; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				gram := string(runes[i : i+n])
+				if f.preserveOriginal && gram == token.Term {
+					continue
+				}
+				grams = append(grams, Token{Term: gram, Position: token.Position})
+			}
+		}
+	}
+	return grams
+}
@@ -0,0 +1,403 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WARC record types
+const (
+	WARCInfo     = "warcinfo"
+	WARCRequest  = "request"
+	WARCResponse = "response"
+	WARCResource = "resource"
+)
+
+// WARCRecord is one record of a WARC file with its block read into memory
+type WARCRecord struct {
+	Header textproto.MIMEHeader
+	Block  []byte
+}
+
+// Type is the record's WARC-Type
+func (r *WARCRecord) Type() string {
+	return r.Header.Get("WARC-Type")
+}
+
+// TargetURI is the URL the record was captured from
+func (r *WARCRecord) TargetURI() string {
+	return strings.Trim(r.Header.Get("WARC-Target-URI"), "<>")
+}
+
+// WARCWriter appends records to WARC files, gzipping each record separately
+// as archive tools expect, and starts a new file once maxBytes is reached
+type WARCWriter struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	file    *os.File
+	written int64
+}
+
+// NewWARCWriter writes WARC files into dir. Files are only created once the
+// first record is written.
+func NewWARCWriter(dir string, maxBytes int64) (*WARCWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating WARC directory: %w", err)
+	}
+	return &WARCWriter{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// WriteExchange archives a fetched response and the request that fetched it.
+// Go decompresses response bodies, so the archived headers are adjusted to
+// describe the body that is written.
+func (w *WARCWriter) WriteExchange(resp *http.Response, body []byte) error {
+	targetURI := resp.Request.URL.String()
+	date := time.Now().UTC()
+
+	var request bytes.Buffer
+	fmt.Fprintf(&request, "%s %s HTTP/1.1\r\nHost: %s\r\n", resp.Request.Method, resp.Request.URL.RequestURI(), resp.Request.URL.Host)
+	resp.Request.Header.Write(&request)
+	request.WriteString("\r\n")
+
+	header := resp.Header.Clone()
+	if resp.Uncompressed {
+		header.Del("Content-Encoding")
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	var response bytes.Buffer
+	fmt.Fprintf(&response, "HTTP/%d.%d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+	header.Write(&response)
+	response.WriteString("\r\n")
+	response.Write(body)
+
+	responseID := newWARCRecordID()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.rotate(); err != nil {
+		return err
+	}
+	if err := w.writeRecord([]warcField{
+		{"WARC-Type", WARCResponse},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", date.Format(time.RFC3339)},
+		{"WARC-Target-URI", targetURI},
+		{"WARC-Payload-Digest", warcDigest(body)},
+		{"Content-Type", "application/http;msgtype=response"},
+	}, response.Bytes()); err != nil {
+		return err
+	}
+	return w.writeRecord([]warcField{
+		{"WARC-Type", WARCRequest},
+		{"WARC-Record-ID", newWARCRecordID()},
+		{"WARC-Date", date.Format(time.RFC3339)},
+		{"WARC-Target-URI", targetURI},
+		{"WARC-Concurrent-To", responseID},
+		{"Content-Type", "application/http;msgtype=request"},
+	}, request.Bytes())
+}
+
+// rotate opens a new file, starting with a warcinfo record, when there is no
+// current file or it is full. w.mu must be held.
+func (w *WARCWriter) rotate() error {
+	if w.file != nil && w.written < w.maxBytes {
+		return nil
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+
+	name := fmt.Sprintf("crawl-%s-%s.warc.gz", time.Now().UTC().Format("20060102150405"), newWARCRecordID()[len("<urn:uuid:"):][:8])
+	f, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return fmt.Errorf("creating WARC file: %w", err)
+	}
+	w.file, w.written = f, 0
+	log.Printf("Writing crawl archive %s", name)
+
+	return w.writeRecord([]warcField{
+		{"WARC-Type", WARCInfo},
+		{"WARC-Record-ID", newWARCRecordID()},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}, []byte("software: IndexStream-v2\r\nformat: WARC File Format 1.1\r\n"))
+}
+
+// warcField is a named record header, written in the order given
+type warcField struct {
+	name, value string
+}
+
+// writeRecord writes one record as its own gzip member. w.mu must be held.
+func (w *WARCWriter) writeRecord(header []warcField, block []byte) error {
+	var record bytes.Buffer
+	gz := gzip.NewWriter(&record)
+	fmt.Fprintf(gz, "WARC/1.1\r\n")
+	for _, field := range header {
+		fmt.Fprintf(gz, "%s: %s\r\n", field.name, field.value)
+	}
+	fmt.Fprintf(gz, "WARC-Block-Digest: %s\r\nContent-Length: %d\r\n\r\n", warcDigest(block), len(block))
+	gz.Write(block)
+	gz.Write([]byte("\r\n\r\n"))
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	n, err := w.file.Write(record.Bytes())
+	w.written += int64(n)
+	if err != nil {
+		return fmt.Errorf("writing WARC record: %w", err)
+	}
+	return nil
+}
+
+// Close closes the current file
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func newWARCRecordID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// maxWARCBlock bounds the records read into memory, larger ones are skipped
+const maxWARCBlock = 256 << 20
+
+// ReadWARC calls fn for every record of a WARC file, which may be gzipped
+func ReadWARC(r io.Reader, fn func(*WARCRecord) error) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("opening gzip stream: %w", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+	tp := textproto.NewReader(br)
+
+	for {
+		// Records are separated by blank lines
+		line, err := tp.ReadLine()
+		for err == nil && line == "" {
+			line, err = tp.ReadLine()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading WARC record: %w", err)
+		}
+		if !strings.HasPrefix(line, "WARC/") {
+			return fmt.Errorf("expected a WARC version line, got %q", line)
+		}
+
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return fmt.Errorf("reading WARC headers: %w", err)
+		}
+		length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("invalid WARC Content-Length %q", header.Get("Content-Length"))
+		}
+		if length > maxWARCBlock {
+			log.Printf("Skipping WARC record of %d bytes for %s", length, header.Get("WARC-Target-URI"))
+			if _, err := io.CopyN(io.Discard, br, length); err != nil {
+				return fmt.Errorf("reading WARC block: %w", err)
+			}
+			continue
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(br, block); err != nil {
+			return fmt.Errorf("reading WARC block: %w", err)
+		}
+
+		if err := fn(&WARCRecord{Header: header, Block: block}); err != nil {
+			return err
+		}
+	}
+}
+
+// archivedDocument returns the URL, body and response details a response or
+// resource record captured. ok is false for other records and for responses
+// that aren't 200 OK.
+func archivedDocument(rec *WARCRecord) (url string, body []byte, info *FetchInfo, ok bool, err error) {
+	url = rec.TargetURI()
+	switch rec.Type() {
+	case WARCResource:
+		return url, rec.Block, &FetchInfo{ContentType: rec.Header.Get("Content-Type")}, true, nil
+
+	case WARCResponse:
+		if !strings.HasPrefix(rec.Header.Get("Content-Type"), "application/http") {
+			return "", nil, nil, false, nil
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rec.Block)), nil)
+		if err != nil {
+			return "", nil, nil, false, fmt.Errorf("parsing archived response for %s: %w", url, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", nil, nil, false, nil
+		}
+
+		// Archives keep bodies as they were sent, possibly compressed
+		var reader io.Reader = resp.Body
+		switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				return "", nil, nil, false, fmt.Errorf("decompressing %s: %w", url, err)
+			}
+			reader = gz
+		case "deflate":
+			reader = flate.NewReader(resp.Body)
+		default:
+			return "", nil, nil, false, fmt.Errorf("unsupported content encoding %q for %s", resp.Header.Get("Content-Encoding"), url)
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil && len(body) == 0 {
+			return "", nil, nil, false, fmt.Errorf("reading archived body of %s: %w", url, err)
+		}
+		return url, body, &FetchInfo{
+			ContentType:     resp.Header.Get("Content-Type"),
+			ContentLanguage: resp.Header.Get("Content-Language"),
+		}, true, nil
+	}
+	return "", nil, nil, false, nil
+}
+
+// WARCStats counts what ImportWARC did
+type WARCStats struct {
+	Records  int `json:"records"`
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // not a 200 response or an unsupported content type
+	Failed   int `json:"failed"`
+	Other    int `json:"other"` // request, warcinfo and other records without a document
+}
+
+// ImportWARC stores the documents archived in a WARC file in the data
+// directory, as if they had just been crawled, and indexes them into idx.
+// With a nil idx only the stored snapshot is updated, as with IndexDirectory.
+func ImportWARC(idx *Index, path string) (*WARCStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := &WARCStats{}
+	err = ReadWARC(f, func(rec *WARCRecord) error {
+		stats.Records++
+		if t := rec.Type(); t != WARCResponse && t != WARCResource {
+			stats.Other++
+			return nil
+		}
+		url, body, info, ok, err := archivedDocument(rec)
+		if err != nil {
+			log.Printf("Skipping record %d: %v", stats.Records, err)
+			stats.Failed++
+			return nil
+		}
+		if !ok || url == "" {
+			stats.Skipped++
+			return nil
+		}
+
+		contentType := DetectContentType(info.ContentType, url, body)
+		if ExtractorFor(contentType) == nil {
+			stats.Skipped++
+			return nil
+		}
+		_, info.Charset = decodeCharset(body, info.ContentType, contentType)
+
+		doc, _, err := analyzeAndStore(documentID(url), url, body, info, nil)
+		if err != nil {
+			log.Printf("Skipping %s: %v", url, err)
+			stats.Failed++
+			return nil
+		}
+		if idx != nil {
+			idx.replaceDocuments([]*analyzedDocument{doc})
+			recordIndexed(idx, doc.docID, len(doc.fields[FieldBody]))
+		}
+		stats.Imported++
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	log.Printf("Imported %s: %d of %d records, %d skipped, %d failed, %d without a document",
+		path, stats.Imported, stats.Records, stats.Skipped, stats.Failed, stats.Other)
+	return stats, nil
+}
+
+var (
+	crawlArchive     *WARCWriter
+	crawlArchiveOnce sync.Once
+)
+
+// archiveResponse writes a crawled response to the crawl archive when a WARC
+// directory is configured
+func archiveResponse(resp *http.Response, body []byte) {
+	if cfg.WARCDir == "" {
+		return
+	}
+	crawlArchiveOnce.Do(func() {
+		w, err := NewWARCWriter(cfg.WARCDir, cfg.WARCMaxBytes)
+		if err != nil {
+			log.Printf("Crawl archive disabled: %v", err)
+			return
+		}
+		crawlArchive = w
+	})
+	if crawlArchive == nil {
+		return
+	}
+	if err := crawlArchive.WriteExchange(resp, body); err != nil {
+		log.Printf("Error archiving %s: %v", resp.Request.URL, err)
+	}
+}
+
+// CloseCrawlArchive finishes the crawl archive's current file
+func CloseCrawlArchive() {
+	if crawlArchive != nil {
+		crawlArchive.Close()
+	}
+}